@@ -1,8 +1,13 @@
 package configs
 
 import (
+	"crypto/rand"
+	"fmt"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/rs/zerolog/log"
@@ -24,18 +29,71 @@ type Config struct {
 	AccessCheckToken         *string
 	AccessTokenCacheLifeTime int
 	MaximumFileSize          int
+	NoteRevisionsLimit       int           // Maximum number of stored revisions per note
+	NoteRevisionsMaxAge      time.Duration // Revisions older than this will be removed
+	SyncPageSize             int64         // Number of changed notes returned per cursor sync request
+	FilesGCSchedule          string        // Cron spec of the job removing files which are not referenced by notes
+	FilesGCGracePeriod       time.Duration // Recently uploaded files are kept, their notes could be not synced yet
+	StorageDriver            string        // Media storage driver: local or s3
+	S3Endpoint               string
+	S3Region                 string
+	S3Bucket                 string
+	S3AccessKey              string
+	S3SecretKey              string
+	S3UseSSL                 bool
+	MediaURLExpiration       time.Duration // Lifetime of presigned and signed media urls
+	MediaSigningKey          []byte        // HMAC key of signed media urls
+	UploadMaxSize            int64         // Maximum size of file uploaded by resumable upload
+	UploadExpiration         time.Duration // Unfinished resumable uploads are removed after this period of inactivity
+	ThumbnailWidths          []int         // Widths of generated image thumbnails in ascending order
+	UploadAllowedTypes       []string      // Allowed MIME types of uploaded files, type/* and * patterns are supported
+	LocalAuthEnabled         bool          // Allow login by email and password
+	RegistrationMode         string        // Registration of local accounts: open, invite or closed
+	SMTPHost                 string        // Emails are not sent and addresses are not verified when it's empty
+	SMTPPort                 string
+	SMTPUsername             string
+	SMTPPassword             string
+	SMTPFrom                 string
+	OIDCProviders            []OIDCProviderConfig
+	SessionLifetime          time.Duration // Session is expired after this period unless its token is rotated
+	TwoFactorRequired        bool          // Every user should enable TOTP two factor authentication to login
+	TwoFactorIssuer          string        // Name of the service shown by authenticator apps
+	AdminEmails              []string      // Local accounts with these verified emails get admin role on start
+	AdminUserIDs             []string      // Users with these ids get admin role on start
 
 	GithubClientOwner    string
 	GithubClientRepoName string
 }
 
-func (c *Config) BackendHost() string {
+func (c *Config) backendOrigin() string {
 	host := c.BackendSchema + "://" + c.BackendDomain
 	if c.BackendPort != "" {
 		host += ":" + c.BackendPort
 	}
+	return host
+}
+
+func (c *Config) BackendHost() string {
 	// TODO: master add version to environment and config
-	return host + "/v1"
+	return c.backendOrigin() + "/v1"
+}
+
+// Public url of the static media route of local storage
+func (c *Config) MediaURL() string {
+	return c.backendOrigin() + "/media"
+}
+
+func parseThumbnailWidths(value string) ([]int, error) {
+	widths := []int{}
+	for _, part := range strings.Split(value, ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("invalid thumbnail width: %s", part)
+		}
+		widths = append(widths, width)
+	}
+	sort.Ints(widths)
+	return widths, nil
 }
 
 // TODO: master split into several functions
@@ -102,6 +160,166 @@ func NewConfig() Config {
 		}
 	}
 
+	noteRevisionsLimit := 50
+	if envNoteRevisionsLimit := os.Getenv("NOTE_REVISIONS_LIMIT"); envNoteRevisionsLimit != "" {
+		val, err := strconv.Atoi(envNoteRevisionsLimit)
+		if err == nil {
+			noteRevisionsLimit = val
+		} else {
+			log.Warn().Msgf("NOTE_REVISIONS_LIMIT is not a number, init with default value: %d", noteRevisionsLimit)
+		}
+	}
+
+	noteRevisionsMaxAgeDays := 30
+	if envNoteRevisionsMaxAge := os.Getenv("NOTE_REVISIONS_MAX_AGE_DAYS"); envNoteRevisionsMaxAge != "" {
+		val, err := strconv.Atoi(envNoteRevisionsMaxAge)
+		if err == nil {
+			noteRevisionsMaxAgeDays = val
+		} else {
+			log.Warn().Msgf("NOTE_REVISIONS_MAX_AGE_DAYS is not a number, init with default value: %d", noteRevisionsMaxAgeDays)
+		}
+	}
+
+	syncPageSize := int64(100)
+	if envSyncPageSize := os.Getenv("SYNC_PAGE_SIZE"); envSyncPageSize != "" {
+		val, err := strconv.ParseInt(envSyncPageSize, 10, 64)
+		if err == nil && val > 0 {
+			syncPageSize = val
+		} else {
+			log.Warn().Msgf("SYNC_PAGE_SIZE is not a positive number, init with default value: %d", syncPageSize)
+		}
+	}
+
+	filesGCSchedule := "@daily"
+	if envFilesGCSchedule := os.Getenv("FILES_GC_SCHEDULE"); envFilesGCSchedule != "" {
+		filesGCSchedule = envFilesGCSchedule
+	}
+
+	filesGCGracePeriodHours := 24
+	if envFilesGCGracePeriod := os.Getenv("FILES_GC_GRACE_PERIOD_HOURS"); envFilesGCGracePeriod != "" {
+		val, err := strconv.Atoi(envFilesGCGracePeriod)
+		if err == nil && val >= 0 {
+			filesGCGracePeriodHours = val
+		} else {
+			log.Warn().Msgf("FILES_GC_GRACE_PERIOD_HOURS is not a number, init with default value: %d", filesGCGracePeriodHours)
+		}
+	}
+
+	uploadMaxSize := int64(1024 * 1024 * 1024)
+	if envUploadMaxSize := os.Getenv("UPLOAD_MAX_SIZE"); envUploadMaxSize != "" {
+		val, err := strconv.ParseInt(envUploadMaxSize, 10, 64)
+		if err == nil && val > 0 {
+			uploadMaxSize = val
+		} else {
+			log.Warn().Msgf("UPLOAD_MAX_SIZE is not a number, init with default value: %d", uploadMaxSize)
+		}
+	}
+
+	uploadExpirationHours := 24
+	if envUploadExpiration := os.Getenv("UPLOAD_EXPIRATION_HOURS"); envUploadExpiration != "" {
+		val, err := strconv.Atoi(envUploadExpiration)
+		if err == nil && val > 0 {
+			uploadExpirationHours = val
+		} else {
+			log.Warn().Msgf("UPLOAD_EXPIRATION_HOURS is not a number, init with default value: %d", uploadExpirationHours)
+		}
+	}
+
+	thumbnailWidths := []int{320, 640, 1280}
+	if envThumbnailWidths := os.Getenv("THUMBNAIL_WIDTHS"); envThumbnailWidths != "" {
+		widths, err := parseThumbnailWidths(envThumbnailWidths)
+		if err == nil {
+			thumbnailWidths = widths
+		} else {
+			log.Warn().Msgf("THUMBNAIL_WIDTHS is not a list of positive numbers, init with default value: %v", thumbnailWidths)
+		}
+	}
+
+	uploadAllowedTypes := []string{"image/*", "audio/*", "video/*", "text/plain", "text/csv", "application/pdf", "application/zip", "application/ogg"}
+	if envUploadAllowedTypes := os.Getenv("UPLOAD_ALLOWED_TYPES"); envUploadAllowedTypes != "" {
+		uploadAllowedTypes = []string{}
+		for _, mimeType := range strings.Split(envUploadAllowedTypes, ",") {
+			if mimeType = strings.TrimSpace(mimeType); mimeType != "" {
+				uploadAllowedTypes = append(uploadAllowedTypes, mimeType)
+			}
+		}
+	}
+
+	storageDriver := "local"
+	if envStorageDriver := os.Getenv("STORAGE_DRIVER"); envStorageDriver != "" {
+		storageDriver = envStorageDriver
+	}
+	if storageDriver != "local" && storageDriver != "s3" {
+		log.Fatal().Msgf("STORAGE_DRIVER should be local or s3, got: %s", storageDriver)
+	}
+
+	s3Bucket := os.Getenv("S3_BUCKET")
+	if storageDriver == "s3" && (os.Getenv("S3_ENDPOINT") == "" || s3Bucket == "") {
+		log.Fatal().Msg("S3_ENDPOINT or S3_BUCKET is not set")
+	}
+
+	s3Region := "us-east-1"
+	if envS3Region := os.Getenv("S3_REGION"); envS3Region != "" {
+		s3Region = envS3Region
+	}
+
+	mediaURLExpirationMinutes := 60
+	if envMediaURLExpiration := os.Getenv("MEDIA_URL_EXPIRATION_MINUTES"); envMediaURLExpiration != "" {
+		val, err := strconv.Atoi(envMediaURLExpiration)
+		if err == nil && val > 0 {
+			mediaURLExpirationMinutes = val
+		} else {
+			log.Warn().Msgf("MEDIA_URL_EXPIRATION_MINUTES is not a positive number, init with default value: %d", mediaURLExpirationMinutes)
+		}
+	}
+
+	mediaSigningKey := []byte(os.Getenv("MEDIA_SIGNING_KEY"))
+	if len(mediaSigningKey) == 0 {
+		log.Warn().Msg("MEDIA_SIGNING_KEY is not set, signed media urls will be invalid after restart")
+		mediaSigningKey = make([]byte, 32)
+		if _, err := rand.Read(mediaSigningKey); err != nil {
+			log.Fatal().Err(err).Msg("could not generate media signing key")
+		}
+	}
+
+	registrationMode := "open"
+	if envRegistrationMode := os.Getenv("REGISTRATION_MODE"); envRegistrationMode != "" {
+		registrationMode = envRegistrationMode
+	}
+	if registrationMode != "open" && registrationMode != "invite" && registrationMode != "closed" {
+		log.Fatal().Msgf("REGISTRATION_MODE should be open, invite or closed, got: %s", registrationMode)
+	}
+
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := "587"
+	if envSMTPPort := os.Getenv("SMTP_PORT"); envSMTPPort != "" {
+		smtpPort = envSMTPPort
+	}
+	smtpFrom := os.Getenv("SMTP_FROM")
+	if smtpHost != "" && smtpFrom == "" {
+		log.Fatal().Msg("SMTP_FROM is not set")
+	}
+
+	sessionLifetimeDays := 30
+	if envSessionLifetime := os.Getenv("SESSION_LIFETIME_DAYS"); envSessionLifetime != "" {
+		val, err := strconv.Atoi(envSessionLifetime)
+		if err == nil && val > 0 {
+			sessionLifetimeDays = val
+		} else {
+			log.Warn().Msgf("SESSION_LIFETIME_DAYS is not a positive number, init with default value: %d", sessionLifetimeDays)
+		}
+	}
+
+	twoFactorIssuer := "Org Note"
+	if envTwoFactorIssuer := os.Getenv("TWO_FACTOR_ISSUER"); envTwoFactorIssuer != "" {
+		twoFactorIssuer = envTwoFactorIssuer
+	}
+
+	oidcProviders, err := parseOIDCProviders(os.Getenv("OIDC_PROVIDERS"), os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("OIDC providers are not configured correctly")
+	}
+
 	backendPort := os.Getenv("BACKEND_PORT")
 
 	config := Config{
@@ -119,6 +337,37 @@ func NewConfig() Config {
 		AccessCheckToken:         accessCheckToken,
 		AccessTokenCacheLifeTime: accessTokenCacheLifeTime,
 		MaximumFileSize:          maximumFileSize,
+		NoteRevisionsLimit:       noteRevisionsLimit,
+		NoteRevisionsMaxAge:      time.Duration(noteRevisionsMaxAgeDays) * 24 * time.Hour,
+		SyncPageSize:             syncPageSize,
+		FilesGCSchedule:          filesGCSchedule,
+		FilesGCGracePeriod:       time.Duration(filesGCGracePeriodHours) * time.Hour,
+		StorageDriver:            storageDriver,
+		S3Endpoint:               os.Getenv("S3_ENDPOINT"),
+		S3Region:                 s3Region,
+		S3Bucket:                 s3Bucket,
+		S3AccessKey:              os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:              os.Getenv("S3_SECRET_KEY"),
+		S3UseSSL:                 os.Getenv("S3_USE_SSL") != "false",
+		MediaURLExpiration:       time.Duration(mediaURLExpirationMinutes) * time.Minute,
+		UploadMaxSize:            uploadMaxSize,
+		UploadExpiration:         time.Duration(uploadExpirationHours) * time.Hour,
+		ThumbnailWidths:          thumbnailWidths,
+		UploadAllowedTypes:       uploadAllowedTypes,
+		MediaSigningKey:          mediaSigningKey,
+		LocalAuthEnabled:         os.Getenv("LOCAL_AUTH_ENABLED") == "true",
+		RegistrationMode:         registrationMode,
+		SMTPHost:                 smtpHost,
+		SMTPPort:                 smtpPort,
+		SMTPUsername:             os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:             os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:                 smtpFrom,
+		OIDCProviders:            oidcProviders,
+		SessionLifetime:          time.Duration(sessionLifetimeDays) * 24 * time.Hour,
+		TwoFactorRequired:        os.Getenv("TWO_FACTOR_REQUIRED") == "true",
+		TwoFactorIssuer:          twoFactorIssuer,
+		AdminEmails:              splitList(strings.ToLower(os.Getenv("ADMIN_EMAILS"))),
+		AdminUserIDs:             splitList(os.Getenv("ADMIN_USER_IDS")),
 		MobileAppName:            "orgnote",
 
 		GithubClientOwner:    "artawower",
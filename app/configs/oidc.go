@@ -0,0 +1,87 @@
+package configs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OpenID Connect login provider, empty claim lists mean standard claims
+type OIDCProviderConfig struct {
+	Name             string // Used in login url: /auth/<name>/login
+	Issuer           string // Configuration is discovered from <issuer>/.well-known/openid-configuration
+	ClientID         string
+	ClientSecret     string
+	Scopes           []string
+	IDClaims         []string
+	NameClaims       []string
+	NickNameClaims   []string
+	EmailClaims      []string
+	AvatarURLClaims  []string
+	FirstNameClaims  []string
+	LastNameClaims   []string
+	ProfileURLClaims []string
+}
+
+var oidcProviderNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Names which are used by other login methods
+var reservedProviderNames = []string{"github", "local"}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Read providers listed in OIDC_PROVIDERS, every provider is configured by OIDC_<NAME>_* variables
+func parseOIDCProviders(providerNames string, getenv func(string) string) ([]OIDCProviderConfig, error) {
+	providers := []OIDCProviderConfig{}
+	for _, name := range splitList(strings.ToLower(providerNames)) {
+		if !oidcProviderNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid oidc provider name: %s", name)
+		}
+		for _, reservedName := range reservedProviderNames {
+			if name == reservedName {
+				return nil, fmt.Errorf("oidc provider name is reserved: %s", name)
+			}
+		}
+		for _, provider := range providers {
+			if provider.Name == name {
+				return nil, fmt.Errorf("duplicated oidc provider: %s", name)
+			}
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProviderConfig{
+			Name:             name,
+			Issuer:           strings.TrimSuffix(getenv(prefix+"ISSUER"), "/"),
+			ClientID:         getenv(prefix + "CLIENT_ID"),
+			ClientSecret:     getenv(prefix + "CLIENT_SECRET"),
+			Scopes:           splitList(getenv(prefix + "SCOPES")),
+			IDClaims:         splitList(getenv(prefix + "CLAIM_ID")),
+			NameClaims:       splitList(getenv(prefix + "CLAIM_NAME")),
+			NickNameClaims:   splitList(getenv(prefix + "CLAIM_NICKNAME")),
+			EmailClaims:      splitList(getenv(prefix + "CLAIM_EMAIL")),
+			AvatarURLClaims:  splitList(getenv(prefix + "CLAIM_AVATAR_URL")),
+			FirstNameClaims:  splitList(getenv(prefix + "CLAIM_FIRST_NAME")),
+			LastNameClaims:   splitList(getenv(prefix + "CLAIM_LAST_NAME")),
+			ProfileURLClaims: splitList(getenv(prefix + "CLAIM_PROFILE_URL")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" || provider.ClientSecret == "" {
+			return nil, fmt.Errorf("%sISSUER, %sCLIENT_ID or %sCLIENT_SECRET is not set", prefix, prefix, prefix)
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "profile", "email"}
+		}
+		if len(provider.ProfileURLClaims) == 0 {
+			provider.ProfileURLClaims = []string{"profile"}
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
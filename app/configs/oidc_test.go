@@ -0,0 +1,58 @@
+package configs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOIDCProviders(t *testing.T) {
+	env := map[string]string{
+		"OIDC_KEYCLOAK_ISSUER":          "https://sso.example.com/realms/main/",
+		"OIDC_KEYCLOAK_CLIENT_ID":       "orgnote",
+		"OIDC_KEYCLOAK_CLIENT_SECRET":   "secret",
+		"OIDC_KEYCLOAK_SCOPES":          "openid, email",
+		"OIDC_KEYCLOAK_CLAIM_EMAIL":     "upn,email",
+		"OIDC_MY_IDP_ISSUER":            "https://idp.example.com",
+		"OIDC_MY_IDP_CLIENT_ID":         "client",
+		"OIDC_MY_IDP_CLIENT_SECRET":     "secret",
+		"OIDC_MY_IDP_CLAIM_PROFILE_URL": "website",
+	}
+
+	providers, err := parseOIDCProviders("Keycloak, my-idp", func(key string) string { return env[key] })
+	require.NoError(t, err)
+	require.Len(t, providers, 2)
+
+	assert.Equal(t, "keycloak", providers[0].Name)
+	assert.Equal(t, "https://sso.example.com/realms/main", providers[0].Issuer)
+	assert.Equal(t, []string{"openid", "email"}, providers[0].Scopes)
+	assert.Equal(t, []string{"upn", "email"}, providers[0].EmailClaims)
+	assert.Empty(t, providers[0].NameClaims)
+	assert.Equal(t, []string{"profile"}, providers[0].ProfileURLClaims)
+
+	assert.Equal(t, "my-idp", providers[1].Name)
+	assert.Equal(t, []string{"openid", "profile", "email"}, providers[1].Scopes)
+	assert.Equal(t, []string{"website"}, providers[1].ProfileURLClaims)
+}
+
+func TestParseOIDCProvidersEmpty(t *testing.T) {
+	providers, err := parseOIDCProviders("", func(string) string { return "" })
+
+	assert.NoError(t, err)
+	assert.Empty(t, providers)
+}
+
+func TestParseOIDCProvidersInvalid(t *testing.T) {
+	env := map[string]string{
+		"OIDC_GITHUB_ISSUER":        "https://example.com",
+		"OIDC_GITHUB_CLIENT_ID":     "client",
+		"OIDC_GITHUB_CLIENT_SECRET": "secret",
+	}
+	getenv := func(key string) string { return env[key] }
+
+	for _, names := range []string{"github", "local", "key cloak", "sso/main", "keycloak"} {
+		_, err := parseOIDCProviders(names, getenv)
+		assert.Error(t, err, names)
+	}
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"orgnote/app/models"
+	"orgnote/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+type AdminHandlers struct {
+	adminService *services.AdminService
+}
+
+func (h *AdminHandlers) writeError(c *fiber.Ctx, err error, msg string) error {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("User not found", nil))
+	case errors.Is(err, services.ErrInvalidRole):
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid role", models.UserRoles))
+	case errors.Is(err, services.ErrInvalidSpaceLimit):
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Space limit should not be negative", nil))
+	case errors.Is(err, services.ErrOwnAccount):
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any]("Admin can't change role or disable own account", nil))
+	}
+	log.Error().Err(err).Msgf("admin handler: %s", msg)
+	return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any](msg, nil))
+}
+
+type GetUsersFilter struct {
+	Query    string  `json:"q" query:"q" extensions:"x-order=1"` // Part of email, name or nickname
+	Role     *string `json:"role" query:"role" enums:"user,admin" extensions:"x-order=2"`
+	Disabled *bool   `json:"disabled" query:"disabled" extensions:"x-order=3"`
+	Limit    *int64  `json:"limit" query:"limit" extensions:"x-order=4"`
+	Offset   *int64  `json:"offset" query:"offset" extensions:"x-order=5"`
+}
+
+// GetUsers godoc
+// @Summary      Get users
+// @Description  Search users by email, name or nickname, the newest users first
+// @Tags         admin
+// @Produce      json
+// @Param        filter  query  GetUsersFilter  false  "Filter"
+// @Success      200  {object}  HttpResponse[[]models.AdminUser, models.Pagination]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/users  [get]
+func (h *AdminHandlers) GetUsers(c *fiber.Ctx) error {
+	filter := new(GetUsersFilter)
+	if err := c.QueryParser(filter); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input query", nil))
+	}
+
+	users, err := h.adminService.GetUsers(models.UserFilter{
+		Limit:      filter.Limit,
+		Offset:     filter.Offset,
+		SearchText: &filter.Query,
+		Role:       filter.Role,
+		Disabled:   filter.Disabled,
+	})
+	if err != nil {
+		return h.writeError(c, err, "Can't get users")
+	}
+	return c.Status(http.StatusOK).JSON(
+		NewHttpResponse(users.Data, models.Pagination{
+			Limit:  users.Limit,
+			Offset: users.Offset,
+			Total:  users.Total,
+		}))
+}
+
+// GetUser godoc
+// @Summary      Get user
+// @Description
+// @Tags         admin
+// @Produce      json
+// @Param        id  path  string  true  "User id"
+// @Success      200  {object}  HttpResponse[models.AdminUser, any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/users/{id}  [get]
+func (h *AdminHandlers) GetUser(c *fiber.Ctx) error {
+	user, err := h.adminService.GetUser(c.Params("id"))
+	if err != nil {
+		return h.writeError(c, err, "Can't get user")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AdminUser, any](user, nil))
+}
+
+type SetRoleBody struct {
+	Role string `json:"role" enums:"user,admin"`
+}
+
+// SetUserRole godoc
+// @Summary      Set user role
+// @Description  Admin can't change own role
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string       true  "User id"
+// @Param        data  body  SetRoleBody  true  "Role"
+// @Success      200  {object}  HttpResponse[models.AdminUser, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/users/{id}/role  [put]
+func (h *AdminHandlers) SetRole(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	body := new(SetRoleBody)
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	user, err := h.adminService.SetRole(admin, c.Params("id"), body.Role)
+	if err != nil {
+		return h.writeError(c, err, "Can't set role")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AdminUser, any](user, nil))
+}
+
+type SetSpaceLimitBody struct {
+	SpaceLimit *int64 `json:"spaceLimit"` // Bytes, 0 means unlimited. Null returns control over the limit to subscription
+}
+
+// SetUserSpaceLimit godoc
+// @Summary      Set user space limit
+// @Description  Override space limit of the user, subscription doesn't change overridden limit
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string             true  "User id"
+// @Param        data  body  SetSpaceLimitBody  true  "Space limit"
+// @Success      200  {object}  HttpResponse[models.AdminUser, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/users/{id}/space-limit  [put]
+func (h *AdminHandlers) SetSpaceLimit(c *fiber.Ctx) error {
+	body := new(SetSpaceLimitBody)
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	user, err := h.adminService.SetSpaceLimit(c.Params("id"), body.SpaceLimit)
+	if err != nil {
+		return h.writeError(c, err, "Can't set space limit")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AdminUser, any](user, nil))
+}
+
+// DisableUser godoc
+// @Summary      Disable user
+// @Description  Disabled user can't login, sessions are closed and API tokens are not accepted. Admin can't disable own account
+// @Tags         admin
+// @Produce      json
+// @Param        id  path  string  true  "User id"
+// @Success      200  {object}  HttpResponse[models.AdminUser, any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/users/{id}/disable  [post]
+func (h *AdminHandlers) DisableUser(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+
+	user, err := h.adminService.SetDisabled(admin, c.Params("id"), true)
+	if err != nil {
+		return h.writeError(c, err, "Can't disable user")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AdminUser, any](user, nil))
+}
+
+// EnableUser godoc
+// @Summary      Enable user
+// @Description  Allow login of disabled user, API tokens of the user are accepted again
+// @Tags         admin
+// @Produce      json
+// @Param        id  path  string  true  "User id"
+// @Success      200  {object}  HttpResponse[models.AdminUser, any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/users/{id}/enable  [post]
+func (h *AdminHandlers) EnableUser(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+
+	user, err := h.adminService.SetDisabled(admin, c.Params("id"), false)
+	if err != nil {
+		return h.writeError(c, err, "Can't enable user")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AdminUser, any](user, nil))
+}
+
+// RevokeUserTokens godoc
+// @Summary      Revoke user tokens
+// @Description  Close all sessions and delete API and calendar tokens of the user
+// @Tags         admin
+// @Produce      json
+// @Param        id  path  string  true  "User id"
+// @Success      200  {object}  HttpResponse[models.AdminUser, any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/users/{id}/revoke-tokens  [post]
+func (h *AdminHandlers) RevokeTokens(c *fiber.Ctx) error {
+	user, err := h.adminService.RevokeTokens(c.Params("id"))
+	if err != nil {
+		return h.writeError(c, err, "Can't revoke tokens")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AdminUser, any](user, nil))
+}
+
+// GetStats godoc
+// @Summary      Get instance stats
+// @Description  Number of users and notes, used storage space
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.InstanceStats, any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /admin/stats  [get]
+func (h *AdminHandlers) GetStats(c *fiber.Ctx) error {
+	stats, err := h.adminService.GetStats()
+	if err != nil {
+		return h.writeError(c, err, "Can't get stats")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.InstanceStats, any](stats, nil))
+}
+
+func RegisterAdminHandler(app fiber.Router, adminService *services.AdminService, authMiddleware fiber.Handler) {
+	adminHandlers := &AdminHandlers{
+		adminService: adminService,
+	}
+	admin := app.Group("/admin", authMiddleware, NewScopeMiddleware(models.ScopeAccount), NewAdminMiddleware())
+
+	admin.Get("/users", adminHandlers.GetUsers)
+	admin.Get("/users/:id", adminHandlers.GetUser)
+	admin.Put("/users/:id/role", adminHandlers.SetRole)
+	admin.Put("/users/:id/space-limit", adminHandlers.SetSpaceLimit)
+	admin.Post("/users/:id/disable", adminHandlers.DisableUser)
+	admin.Post("/users/:id/enable", adminHandlers.EnableUser)
+	admin.Post("/users/:id/revoke-tokens", adminHandlers.RevokeTokens)
+	admin.Get("/stats", adminHandlers.GetStats)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"orgnote/app/models"
+	"orgnote/app/services"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+type AgendaHandlers struct {
+	noteService *services.NoteService
+}
+
+type GetAgendaFilter struct {
+	From  *string `json:"from" query:"from" extensions:"x-order=1"`   // RFC3339 or YYYY-MM-DD
+	To    *string `json:"to" query:"to" extensions:"x-order=2"`       // RFC3339 or YYYY-MM-DD, date without time includes the whole day
+	State *string `json:"state" query:"state" extensions:"x-order=3"` // Comma separated TODO keywords
+}
+
+func parseAgendaDate(raw string, endOfDay bool) (*time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, raw); err == nil {
+		date = date.UTC()
+		return &date, nil
+	}
+	date, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect date %s", raw)
+	}
+	if endOfDay {
+		date = date.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &date, nil
+}
+
+func buildAgendaFilter(agendaFilter *GetAgendaFilter) (*models.AgendaFilter, error) {
+	filter := &models.AgendaFilter{}
+	var err error
+
+	if agendaFilter.From != nil && *agendaFilter.From != "" {
+		if filter.From, err = parseAgendaDate(*agendaFilter.From, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if agendaFilter.To != nil && *agendaFilter.To != "" {
+		if filter.To, err = parseAgendaDate(*agendaFilter.To, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if agendaFilter.State != nil {
+		for _, state := range strings.Split(*agendaFilter.State, ",") {
+			if state = strings.TrimSpace(state); state != "" {
+				filter.States = append(filter.States, state)
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// GetAgenda godoc
+// @Summary      Get agenda
+// @Description  Return TODO headlines and headlines with SCHEDULED/DEADLINE timestamps from user notes.
+// @Description  Repeated timestamps are matched by their next occurrence within the range
+// @Tags         agenda
+// @Accept       json
+// @Produce      json
+// @Param        filter  query  GetAgendaFilter  false  "Agenda filter"
+// @Success      200  {object}  HttpResponse[[]models.AgendaItem, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /agenda  [get]
+func (h *AgendaHandlers) GetAgenda(c *fiber.Ctx) error {
+	agendaFilter := new(GetAgendaFilter)
+	if err := c.QueryParser(agendaFilter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input query", nil))
+	}
+
+	filter, err := buildAgendaFilter(agendaFilter)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Incorrect date format, use RFC3339 or YYYY-MM-DD", nil))
+	}
+
+	user := c.Locals("user").(*models.User)
+	items, err := h.noteService.GetAgenda(user.ID.Hex(), *filter)
+	if err != nil {
+		log.Info().Err(err).Msgf("agenda handler: get agenda: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't get agenda, something went wrong", nil))
+	}
+
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[[]models.AgendaItem, any](items, nil))
+}
+
+func RegisterAgendaHandler(app fiber.Router, noteService *services.NoteService, authMiddleware func(*fiber.Ctx) error) {
+	agendaHandlers := &AgendaHandlers{
+		noteService: noteService,
+	}
+	app.Get("/agenda", authMiddleware, NewScopeMiddleware(models.ScopeNotesRead), agendaHandlers.GetAgenda)
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"net/url"
 	"orgnote/app/configs"
+	"orgnote/app/infrastructure"
 	"orgnote/app/models"
 	"orgnote/app/services"
 	"orgnote/app/tools"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/markbates/goth"
@@ -22,7 +25,17 @@ type OAuthRedirectData struct {
 	RedirectURL string `json:"redirectUrl"`
 }
 
-func mapToUser(user goth.User) *models.User {
+// Return the first not empty string claim
+func getClaimValue(rawData map[string]interface{}, claims []string) string {
+	for _, claim := range claims {
+		if value, ok := rawData[claim].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func mapToUser(user goth.User, profileURLClaims []string) *models.User {
 	return &models.User{
 		Provider:            user.Provider,
 		Email:               user.Email,
@@ -32,10 +45,9 @@ func mapToUser(user goth.User) *models.User {
 		ExternalID:          user.UserID,
 		FirstName:           user.FirstName,
 		LastName:            user.LastName,
-		Token:               user.AccessToken,
 		RefreshToken:        &user.RefreshToken,
 		TokenExpirationDate: user.ExpiresAt,
-		ProfileURL:          user.RawData["html_url"].(string),
+		ProfileURL:          getClaimValue(user.RawData, profileURLClaims),
 		Notes:               []models.Note{},
 		APITokens:           []models.APIToken{},
 		// TODO: [selfhosted] master get this values from environment
@@ -46,14 +58,18 @@ func mapToUser(user goth.User) *models.User {
 }
 
 type AuthHandler struct {
-	userService    *services.UserService
-	config         configs.Config
-	authMiddleware fiber.Handler
+	userService      *services.UserService
+	fileService      *services.FileService
+	config           configs.Config
+	authMiddleware   fiber.Handler
+	profileURLClaims map[string][]string // Raw data fields with profile url by provider name
 }
 
 type State struct {
 	Environment string  `json:"environment"`
 	RedirectURL *string `json:"redirectUrl"`
+	LinkToken   string  `json:"linkToken,omitempty"`  // Identity is linked to the existing user instead of login
+	DeviceName  string  `json:"deviceName,omitempty"` // Name of the created session
 }
 
 // Login godoc
@@ -63,7 +79,7 @@ type State struct {
 // @Accept       json
 // @Produce      json
 // @Param        provider path string true "provider"
-// @Param        state query string false "OAuth state"
+// @Param        state query string false "OAuth state, JSON with optional deviceName field is used as session name"
 // @Success      200  {object}  handlers.HttpResponse[OAuthRedirectData, any]
 // @Failure      400  {object}  handlers.HttpError[any]
 // @Failure      404  {object}  handlers.HttpError[any]
@@ -85,7 +101,7 @@ func (a *AuthHandler) Login(c *fiber.Ctx) error {
 
 // LoginCallback godoc
 // @Summary      Callback for OAuth
-// @Description
+// @Description  Redirect to the client with session token. When the second factor is required, twoFactorToken is passed instead
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -108,13 +124,29 @@ func (a *AuthHandler) LoginCallback(c *fiber.Ctx) error {
 		log.Error().Err(err).Msgf("auth handlers: github auth handler: encode user: %v", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
 	}
-	u, err := a.userService.Login(*mapToUser(user))
+	providerUser := mapToUser(user, a.profileURLClaims[user.Provider])
+	state := goth_fiber.GetState(c)
+	parsedState := State{}
+	if json.Unmarshal([]byte(state), &parsedState) == nil && parsedState.LinkToken != "" {
+		return a.linkIdentityCallback(c, state, parsedState.LinkToken, providerUser)
+	}
+
+	session, err := a.userService.Login(*providerUser, services.LoginParams{
+		DeviceName: parsedState.DeviceName,
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+	})
+	if errors.Is(err, services.ErrUserDisabled) {
+		q := url.Values{}
+		q.Set("state", state)
+		q.Set("error", "account_disabled")
+		return c.Redirect(a.getLoginCallbackURL(state) + "?" + q.Encode())
+	}
 	if err != nil {
 		log.Error().Err(err).Msgf("auth handlers: github auth handler: login user %v", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
 	}
 	// TODO: master client url for redirect. Read from env
-	state := goth_fiber.GetState(c)
 	redirectURL := a.getLoginCallbackURL(state)
 	parsedURL, err := url.Parse(redirectURL)
 	if err != nil {
@@ -122,8 +154,18 @@ func (a *AuthHandler) LoginCallback(c *fiber.Ctx) error {
 	}
 
 	q := parsedURL.Query()
-	q.Set("token", u.Token)
-	q.Set("id", u.ID.Hex())
+	q.Set("state", state)
+	// Client completes login by POST /auth/2fa/challenge
+	if session.TwoFactorChallenge != nil {
+		q.Set("twoFactorToken", session.TwoFactorChallenge.Token)
+		q.Set("twoFactorSetupRequired", strconv.FormatBool(session.TwoFactorChallenge.SetupRequired))
+		parsedURL.RawQuery = q.Encode()
+		return c.Redirect(redirectURL + "?" + parsedURL.RawQuery)
+	}
+
+	u := session.User
+	q.Set("token", session.Token)
+	q.Set("id", u.ID)
 	q.Set("username", u.NickName)
 	q.Set("avatarUrl", u.AvatarURL)
 	q.Set("email", u.Email)
@@ -133,12 +175,31 @@ func (a *AuthHandler) LoginCallback(c *fiber.Ctx) error {
 		q.Set("active", *u.Active)
 	}
 	q.Set("usedSpace", strconv.FormatInt(u.UsedSpace, 10))
-	q.Set("state", state)
 	parsedURL.RawQuery = q.Encode()
 
 	return c.Redirect(redirectURL + "?" + parsedURL.RawQuery)
 }
 
+// Redirect to the client with linked provider or error code
+func (a *AuthHandler) linkIdentityCallback(c *fiber.Ctx, state string, linkToken string, providerUser *models.User) error {
+	q := url.Values{}
+	q.Set("state", state)
+
+	_, err := a.userService.LinkIdentity(linkToken, *providerUser)
+	switch {
+	case errors.Is(err, services.ErrInvalidActionToken):
+		q.Set("error", "invalid_link_token")
+	case errors.Is(err, services.ErrIdentityLinked):
+		q.Set("error", "identity_already_linked")
+	case err != nil:
+		log.Error().Err(err).Msgf("auth handlers: link identity callback: link identity")
+		return c.Status(fiber.StatusInternalServerError).SendString("Internal server error")
+	default:
+		q.Set("linked", providerUser.Provider)
+	}
+	return c.Redirect(a.getLoginCallbackURL(state) + "?" + q.Encode())
+}
+
 func (a *AuthHandler) getLoginCallbackURL(state string) string {
 	parsedState := State{}
 	URL := a.config.ClientAddress
@@ -157,35 +218,253 @@ func (a *AuthHandler) getLoginCallbackURL(state string) string {
 
 // Logout godoc
 // @Summary      Logout
-// @Description
+// @Description  Close current session, its token becomes invalid
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Success      200  {object}  any
 // @Failure      500  {object}  handlers.HttpError[any]
-// @Router       /auth/logout  [get]
+// @Router       /auth/logout  [post]
 func (a *AuthHandler) Logout(c *fiber.Ctx) error {
 	if err := goth_fiber.Logout(c); err != nil {
 		log.Error().Err(err).Msgf("auth handlers: github auth handler: logout")
 		return c.Status(500).SendString("Internal server error")
 	}
-	// TODO: master delete user token here
-	// c.SendString("logout")
+	user, _ := c.Locals("user").(*models.User)
+	session, _ := c.Locals("session").(*models.Session)
+	if user != nil && session != nil {
+		err := a.userService.DeleteSession(user, session.ID.Hex())
+		if err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+			log.Error().Err(err).Msgf("auth handlers: logout: delete session")
+			return c.Status(500).SendString("Internal server error")
+		}
+	}
 	return c.Status(200).JSON(struct{}{})
 }
 
+// GetSessions godoc
+// @Summary      Get sessions
+// @Description  Return active sessions of the current user, the most recently used first
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  handlers.HttpResponse[[]models.Session, any]
+// @Failure      500  {object}  handlers.HttpError[any]
+// @Router       /auth/sessions  [get]
+func (a *AuthHandler) GetSessions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	session, _ := c.Locals("session").(*models.Session)
+
+	sessions := a.userService.GetSessions(user, session)
+	return c.Status(fiber.StatusOK).JSON(NewHttpResponse[[]models.Session, any](sessions, nil))
+}
+
+// DeleteSession godoc
+// @Summary      Delete session
+// @Description  Sign out the device
+// @Tags         auth
+// @Param        id  path  string  true  "Session id"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      404  {object}  handlers.HttpError[any]
+// @Failure      500  {object}  handlers.HttpError[any]
+// @Router       /auth/sessions/{id}  [delete]
+func (a *AuthHandler) DeleteSession(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	err := a.userService.DeleteSession(user, c.Params("id"))
+	if errors.Is(err, services.ErrSessionNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(NewHttpError[any]("Session not found", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("auth handlers: delete session")
+		return c.Status(fiber.StatusInternalServerError).JSON(NewHttpError[any]("Can't delete session", nil))
+	}
+	return c.Status(fiber.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+// DeleteSessions godoc
+// @Summary      Sign out everywhere
+// @Description  Delete all sessions of the current user including the current one. API tokens are not changed
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      500  {object}  handlers.HttpError[any]
+// @Router       /auth/sessions  [delete]
+func (a *AuthHandler) DeleteSessions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	if err := a.userService.DeleteSessions(user); err != nil {
+		log.Error().Err(err).Msgf("auth handlers: delete sessions")
+		return c.Status(fiber.StatusInternalServerError).JSON(NewHttpError[any]("Can't delete sessions", nil))
+	}
+	return c.Status(fiber.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+// RotateSession godoc
+// @Summary      Rotate session token
+// @Description  Replace token of the current session and prolong the session. Previous token becomes invalid
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  handlers.HttpResponse[models.SessionToken, any]
+// @Failure      400  {object}  handlers.HttpError[any]
+// @Failure      404  {object}  handlers.HttpError[any]
+// @Failure      500  {object}  handlers.HttpError[any]
+// @Router       /auth/sessions/rotate  [post]
+func (a *AuthHandler) RotateSession(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	session, _ := c.Locals("session").(*models.Session)
+	if session == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Request should be authorized by session token", nil))
+	}
+
+	sessionToken, err := a.userService.RotateSession(user, session)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(NewHttpError[any]("Session not found", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("auth handlers: rotate session")
+		return c.Status(fiber.StatusInternalServerError).JSON(NewHttpError[any]("Can't rotate session", nil))
+	}
+	return c.Status(fiber.StatusOK).JSON(NewHttpResponse[*models.SessionToken, any](sessionToken, nil))
+}
+
+// LinkIdentity godoc
+// @Summary      Link identity
+// @Description  Return OAuth url of the provider, after login the provider identity is attached to the current user.
+// @Description  Client is redirected to the login page with linked provider or error query parameter
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        provider path string true "provider"
+// @Param        state query string false "OAuth state"
+// @Success      200  {object}  handlers.HttpResponse[OAuthRedirectData, any]
+// @Failure      400  {object}  handlers.HttpError[any]
+// @Failure      500  {object}  handlers.HttpError[any]
+// @Router       /auth/{provider}/link  [post]
+func (a *AuthHandler) LinkIdentity(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	if _, err := goth.GetProvider(c.Params("provider")); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Unknown provider", nil))
+	}
+
+	linkToken, err := a.userService.CreateLinkIdentityToken(user)
+	if err != nil {
+		log.Error().Err(err).Msgf("auth handlers: link identity: create link token")
+		return c.Status(fiber.StatusInternalServerError).JSON(NewHttpError[any]("Can't link identity", nil))
+	}
+	state := State{}
+	json.Unmarshal([]byte(c.Query("state")), &state)
+	state.LinkToken = linkToken
+	encodedState, err := json.Marshal(state)
+	if err != nil {
+		log.Error().Err(err).Msgf("auth handlers: link identity: encode state")
+		return c.Status(fiber.StatusInternalServerError).JSON(NewHttpError[any]("Can't link identity", nil))
+	}
+	// OAuth state is read from query
+	c.Request().URI().QueryArgs().Set("state", string(encodedState))
+
+	authURL, err := goth_fiber.GetAuthURL(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any](err.Error(), nil))
+	}
+	return c.JSON(NewHttpResponse[OAuthRedirectData, any](OAuthRedirectData{
+		RedirectURL: authURL,
+	}, nil))
+}
+
+// GetIdentities godoc
+// @Summary      Get identities
+// @Description  Return login providers attached to the current user
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  handlers.HttpResponse[[]models.Identity, any]
+// @Failure      500  {object}  handlers.HttpError[any]
+// @Router       /auth/identities  [get]
+func (a *AuthHandler) GetIdentities(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	identities := user.Identities
+	if identities == nil {
+		identities = []models.Identity{}
+	}
+	return c.Status(fiber.StatusOK).JSON(NewHttpResponse[[]models.Identity, any](identities, nil))
+}
+
+// UnlinkIdentity godoc
+// @Summary      Unlink identity
+// @Description  Detach login provider from the current user, the last identity could not be removed
+// @Tags         auth
+// @Param        id  path  string  true  "Identity id"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      404  {object}  handlers.HttpError[any]
+// @Failure      409  {object}  handlers.HttpError[any]
+// @Failure      500  {object}  handlers.HttpError[any]
+// @Router       /auth/identities/{id}  [delete]
+func (a *AuthHandler) UnlinkIdentity(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	err := a.userService.UnlinkIdentity(user, c.Params("id"))
+	if errors.Is(err, services.ErrIdentityNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(NewHttpError[any]("Identity not found", nil))
+	}
+	if errors.Is(err, services.ErrLastIdentity) {
+		return c.Status(fiber.StatusConflict).JSON(NewHttpError[any]("The last identity could not be removed", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("auth handlers: unlink identity")
+		return c.Status(fiber.StatusInternalServerError).JSON(NewHttpError[any]("Can't unlink identity", nil))
+	}
+	return c.Status(fiber.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+type CreateTokenBody struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes" enums:"notes:read,notes:write,files:write,publish,account"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
 // CreateApiToken godoc
 // @Summary      Create API token
-// @Description  Create API token
+// @Description  Create API token with scopes. Token gets notes:read, notes:write, files:write and publish scopes
+// @Description  when scopes are not provided. Token without expiration time never expires
+// @Description  Token value is returned only once, only its hash is stored
 // @Tags         auth
+// @Param        data  body  CreateTokenBody  false  "Token params"
 // @Accept       json
 // @Produce      json
 // @Success      200  {object}  HttpResponse[models.APIToken, any]
+// @Failure      400  {object}  handlers.HttpError[any]
 // @Failure      500  {object}  handlers.HttpError[any]
 // @Router       /auth/token  [post]
 func (a *AuthHandler) CreateToken(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
-	token, err := a.userService.CreateToken(user)
+
+	body := new(CreateTokenBody)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Incorrect token params", nil))
+		}
+	}
+
+	token, err := a.userService.CreateToken(user, services.CreateAPITokenParams{
+		Name:      body.Name,
+		Scopes:    body.Scopes,
+		ExpiresAt: body.ExpiresAt,
+	})
+	if errors.Is(err, services.ErrInvalidTokenScope) {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Unknown token scope", models.APITokenScopes))
+	}
+	if errors.Is(err, services.ErrInvalidTokenExpiration) {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Token expiration time should be in the future", nil))
+	}
 	if err != nil {
 		log.Error().Err(err).Msgf("auth handlers: github auth handler: create token")
 		return c.Status(500).SendString("Internal server error")
@@ -287,6 +566,11 @@ func (a *AuthHandler) DeleteUserAccount(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString("Could not delete user account")
 	}
 
+	err = a.fileService.DeleteUserFiles(user.ID.Hex())
+	if err != nil {
+		log.Error().Err(err).Msg("auth handler: delete user account: could not delete user files")
+	}
+
 	return c.Status(fiber.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
 }
 
@@ -323,27 +607,76 @@ func (a *AuthHandler) Subscribe(c *fiber.Ctx) error {
 }
 
 // TODO: master refactor this code.
-func RegisterAuthHandler(app fiber.Router, userService *services.UserService, config configs.Config, authMiddleware fiber.Handler) {
-	redirectURL := config.BackendHost() + "/auth/github/callback"
-	log.Info().Msgf("Redirect url: %s", redirectURL)
+func RegisterAuthHandler(
+	app fiber.Router,
+	userService *services.UserService,
+	fileService *services.FileService,
+	config configs.Config,
+	authMiddleware fiber.Handler,
+) {
+	providers := []goth.Provider{}
+	profileURLClaims := map[string][]string{}
+
+	if config.GithubID != "" {
+		redirectURL := config.BackendHost() + "/auth/github/callback"
+		log.Info().Msgf("Redirect url: %s", redirectURL)
+		providers = append(providers, github.New(config.GithubID, config.GithubSecret, redirectURL))
+		profileURLClaims["github"] = []string{"html_url"}
+	}
+
+	for _, oidcConfig := range config.OIDCProviders {
+		provider := infrastructure.NewLazyOIDCProvider(infrastructure.OIDCConfig{
+			Name:         oidcConfig.Name,
+			Issuer:       oidcConfig.Issuer,
+			ClientID:     oidcConfig.ClientID,
+			ClientSecret: oidcConfig.ClientSecret,
+			CallbackURL:  config.BackendHost() + "/auth/" + oidcConfig.Name + "/callback",
+			Scopes:       oidcConfig.Scopes,
+			Claims: infrastructure.OIDCClaims{
+				ID:        oidcConfig.IDClaims,
+				Name:      oidcConfig.NameClaims,
+				NickName:  oidcConfig.NickNameClaims,
+				Email:     oidcConfig.EmailClaims,
+				AvatarURL: oidcConfig.AvatarURLClaims,
+				FirstName: oidcConfig.FirstNameClaims,
+				LastName:  oidcConfig.LastNameClaims,
+			},
+		})
+		// Login by other providers should work when identity provider is not available,
+		// discovery is retried on login
+		if _, err := provider.Discover(); err != nil {
+			log.Error().Err(err).Msgf("auth handlers: register auth handler: could not discover %s provider", oidcConfig.Name)
+		}
+		providers = append(providers, provider)
+		profileURLClaims[oidcConfig.Name] = oidcConfig.ProfileURLClaims
+	}
 
-	goth.UseProviders(
-		github.New(config.GithubID, config.GithubSecret, redirectURL),
-	)
+	goth.UseProviders(providers...)
 
 	authHandler := &AuthHandler{
-		userService:    userService,
-		config:         config,
-		authMiddleware: authMiddleware,
+		userService:      userService,
+		fileService:      fileService,
+		config:           config,
+		authMiddleware:   authMiddleware,
+		profileURLClaims: profileURLClaims,
 	}
 
+	accountScope := NewScopeMiddleware(models.ScopeAccount)
+
 	app.Get("/auth/:provider/login", authHandler.Login)
 	app.Get("/auth/:provider/callback", authHandler.LoginCallback)
-	app.Get("/auth/logout", authHandler.Logout)
-	app.Post("/auth/token", authMiddleware, authHandler.CreateToken)
-	app.Delete("/auth/token/:tokenId", authMiddleware, authHandler.DeleteToken)
+	app.Post("/auth/logout", authMiddleware, authHandler.Logout)
+	app.Get("/auth/sessions", authMiddleware, accountScope, authHandler.GetSessions)
+	app.Post("/auth/sessions/rotate", authMiddleware, authHandler.RotateSession)
+	app.Delete("/auth/sessions", authMiddleware, accountScope, authHandler.DeleteSessions)
+	app.Delete("/auth/sessions/:id", authMiddleware, accountScope, authHandler.DeleteSession)
+	app.Post("/auth/:provider/link", authMiddleware, accountScope, authHandler.LinkIdentity)
+	app.Get("/auth/identities", authMiddleware, accountScope, authHandler.GetIdentities)
+	app.Delete("/auth/identities/:id", authMiddleware, accountScope, authHandler.UnlinkIdentity)
+	app.Post("/auth/token", authMiddleware, accountScope, authHandler.CreateToken)
+	app.Delete("/auth/token/:tokenId", authMiddleware, accountScope, authHandler.DeleteToken)
 	app.Get("/auth/verify", authHandler.VerifyUser)
-	app.Get("/auth/api-tokens", authHandler.GetAPITokens)
-	app.Post("/auth/subscribe", authMiddleware, authHandler.Subscribe)
-	app.Delete("/auth/account", authMiddleware, authHandler.DeleteUserAccount)
+	app.Get("/auth/api-tokens", accountScope, authHandler.GetAPITokens)
+	app.Post("/auth/subscribe", authMiddleware, accountScope, authHandler.Subscribe)
+	app.Delete("/auth/account", authMiddleware, accountScope, authHandler.DeleteUserAccount)
 }
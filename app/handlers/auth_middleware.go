@@ -3,6 +3,7 @@ package handlers
 import (
 	"orgnote/app/models"
 	"orgnote/app/tools"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
@@ -36,10 +37,63 @@ func NewAuthMiddleware() func(*fiber.Ctx) error {
 	}
 }
 
+// Requests authorized by API token require the scope, session tokens have access to everything
+func NewScopeMiddleware(scope string) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if !hasTokenScope(c, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(NewHttpError[any](ErrInsufficientScope, scope))
+		}
+		return c.Next()
+	}
+}
+
+func NewAdminMiddleware() func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, _ := c.Locals("user").(*models.User)
+		if user == nil || !user.IsAdmin() {
+			return c.Status(fiber.StatusForbidden).JSON(NewHttpError[any](ErrAdminRequired, nil))
+		}
+		return c.Next()
+	}
+}
+
+func hasTokenScope(c *fiber.Ctx, scope string) bool {
+	apiToken, _ := c.Locals("apiToken").(*models.APIToken)
+	return apiToken == nil || apiToken.HasScope(scope)
+}
+
+func findAPIToken(user *models.User, token string) *models.APIToken {
+	now := time.Now()
+	for i := range user.APITokens {
+		apiToken := &user.APITokens[i]
+		if !apiToken.IsExpired(now) && tools.VerifyToken(token, apiToken.Hash, apiToken.Salt) {
+			return apiToken
+		}
+	}
+	return nil
+}
+
+func findSession(user *models.User, token string) *models.Session {
+	now := time.Now()
+	for i := range user.Sessions {
+		session := &user.Sessions[i]
+		if !session.IsExpired(now) && tools.VerifyToken(token, session.Hash, session.Salt) {
+			return session
+		}
+	}
+	return nil
+}
+
 type Config struct {
 	Filter       func(c *fiber.Ctx) bool
 	Unauthorized fiber.Handler
 	GetUser      func(token string) (*models.User, error)
+	// Allow token from the token query parameter, for clients which can't set headers (EventSource, WebSocket)
+	AllowQueryToken func(c *fiber.Ctx) bool
+	// Called in background when request is authorized by API token
+	OnAPITokenUsed func(user *models.User, token *models.APIToken, ip string)
+	// Called in background when request is authorized by session token
+	OnSessionUsed func(user *models.User, session *models.Session, ip string)
 }
 
 func NewUserInjectMiddleware(config ...Config) func(*fiber.Ctx) error {
@@ -54,6 +108,9 @@ func NewUserInjectMiddleware(config ...Config) func(*fiber.Ctx) error {
 		}
 
 		token := tools.ExtractBearerTokenFromCtx(c)
+		if token == "" && cfg.AllowQueryToken != nil && cfg.AllowQueryToken(c) {
+			token = c.Query("token")
+		}
 
 		var user *models.User
 		var err error
@@ -67,6 +124,19 @@ func NewUserInjectMiddleware(config ...Config) func(*fiber.Ctx) error {
 		if err != nil {
 			log.Info().Msgf("auth middleware: GetUser: %s", err)
 		}
+		if user != nil {
+			apiToken := findAPIToken(user, token)
+			if apiToken != nil && cfg.OnAPITokenUsed != nil {
+				go cfg.OnAPITokenUsed(user, apiToken, c.IP())
+			}
+			c.Locals("apiToken", apiToken)
+
+			session := findSession(user, token)
+			if session != nil && cfg.OnSessionUsed != nil {
+				go cfg.OnSessionUsed(user, session, c.IP())
+			}
+			c.Locals("session", session)
+		}
 
 		c.Locals("user", user)
 		return c.Next()
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Request bodies are streamed by the server, so the body limit is checked here.
+// Routes matched by streamed read the body themselves and are not limited
+func NewBodyLimitMiddleware(limit int, streamed func(c *fiber.Ctx) bool) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if streamed(c) {
+			return c.Next()
+		}
+
+		length := c.Request().Header.ContentLength()
+		if length > limit {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(NewHttpError[any](ErrBodyTooLarge, nil))
+		}
+
+		bodyStream := c.Context().RequestBodyStream()
+		if length != -1 || bodyStream == nil {
+			return c.Next()
+		}
+
+		// Chunked body has unknown length, it's read up to the limit
+		body, err := io.ReadAll(io.LimitReader(bodyStream, int64(limit)+1))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any](ErrInvalidBody, nil))
+		}
+		if len(body) > limit {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(NewHttpError[any](ErrBodyTooLarge, nil))
+		}
+		c.Request().SetBody(body)
+		return c.Next()
+	}
+}
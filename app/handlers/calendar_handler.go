@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"orgnote/app/models"
+	"orgnote/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+type CalendarHandlers struct {
+	calendarService *services.CalendarService
+}
+
+// GetCalendar godoc
+// @Summary      Get calendar feed
+// @Description  iCalendar feed with SCHEDULED and DEADLINE headlines from non encrypted notes.
+// @Description  Token is a read-only calendar token, it could be used only for this feed
+// @Tags         calendar
+// @Produce      text/calendar
+// @Param        token  path  string  true  "Calendar token"
+// @Success      200  {string}  string
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /calendar/{token}.ics  [get]
+func (h *CalendarHandlers) GetCalendar(c *fiber.Ctx) error {
+	calendar, err := h.calendarService.GetCalendar(c.Params("token"))
+	if errors.Is(err, services.ErrCalendarNotFound) {
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("Calendar not found", nil))
+	}
+	if err != nil {
+		log.Info().Err(err).Msgf("calendar handler: get calendar: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't get calendar, something went wrong", nil))
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	return c.Status(http.StatusOK).SendString(calendar)
+}
+
+// CreateCalendarToken godoc
+// @Summary      Create calendar token
+// @Description  Create read-only token with secret url of the calendar feed, the url is returned only once
+// @Tags         calendar
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.CalendarToken, any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /calendar/tokens  [post]
+func (h *CalendarHandlers) CreateCalendarToken(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	token, err := h.calendarService.CreateToken(user)
+	if err != nil {
+		log.Error().Err(err).Msgf("calendar handler: create calendar token")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't create calendar token", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.CalendarToken, any](token, nil))
+}
+
+// GetCalendarTokens godoc
+// @Summary      Get calendar tokens
+// @Description  Return all calendar tokens of current user without their feed urls
+// @Tags         calendar
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[[]models.CalendarToken, any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /calendar/tokens  [get]
+func (h *CalendarHandlers) GetCalendarTokens(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	tokens, err := h.calendarService.GetTokens(user.ID.Hex())
+	if err != nil {
+		log.Error().Err(err).Msgf("calendar handler: get calendar tokens")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't get calendar tokens", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[[]models.CalendarToken, any](tokens, nil))
+}
+
+// DeleteCalendarToken godoc
+// @Summary      Delete calendar token
+// @Description  Delete calendar token, feed url with this token stops working
+// @Tags         calendar
+// @Param        tokenId  path  string  true  "token id"
+// @Success      200  {object}  any
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /calendar/tokens/{tokenId}  [delete]
+func (h *CalendarHandlers) DeleteCalendarToken(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	tokenID := c.Params("tokenId")
+	if tokenID == "" {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any](ErrTokenNotProvided, nil))
+	}
+
+	err := h.calendarService.DeleteToken(user, tokenID)
+	if err != nil {
+		log.Error().Err(err).Msgf("calendar handler: delete calendar token")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't delete calendar token", nil))
+	}
+	return c.Status(http.StatusOK).JSON(struct{}{})
+}
+
+func RegisterCalendarHandler(app fiber.Router, calendarService *services.CalendarService, authMiddleware func(*fiber.Ctx) error) {
+	calendarHandlers := &CalendarHandlers{
+		calendarService: calendarService,
+	}
+	accountScope := NewScopeMiddleware(models.ScopeAccount)
+
+	app.Get("/calendar/tokens", authMiddleware, accountScope, calendarHandlers.GetCalendarTokens)
+	app.Post("/calendar/tokens", authMiddleware, accountScope, calendarHandlers.CreateCalendarToken)
+	app.Delete("/calendar/tokens/:tokenId", authMiddleware, accountScope, calendarHandlers.DeleteCalendarToken)
+	app.Get("/calendar/:token.ics", calendarHandlers.GetCalendar)
+}
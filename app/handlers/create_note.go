@@ -17,6 +17,7 @@ type CreatingNote struct {
 	TouchedAt      time.Time       `json:"touchedAt" form:"touchedAt"`
 	EncryptionType *string         `json:"encryptionType" form:"encryptionType" enums:"gpgKeys,gpgPassword,disabled"`
 	Encrypted      *bool           `json:"encrypted" form:"encrypted"`
+	BaseRevision   *int64          `json:"baseRevision" form:"baseRevision"` // Server revision of the note which was edited on the client
 }
 
 func mapCreatingNoteToNote(note CreatingNote) models.Note {
@@ -35,6 +36,7 @@ func mapCreatingNoteToNote(note CreatingNote) models.Note {
 		TouchedAt:      note.TouchedAt,
 		EncryptionType: note.EncryptionType,
 		Encrypted:      encrypted,
+		BaseRevision:   note.BaseRevision,
 	}
 }
 
@@ -45,6 +47,15 @@ func mapCreatingNotesToNotes(notes []CreatingNote) (mappedNotes []models.Note) {
 	return
 }
 
+func hasPublishedNotes(notes []models.Note) bool {
+	for _, note := range notes {
+		if note.Meta.Published {
+			return true
+		}
+	}
+	return false
+}
+
 func mapNoteToDeletedNote(note models.Note) DeletedNote {
 	return DeletedNote{
 		ID:       note.ExternalID,
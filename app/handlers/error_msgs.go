@@ -1,8 +1,13 @@
 package handlers
 
 const (
-	ErrTokenNotProvided = "Token not provided"
-	ErrInvalidToken     = "Invalid token"
-	ErrAuthRequired     = "Auth required"
-	ErrAccessDenied     = "Access denied"
+	ErrTokenNotProvided  = "Token not provided"
+	ErrInvalidToken      = "Invalid token"
+	ErrAuthRequired      = "Auth required"
+	ErrAccessDenied      = "Access denied"
+	ErrInsufficientScope = "Token scope is insufficient"
+	ErrAdminRequired     = "Admin role is required"
+	ErrUserDisabled      = "Account is disabled"
+	ErrBodyTooLarge      = "Request body is too large"
+	ErrInvalidBody       = "Couldn't read request body"
 )
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const eventsHeartbeatInterval = 30 * time.Second
+
+type EventSubscriber interface {
+	Subscribe(userID string) (<-chan models.Event, func())
+}
+
+type EventsHandler struct {
+	subscriber EventSubscriber
+}
+
+// StreamEvents godoc
+// @Summary      Subscribe for user events
+// @Description  Server-Sent Events stream with note.updated, note.deleted, file.uploaded and file.deleted events.
+// @Description  Token could be provided via token query parameter when headers are not available
+// @Tags         events
+// @Produce      text/event-stream
+// @Param        token  query  string  false  "Auth token"
+// @Success      200  {object}  models.Event
+// @Failure      401  {object}  HttpError[any]
+// @Router       /events  [get]
+func (h *EventsHandler) StreamEvents(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	events, unsubscribe := h.subscriber.Subscribe(user.ID.Hex())
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+		heartbeat := time.NewTicker(eventsHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Error().Err(err).Msg("events handler: stream events: marshal event")
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// WebSocketEvents godoc
+// @Summary      Subscribe for user events via WebSocket
+// @Description  WebSocket variant of the events stream. Each message is a JSON encoded event
+// @Tags         events
+// @Param        token  query  string  false  "Auth token"
+// @Success      101  {object}  models.Event
+// @Failure      401  {object}  HttpError[any]
+// @Failure      426  {object}  HttpError[any]
+// @Router       /events/ws  [get]
+func (h *EventsHandler) WebSocketEvents(conn *websocket.Conn) {
+	user := conn.Locals("user").(*models.User)
+	events, unsubscribe := h.subscriber.Subscribe(user.ID.Hex())
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				log.Info().Err(err).Msg("events handler: websocket events: write event")
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func upgradeWebSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(NewHttpError[any]("WebSocket upgrade required", nil))
+	}
+	return c.Next()
+}
+
+func RegisterEventsHandler(app fiber.Router, subscriber EventSubscriber, authMiddleware fiber.Handler) {
+	eventsHandler := &EventsHandler{subscriber: subscriber}
+
+	readScope := NewScopeMiddleware(models.ScopeNotesRead)
+
+	app.Get("/events", authMiddleware, readScope, eventsHandler.StreamEvents)
+	app.Get("/events/ws", authMiddleware, readScope, upgradeWebSocket, websocket.New(eventsHandler.WebSocketEvents))
+}
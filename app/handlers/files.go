@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"net/url"
+	"orgnote/app/configs"
 	"orgnote/app/models"
 	"orgnote/app/services"
 	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
@@ -11,12 +15,13 @@ import (
 
 // UploadFiles godoc
 // @Summary      Upload files
-// @Description  Upload files.
+// @Description  Upload files. File names are sanitized, content type should be allowed
+// @Description  and uploaded files should fit into space limit. Result is returned for every file
 // @Tags         files
 // @Accept       json
 // @Produce      json
 // @Param        files   formData      []string  true  "files"
-// @Success      200  {object}  any
+// @Success      200  {object}  HttpResponse[[]models.UploadResult, any]
 // @Failure      400  {object}  HttpError[any]
 // @Failure      404  {object}  HttpError[any]
 // @Failure      500  {object}  HttpError[any]
@@ -32,29 +37,210 @@ func (h FilesHandlers) UploadFiles(c *fiber.Ctx) error {
 	}
 	files := form.File["files"]
 	// TODO: master check
-	err = h.fileService.UploadFiles(user.(*models.User), files)
+	results, err := h.fileService.UploadFiles(user.(*models.User), files)
 	if err != nil {
 		log.Error().Err(err).Msg("files handler: upload files: could not upload files")
 		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't upload files", nil))
 	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[[]models.UploadResult, any](results, nil))
+
+}
+
+// GetFiles godoc
+// @Summary      Get files
+// @Description  Return uploaded files of current user with notes which use them
+// @Tags         files
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[[]models.StoredFile, any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /files  [get]
+func (h FilesHandlers) GetFiles(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	files, err := h.fileService.GetFiles(user.ID.Hex())
+	if err != nil {
+		log.Error().Err(err).Msg("files handler: get files: could not get files")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't get files", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[[]models.StoredFile, any](files, nil))
+}
+
+// DeleteFile godoc
+// @Summary      Delete file
+// @Description  Delete uploaded file of current user
+// @Tags         files
+// @Accept       json
+// @Produce      json
+// @Param        name  path  string  true  "File name"
+// @Success      200  {object}  any
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /files/{name}  [delete]
+func (h FilesHandlers) DeleteFile(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	fileName, err := url.PathUnescape(c.Params("name"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid file name", nil))
+	}
+
+	err = h.fileService.DeleteFile(user, fileName)
+	if errors.Is(err, services.ErrInvalidFileName) {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid file name", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("files handler: delete file: could not delete file")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't delete file", nil))
+	}
 	return c.Status(http.StatusOK).JSON(nil)
+}
 
+const maxSignedURLExpiration = 7 * 24 * time.Hour
+
+// GetMedia godoc
+// @Summary      Get media file
+// @Description  Return uploaded file. File is available for its owner, by signed url
+// @Description  or when it's used by published note. Files from object storage are redirected to presigned url.
+// @Description  Images are resized to JPEG thumbnail when width is provided
+// @Tags         files
+// @Param        folder     path   string  true   "User id"
+// @Param        name       path   string  true   "File name"
+// @Param        w          query  int     false  "Width of image thumbnail, the closest configured width is used"
+// @Param        expires    query  string  false  "Signed url expiration time"
+// @Param        signature  query  string  false  "Signed url signature"
+// @Success      200
+// @Success      302
+// @Success      304
+// @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /media/{folder}/{name}  [get]
+func (h FilesHandlers) GetMedia(c *fiber.Ctx) error {
+	folder, folderErr := url.PathUnescape(c.Params("folder"))
+	fileName, nameErr := url.PathUnescape(c.Params("name"))
+	if folderErr != nil || nameErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid file name", nil))
+	}
+
+	user, _ := c.Locals("user").(*models.User)
+	media, err := h.fileService.GetMedia(folder, fileName, c.QueryInt("w"), services.MediaAccess{
+		User:      user,
+		Expires:   c.Query("expires"),
+		Signature: c.Query("signature"),
+	})
+	if errors.Is(err, services.ErrInvalidFileName) {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid file name", nil))
+	}
+	if errors.Is(err, services.ErrMediaAccessDenied) {
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any](ErrAccessDenied, nil))
+	}
+	if errors.Is(err, services.ErrMediaNotFound) {
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("File not found", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("files handler: get media: could not get file")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't get file", nil))
+	}
+
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderETag, media.ETag)
+	if c.Get(fiber.HeaderIfNoneMatch) == media.ETag {
+		if media.Content != nil {
+			media.Content.Close()
+		}
+		return c.SendStatus(http.StatusNotModified)
+	}
+	if media.RedirectURL != "" {
+		return c.Redirect(media.RedirectURL, http.StatusFound)
+	}
+
+	c.Set(fiber.HeaderContentType, media.File.MimeType)
+	return c.Status(http.StatusOK).SendStream(media.Content, int(media.File.Size))
+}
+
+type SignedURLParams struct {
+	ExpiresIn *int `query:"expiresIn"` // Lifetime of url in seconds
+}
+
+// GetSignedURL godoc
+// @Summary      Get signed file url
+// @Description  Create time limited url of the file, which could be opened without authorization
+// @Tags         files
+// @Produce      json
+// @Param        name       path   string  true   "File name"
+// @Param        expiresIn  query  int     false  "Lifetime of url in seconds, 7 days max"
+// @Success      200  {object}  HttpResponse[models.SignedURL, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /files/{name}/signed-url  [get]
+func (h FilesHandlers) GetSignedURL(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	fileName, err := url.PathUnescape(c.Params("name"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid file name", nil))
+	}
+
+	params := new(SignedURLParams)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input query", nil))
+	}
+
+	expiresIn := h.config.MediaURLExpiration
+	if params.ExpiresIn != nil {
+		expiresIn = time.Duration(*params.ExpiresIn) * time.Second
+	}
+	if expiresIn <= 0 || expiresIn > maxSignedURLExpiration {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Url lifetime should be between 1 second and 7 days", nil))
+	}
+
+	signedURL, err := h.fileService.SignMediaURL(user, fileName, expiresIn)
+	if errors.Is(err, services.ErrInvalidFileName) {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid file name", nil))
+	}
+	if errors.Is(err, services.ErrMediaNotFound) {
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("File not found", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("files handler: get signed url: could not sign url")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't sign file url", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.SignedURL, any](signedURL, nil))
 }
 
 type FilesHandlers struct {
 	fileService *services.FileService
+	config      configs.Config
 }
 
 func RegisterFileHandler(
 	app fiber.Router,
 	fileService *services.FileService,
+	config configs.Config,
 	authMiddleware func(*fiber.Ctx) error,
 	accessMiddleware func(*fiber.Ctx) error,
 ) {
+	fileHandlers := &FilesHandlers{
+		fileService: fileService,
+		config:      config,
+	}
+	readScope := NewScopeMiddleware(models.ScopeNotesRead)
+	writeScope := NewScopeMiddleware(models.ScopeFilesWrite)
+
+	app.Get("/files", authMiddleware, readScope, fileHandlers.GetFiles)
+	app.Post("/files/upload", authMiddleware, writeScope, accessMiddleware, fileHandlers.UploadFiles)
+	app.Get("/files/:name/signed-url", authMiddleware, readScope, fileHandlers.GetSignedURL)
+	app.Delete("/files/:name", authMiddleware, writeScope, fileHandlers.DeleteFile)
+}
+
+// Media route with access check, it replaces static media directory
+func RegisterMediaHandler(app fiber.Router, fileService *services.FileService) {
 	fileHandlers := &FilesHandlers{
 		fileService: fileService,
 	}
-	app.Post("/files/upload", authMiddleware, accessMiddleware, fileHandlers.UploadFiles)
-	// TODO: master implement
-	// app.Delete("/files/:id", noteHandlers.GetNote)
+	app.Get("/media/:folder/:name", fileHandlers.GetMedia)
 }
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"orgnote/app/models"
+	"orgnote/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+type LocalAuthHandlers struct {
+	localAuthService *services.LocalAuthService
+}
+
+func (h *LocalAuthHandlers) writeError(c *fiber.Ctx, err error, msg string) error {
+	switch {
+	case errors.Is(err, services.ErrRegistrationClosed):
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any]("Registration is closed", nil))
+	case errors.Is(err, services.ErrInvalidInviteCode):
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any]("Invalid invite code", nil))
+	case errors.Is(err, services.ErrEmailAlreadyUsed):
+		return c.Status(http.StatusConflict).JSON(NewHttpError[any]("Email is already used", nil))
+	case errors.Is(err, services.ErrInvalidEmail):
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid email", nil))
+	case errors.Is(err, services.ErrInvalidPassword):
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Password should contain from 8 to 128 characters", nil))
+	case errors.Is(err, services.ErrInvalidCredentials):
+		return c.Status(http.StatusUnauthorized).JSON(NewHttpError[any]("Invalid email or password", nil))
+	case errors.Is(err, services.ErrEmailNotVerified):
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any]("Email is not verified", nil))
+	case errors.Is(err, services.ErrInvalidActionToken):
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid or expired token", nil))
+	case errors.Is(err, services.ErrUserDisabled):
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any](ErrUserDisabled, nil))
+	case errors.Is(err, services.ErrMailerNotConfigured):
+		return c.Status(http.StatusNotImplemented).JSON(NewHttpError[any]("Email sending is not configured", nil))
+	}
+	log.Error().Err(err).Msgf("local auth handler: %s", msg)
+	return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any](msg, nil))
+}
+
+type RegisterBody struct {
+	Email      string `json:"email"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode"`
+}
+
+// Register godoc
+// @Summary      Register local account
+// @Description  Register account by email and password. Invite code is required when registration mode is invite.
+// @Description  Verification email is sent when SMTP is configured, login is not possible until email is verified
+// @Tags         auth
+// @Param        data  body  RegisterBody  true  "Account info"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.LocalRegistration, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/local/register  [post]
+func (h *LocalAuthHandlers) Register(c *fiber.Ctx) error {
+	body := new(RegisterBody)
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	registration, err := h.localAuthService.Register(services.RegisterParams{
+		Email:      body.Email,
+		Username:   body.Username,
+		Password:   body.Password,
+		InviteCode: body.InviteCode,
+	})
+	if err != nil {
+		return h.writeError(c, err, "Can't register user")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.LocalRegistration, any](registration, nil))
+}
+
+type LocalLoginBody struct {
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	DeviceName string `json:"deviceName"` // Name of the created session
+}
+
+// LocalLogin godoc
+// @Summary      Login by email and password
+// @Description  Return session token, it's used the same way as token from OAuth login
+// @Tags         auth
+// @Param        data  body  LocalLoginBody  true  "Credentials"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.AuthSession, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      401  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/local/login  [post]
+func (h *LocalAuthHandlers) Login(c *fiber.Ctx) error {
+	body := new(LocalLoginBody)
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	session, err := h.localAuthService.Login(body.Email, body.Password, services.LoginParams{
+		DeviceName: body.DeviceName,
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+	})
+	if err != nil {
+		return h.writeError(c, err, "Can't login")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AuthSession, any](session, nil))
+}
+
+type ActionTokenBody struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmail godoc
+// @Summary      Verify email
+// @Description  Verify email of local account by token from verification email
+// @Tags         auth
+// @Param        data  body  ActionTokenBody  true  "Token from email"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/local/verify-email  [post]
+func (h *LocalAuthHandlers) VerifyEmail(c *fiber.Ctx) error {
+	body := new(ActionTokenBody)
+	if err := c.BodyParser(body); err != nil || body.Token == "" {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any](ErrTokenNotProvided, nil))
+	}
+
+	if err := h.localAuthService.VerifyEmail(body.Token); err != nil {
+		return h.writeError(c, err, "Can't verify email")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+type EmailBody struct {
+	Email string `json:"email"`
+}
+
+// ResendVerificationEmail godoc
+// @Summary      Resend verification email
+// @Description  Send verification email again. Response doesn't depend on existence of the account
+// @Tags         auth
+// @Param        data  body  EmailBody  true  "Email"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      400  {object}  HttpError[any]
+// @Failure      501  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/local/verify-email/resend  [post]
+func (h *LocalAuthHandlers) ResendVerificationEmail(c *fiber.Ctx) error {
+	body := new(EmailBody)
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	if err := h.localAuthService.ResendVerificationEmail(body.Email); err != nil {
+		return h.writeError(c, err, "Can't send verification email")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+// ForgotPassword godoc
+// @Summary      Request password reset
+// @Description  Send password reset link to the email. Response doesn't depend on existence of the account
+// @Tags         auth
+// @Param        data  body  EmailBody  true  "Email"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      400  {object}  HttpError[any]
+// @Failure      501  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/local/password/forgot  [post]
+func (h *LocalAuthHandlers) ForgotPassword(c *fiber.Ctx) error {
+	body := new(EmailBody)
+	if err := c.BodyParser(body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	if err := h.localAuthService.RequestPasswordReset(body.Email); err != nil {
+		return h.writeError(c, err, "Can't send password reset email")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+type ResetPasswordBody struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ResetPassword godoc
+// @Summary      Reset password
+// @Description  Set new password by token from password reset email. Current session is closed
+// @Tags         auth
+// @Param        data  body  ResetPasswordBody  true  "Token from email and new password"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/local/password/reset  [post]
+func (h *LocalAuthHandlers) ResetPassword(c *fiber.Ctx) error {
+	body := new(ResetPasswordBody)
+	if err := c.BodyParser(body); err != nil || body.Token == "" {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any](ErrTokenNotProvided, nil))
+	}
+
+	if err := h.localAuthService.ResetPassword(body.Token, body.Password); err != nil {
+		return h.writeError(c, err, "Can't reset password")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+// CreateInvite godoc
+// @Summary      Create invite
+// @Description  Create one time invite code for registration of local account, it's valid for 7 days. Admin role is required
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.Invite, any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/local/invites  [post]
+func (h *LocalAuthHandlers) CreateInvite(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	invite, err := h.localAuthService.CreateInvite(user)
+	if err != nil {
+		return h.writeError(c, err, "Can't create invite")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.Invite, any](invite, nil))
+}
+
+func RegisterLocalAuthHandler(app fiber.Router, localAuthService *services.LocalAuthService, authMiddleware fiber.Handler) {
+	localAuthHandlers := &LocalAuthHandlers{
+		localAuthService: localAuthService,
+	}
+	accountScope := NewScopeMiddleware(models.ScopeAccount)
+
+	app.Post("/auth/local/register", localAuthHandlers.Register)
+	app.Post("/auth/local/login", localAuthHandlers.Login)
+	app.Post("/auth/local/verify-email", localAuthHandlers.VerifyEmail)
+	app.Post("/auth/local/verify-email/resend", localAuthHandlers.ResendVerificationEmail)
+	app.Post("/auth/local/password/forgot", localAuthHandlers.ForgotPassword)
+	app.Post("/auth/local/password/reset", localAuthHandlers.ResetPassword)
+	app.Post("/auth/local/invites", authMiddleware, accountScope, NewAdminMiddleware(), localAuthHandlers.CreateInvite)
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"net/http"
 	"orgnote/app/models"
@@ -16,6 +18,7 @@ import (
 
 type NoteHandlers struct {
 	noteService *services.NoteService
+	fileService *services.FileService
 }
 
 // TODO: master wait when swago will support generics :(
@@ -85,7 +88,7 @@ func (h *NoteHandlers) DeleteNotes(c *fiber.Ctx) error {
 
 // DeleteNotes godoc
 // @Summary      Drop all user notes
-// @Description  Force delete all user notes. This operation is irreversible
+// @Description  Force delete all user notes and files. This operation is irreversible
 // @Tags         notes
 // @Accept       json
 // @Produce      json
@@ -95,11 +98,18 @@ func (h *NoteHandlers) DeleteNotes(c *fiber.Ctx) error {
 // @Failure      500  {object}  HttpError[any]
 // @Router       /all-notes [delete]
 func (h *NoteHandlers) DeleteAllNotes(c *fiber.Ctx) error {
-	err := h.noteService.DeleteAllNotes(c.Locals("user").(*models.User).ID.Hex())
+	userID := c.Locals("user").(*models.User).ID.Hex()
+	err := h.noteService.DeleteAllNotes(userID)
 	if err != nil {
 		log.Info().Err(err).Msg("note handler: delete all notes")
 		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't delete notes, something went wrong", nil))
 	}
+
+	err = h.fileService.DeleteUserFiles(userID)
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: delete all notes: could not delete files")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't delete files, something went wrong", nil))
+	}
 	return nil
 }
 
@@ -124,8 +134,9 @@ func buildNotesFilter(user *models.User, filter *GetNotesFilter) *models.NoteFil
 		filter.UserID = &userId
 	}
 
+	// Private notes are available only to their owner
 	var published *bool
-	if filter.UserID == nil || user != nil && *filter.UserID != user.ID.Hex() {
+	if filter.UserID == nil || user == nil || *filter.UserID != user.ID.Hex() {
 		pub := true
 		published = &pub
 	}
@@ -190,6 +201,65 @@ func (h *NoteHandlers) GetNotes(c *fiber.Ctx) error {
 		}))
 }
 
+type SearchNotesFilter struct {
+	Query  string  `json:"q" query:"q" extensions:"x-order=1"`
+	Limit  *int64  `json:"limit" query:"limit" extensions:"x-order=2"`
+	Offset *int64  `json:"offset" query:"offset" extensions:"x-order=3"`
+	UserID *string `json:"userId" query:"userId" extensions:"x-order=4"` // User id of which notes to search
+	My     *bool   `json:"my" query:"my" extensions:"x-order=5"`         // Search only my own notes (user will be used from provided token)
+}
+
+// SearchNotes godoc
+// @Summary      Search notes
+// @Description  Full-text search over note title, description, headings, file tags and content.
+// @Description  Results are sorted by relevance and contain highlighted snippets
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        filter       query  SearchNotesFilter true "Search filter"
+// @Success      200  {object}  HttpResponse[[]models.NoteSearchResult, models.Pagination]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /notes/search  [get]
+func (h *NoteHandlers) SearchNotes(c *fiber.Ctx) error {
+	searchFilter := new(SearchNotesFilter)
+	if err := c.QueryParser(searchFilter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input query", nil))
+	}
+
+	if searchFilter.Query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Search query is required", nil))
+	}
+
+	ctxUser := c.Locals("user")
+
+	serviceFilter := buildNotesFilter(ctxUser.(*models.User), &GetNotesFilter{
+		Limit:      searchFilter.Limit,
+		Offset:     searchFilter.Offset,
+		UserID:     searchFilter.UserID,
+		SearchText: &searchFilter.Query,
+		My:         searchFilter.My,
+	})
+
+	var userID string
+	if ctxUser != (*models.User)(nil) {
+		userID = ctxUser.(*models.User).ID.Hex()
+	}
+
+	searchResults, err := h.noteService.SearchNotes(*serviceFilter, userID)
+	if err != nil {
+		log.Info().Err(err).Msgf("note handler: search notes: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't search notes, something went wrong", nil))
+	}
+
+	return c.Status(http.StatusOK).JSON(
+		NewHttpResponse(searchResults.Data, models.Pagination{
+			Limit:  searchResults.Limit,
+			Offset: searchResults.Offset,
+			Total:  searchResults.Total,
+		}))
+}
+
 // CreateNote godoc
 // @Summary      Create note
 // @Description  Create note
@@ -199,6 +269,7 @@ func (h *NoteHandlers) GetNotes(c *fiber.Ctx) error {
 // @Param        note       body  CreatingNote  true  "Note model"
 // @Success      200  {object}  any
 // @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
 // @Failure      404  {object}  HttpError[any]
 // @Failure      500  {object}  HttpError[any]
 // @Router       /notes/  [post]
@@ -213,6 +284,9 @@ func (h *NoteHandlers) CreateNote(c *fiber.Ctx) error {
 	author := c.Locals("user").(*models.User)
 	n := mapCreatingNoteToNote(*note)
 	n.AuthorID = author.ID.Hex()
+	if !hasTokenScope(c, models.ScopePublish) && hasPublishedNotes([]models.Note{n}) {
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any](ErrInsufficientScope, models.ScopePublish))
+	}
 	err := h.noteService.CreateNote(n)
 
 	if err != nil {
@@ -231,6 +305,7 @@ func (h *NoteHandlers) CreateNote(c *fiber.Ctx) error {
 // @Param        notes body []CreatingNote true "List of crated notes"
 // @Success      200  {object}  any
 // @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
 // @Failure      404  {object}  HttpError[any]
 // @Failure      500  {object}  HttpError[any]
 // @Router       /notes/bulk-upsert  [put]
@@ -244,6 +319,9 @@ func (h *NoteHandlers) UpsertNotes(c *fiber.Ctx) error {
 
 	user := c.Locals("user").(*models.User)
 	notes := mapCreatingNotesToNotes(notesForCreate)
+	if !hasTokenScope(c, models.ScopePublish) && hasPublishedNotes(notes) {
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any](ErrInsufficientScope, models.ScopePublish))
+	}
 	log.Info().Msgf("note handler: post note: create note id: %v, note external id: %v", notes[0].ID, notes[0].ExternalID)
 
 	err := h.noteService.BulkCreateOrUpdate(user.ID.Hex(), notes)
@@ -258,6 +336,7 @@ type SyncNotesRequest struct {
 	Timestamp       time.Time      `json:"timestamp"`
 	Notes           []CreatingNote `json:"notes"`
 	DeletedNotesIDs []string       `json:"deletedNotesIds"`
+	Cursor          *string        `json:"cursor"` // Cursor from the previous sync response. Empty string starts cursor sync from the beginning
 }
 
 type DeletedNote struct {
@@ -266,19 +345,25 @@ type DeletedNote struct {
 }
 
 type SyncNotesResponse struct {
-	Notes        []models.PublicNote `json:"notes"`
-	DeletedNotes []DeletedNote       `json:"deletedNotes"`
+	Notes        []models.PublicNote   `json:"notes"`
+	DeletedNotes []DeletedNote         `json:"deletedNotes"`
+	Conflicts    []models.NoteConflict `json:"conflicts"` // Notes changed on both sides which couldn't be merged
+	Cursor       *string               `json:"cursor,omitempty"`
+	HasMore      bool                  `json:"hasMore"` // More changes are available with returned cursor
 }
 
 // SyncNotes godoc
 // @Summary      Synchronize notes
-// @Description  Synchronize notes with specific timestamp
+// @Description  Synchronize notes with specific timestamp.
+// @Description  Notes with provided baseRevision are merged with concurrent server changes, unresolved ones are returned as conflicts.
+// @Description  When cursor is provided, changes are returned in pages by server change sequence instead of timestamp
 // @Tags         notes
 // @Accept       json
 // @Produce      json
 // @Param        data  body     SyncNotesRequest  true  "Sync notes request"
 // @Success      200  {object}  HttpResponse[SyncNotesResponse, any]
 // @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
 // @Failure      404  {object}  HttpError[any]
 // @Failure      500  {object}  HttpError[any]
 // @Router       /notes/sync  [post]
@@ -293,7 +378,15 @@ func (h *NoteHandlers) SyncNotes(c *fiber.Ctx) error {
 		return fmt.Errorf("can't parse body")
 	}
 	notesToSync := mapCreatingNotesToNotes(params.Notes)
-	notes, err := h.noteService.SyncNotes(notesToSync, params.DeletedNotesIDs, params.Timestamp, user)
+	if !hasTokenScope(c, models.ScopePublish) && hasPublishedNotes(notesToSync) {
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any](ErrInsufficientScope, models.ScopePublish))
+	}
+
+	if params.Cursor != nil {
+		return h.syncNotesByCursor(c, notesToSync, params, user)
+	}
+
+	syncResult, err := h.noteService.SyncNotes(notesToSync, params.DeletedNotesIDs, params.Timestamp, user)
 
 	if err != nil {
 		log.Info().Err(err).Msg("note handler: sync notes")
@@ -312,8 +405,225 @@ func (h *NoteHandlers) SyncNotes(c *fiber.Ctx) error {
 	}))
 
 	syncNotesResponse := SyncNotesResponse{
-		Notes:        notes,
+		Notes:        syncResult.Notes,
 		DeletedNotes: mapNotesToDeletedNotes(deletedNotes),
+		Conflicts:    syncResult.Conflicts,
+	}
+
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[SyncNotesResponse, any](syncNotesResponse, nil))
+}
+
+// GetBacklinks godoc
+// @Summary      Get note backlinks
+// @Description  Return notes which link to the note by id links
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Note ID"
+// @Success      200  {object}  HttpResponse[[]models.GraphNoteNode, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /notes/{id}/backlinks  [get]
+func (h *NoteHandlers) GetBacklinks(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	backlinks, err := h.noteService.GetBacklinks(user.ID.Hex(), c.Params("id"))
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: get backlinks")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't get backlinks, something went wrong", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[[]models.GraphNoteNode, any](backlinks, nil))
+}
+
+type GetNoteGraphFilter struct {
+	NoteID *string `json:"noteId" query:"noteId" extensions:"x-order=1"` // Return only neighbourhood of this note
+	Depth  *int    `json:"depth" query:"depth" extensions:"x-order=2"`   // Number of links from the note, 1 by default
+}
+
+// GetNoteGraph godoc
+// @Summary      Get note graph
+// @Description  Return graph of user notes connected by id links.
+// @Description  When noteId is provided only nodes within depth links from this note are returned
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        filter  query  GetNoteGraphFilter  false  "Graph filter"
+// @Success      200  {object}  HttpResponse[models.NoteGraph, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /graph  [get]
+func (h *NoteHandlers) GetNoteGraph(c *fiber.Ctx) error {
+	graphFilter := new(GetNoteGraphFilter)
+	if err := c.QueryParser(graphFilter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input query", nil))
+	}
+
+	depth := 1
+	if graphFilter.Depth != nil {
+		depth = *graphFilter.Depth
+	}
+	if depth < 1 || depth > services.MaxNoteGraphDepth {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			NewHttpError[any](fmt.Sprintf("Depth should be between 1 and %d", services.MaxNoteGraphDepth), nil),
+		)
+	}
+
+	noteID := ""
+	if graphFilter.NoteID != nil {
+		noteID = *graphFilter.NoteID
+	}
+
+	user := c.Locals("user").(*models.User)
+	graph, err := h.noteService.GetNoteGraph(user.ID.Hex(), noteID, depth)
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: get note graph")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't get note graph, something went wrong", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.NoteGraph, any](graph, nil))
+}
+
+var graphExportContentTypes = map[services.GraphExportFormat]string{
+	services.GraphExportDOT:     "text/vnd.graphviz; charset=utf-8",
+	services.GraphExportGraphML: "application/graphml+xml; charset=utf-8",
+	services.GraphExportJSON:    fiber.MIMEApplicationJSONCharsetUTF8,
+}
+
+// ExportNoteGraph godoc
+// @Summary      Export note graph
+// @Description  Download note graph as Graphviz DOT, GraphML or JSON Graph Format file
+// @Tags         notes
+// @Produce      plain
+// @Param        format  query  string  true  "Export format"  Enums(dot, graphml, json)
+// @Success      200  {string}  string
+// @Failure      400  {object}  HttpError[any]
+// @Router       /graph/export  [get]
+func (h *NoteHandlers) ExportNoteGraph(c *fiber.Ctx) error {
+	format := services.GraphExportFormat(c.Query("format"))
+	contentType, ok := graphExportContentTypes[format]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(NewHttpError[any]("Unknown format, use dot, graphml or json", nil))
+	}
+
+	userID := c.Locals("user").(*models.User).ID.Hex()
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"notes.%s\"", format))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := h.noteService.ExportNoteGraph(w, userID, format); err != nil {
+			log.Info().Err(err).Msg("note handler: export note graph: write graph")
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// GetNoteRevisions godoc
+// @Summary      Get note revisions
+// @Description  Get list of saved note revisions without content, newest first
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Note ID"
+// @Success      200  {object}  HttpResponse[[]models.NoteRevision, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /notes/{id}/revisions  [get]
+func (h *NoteHandlers) GetNoteRevisions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	revisions, err := h.noteService.GetNoteRevisions(c.Params("id"), user.ID.Hex())
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: get note revisions")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't get note revisions, something went wrong", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[[]models.NoteRevision, any](revisions, nil))
+}
+
+// GetNoteRevision godoc
+// @Summary      Get note revision
+// @Description  Get note revision with content
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Note ID"
+// @Param        rev  path      string  true  "Revision ID"
+// @Success      200  {object}  HttpResponse[models.NoteRevision, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /notes/{id}/revisions/{rev}  [get]
+func (h *NoteHandlers) GetNoteRevision(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	revision, err := h.noteService.GetNoteRevision(c.Params("id"), user.ID.Hex(), c.Params("rev"))
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: get note revision")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't get note revision, something went wrong", nil))
+	}
+	if revision == nil {
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("Revision not found", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.NoteRevision, any](revision, nil))
+}
+
+// RestoreNoteRevision godoc
+// @Summary      Restore note revision
+// @Description  Replace note with the content of the revision. Current note state will be saved as a new revision
+// @Tags         notes
+// @Accept       json
+// @Produce      json
+// @Param        id   path      string  true  "Note ID"
+// @Param        rev  path      string  true  "Revision ID"
+// @Success      200  {object}  HttpResponse[models.PublicNote, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /notes/{id}/revisions/{rev}/restore  [post]
+func (h *NoteHandlers) RestoreNoteRevision(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	revision, err := h.noteService.GetNoteRevision(c.Params("id"), user.ID.Hex(), c.Params("rev"))
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: restore note revision: get revision")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't restore note revision, something went wrong", nil))
+	}
+	if revision == nil {
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("Revision not found", nil))
+	}
+	if !hasTokenScope(c, models.ScopePublish) && revision.Meta.Published {
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any](ErrInsufficientScope, models.ScopePublish))
+	}
+
+	note, err := h.noteService.RestoreNoteRevision(c.Params("id"), c.Params("rev"), user)
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: restore note revision")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't restore note revision, something went wrong", nil))
+	}
+	if note == nil {
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("Revision not found", nil))
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.PublicNote, any](note, nil))
+}
+
+func (h *NoteHandlers) syncNotesByCursor(c *fiber.Ctx, notes []models.Note, params *SyncNotesRequest, user *models.User) error {
+	page, err := h.noteService.SyncNotesByCursor(notes, params.DeletedNotesIDs, *params.Cursor, user)
+
+	if errors.Is(err, services.ErrInvalidSyncCursor) {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid sync cursor", nil))
+	}
+
+	if err != nil {
+		log.Info().Err(err).Msg("note handler: sync notes by cursor")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Couldn't sync notes", nil))
+	}
+
+	syncNotesResponse := SyncNotesResponse{
+		Notes:        page.Notes,
+		DeletedNotes: mapNotesToDeletedNotes(page.DeletedNotes),
+		Conflicts:    page.Conflicts,
+		Cursor:       &page.Cursor,
+		HasMore:      page.HasMore,
 	}
 
 	return c.Status(http.StatusOK).JSON(NewHttpResponse[SyncNotesResponse, any](syncNotesResponse, nil))
@@ -322,17 +632,29 @@ func (h *NoteHandlers) SyncNotes(c *fiber.Ctx) error {
 func RegisterNoteHandler(
 	app fiber.Router,
 	noteService *services.NoteService,
+	fileService *services.FileService,
 	authMiddleware func(*fiber.Ctx) error,
 	accessMiddleware func(*fiber.Ctx) error,
 ) {
 	noteHandlers := &NoteHandlers{
 		noteService: noteService,
+		fileService: fileService,
 	}
-	app.Get("/notes/:id", noteHandlers.GetNote)
-	app.Get("/notes", noteHandlers.GetNotes)
-	app.Post("/notes/sync", authMiddleware, accessMiddleware, noteHandlers.SyncNotes)
-	app.Post("/notes", authMiddleware, accessMiddleware, noteHandlers.CreateNote)
-	app.Put("/notes/bulk-upsert", authMiddleware, noteHandlers.UpsertNotes)
-	app.Delete("/notes", authMiddleware, noteHandlers.DeleteNotes)
-	app.Delete("/all-notes", authMiddleware, noteHandlers.DeleteAllNotes)
+	readScope := NewScopeMiddleware(models.ScopeNotesRead)
+	writeScope := NewScopeMiddleware(models.ScopeNotesWrite)
+
+	app.Get("/notes/search", readScope, noteHandlers.SearchNotes)
+	app.Get("/notes/:id", readScope, noteHandlers.GetNote)
+	app.Get("/notes", readScope, noteHandlers.GetNotes)
+	app.Get("/notes/:id/backlinks", authMiddleware, readScope, noteHandlers.GetBacklinks)
+	app.Get("/graph", authMiddleware, readScope, noteHandlers.GetNoteGraph)
+	app.Get("/graph/export", authMiddleware, readScope, noteHandlers.ExportNoteGraph)
+	app.Get("/notes/:id/revisions", authMiddleware, readScope, noteHandlers.GetNoteRevisions)
+	app.Get("/notes/:id/revisions/:rev", authMiddleware, readScope, noteHandlers.GetNoteRevision)
+	app.Post("/notes/:id/revisions/:rev/restore", authMiddleware, writeScope, accessMiddleware, noteHandlers.RestoreNoteRevision)
+	app.Post("/notes/sync", authMiddleware, readScope, writeScope, accessMiddleware, noteHandlers.SyncNotes)
+	app.Post("/notes", authMiddleware, writeScope, accessMiddleware, noteHandlers.CreateNote)
+	app.Put("/notes/bulk-upsert", authMiddleware, writeScope, noteHandlers.UpsertNotes)
+	app.Delete("/notes", authMiddleware, writeScope, noteHandlers.DeleteNotes)
+	app.Delete("/all-notes", authMiddleware, writeScope, noteHandlers.DeleteAllNotes)
 }
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuildNotesFilterPublishedForOtherUsers(t *testing.T) {
+	owner := &models.User{ID: primitive.NewObjectID()}
+	ownerID := owner.ID.Hex()
+	anotherUser := &models.User{ID: primitive.NewObjectID()}
+
+	anonymousFilter := buildNotesFilter(nil, &GetNotesFilter{UserID: &ownerID})
+	require.NotNil(t, anonymousFilter.Published)
+	assert.True(t, *anonymousFilter.Published)
+
+	anotherUserFilter := buildNotesFilter(anotherUser, &GetNotesFilter{UserID: &ownerID})
+	require.NotNil(t, anotherUserFilter.Published)
+	assert.True(t, *anotherUserFilter.Published)
+
+	allNotesFilter := buildNotesFilter(owner, &GetNotesFilter{})
+	require.NotNil(t, allNotesFilter.Published)
+	assert.True(t, *allNotesFilter.Published)
+
+	ownerFilter := buildNotesFilter(owner, &GetNotesFilter{UserID: &ownerID})
+	assert.Nil(t, ownerFilter.Published)
+
+	my := true
+	myFilter := buildNotesFilter(owner, &GetNotesFilter{My: &my})
+	assert.Nil(t, myFilter.Published)
+	assert.Equal(t, ownerID, *myFilter.UserID)
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"orgnote/app/configs"
+	"orgnote/app/models"
+	"orgnote/app/services"
+	"orgnote/app/tools"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	tusVersion          = "1.0.0"
+	tusExtensions       = "creation,creation-with-upload,termination,expiration"
+	tusChunkContentType = "application/offset+octet-stream"
+)
+
+// Headers which should be readable by browser tus clients
+const TusExposedHeaders = "Location,Upload-Offset,Upload-Length,Upload-Expires,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Max-Size"
+
+type TusHandlers struct {
+	fileService *services.FileService
+	config      configs.Config
+}
+
+// Requests of unsupported protocol version are rejected
+func tusResumableMiddleware(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return c.Status(http.StatusPreconditionFailed).JSON(NewHttpError[any]("Unsupported tus protocol version", nil))
+	}
+	return c.Next()
+}
+
+// Chunk is read from the request stream, so it's never buffered in memory as a whole
+func getRequestBodyReader(c *fiber.Ctx) io.Reader {
+	if bodyStream := c.Context().RequestBodyStream(); bodyStream != nil {
+		return bodyStream
+	}
+	return bytes.NewReader(c.Body())
+}
+
+func (h *TusHandlers) setUploadHeaders(c *fiber.Ctx, upload *models.FileUpload) {
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+func (h *TusHandlers) writeChunkError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("Upload not found", nil))
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		return c.Status(http.StatusConflict).JSON(NewHttpError[any]("Upload offset doesn't match", nil))
+	case errors.Is(err, services.ErrUploadLengthExceeded):
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Chunk exceeds upload length", nil))
+	case errors.Is(err, services.ErrNoSpaceLeft):
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(NewHttpError[any]("No space left", nil))
+	case errors.Is(err, services.ErrFileTypeNotAllowed):
+		return c.Status(http.StatusUnsupportedMediaType).JSON(NewHttpError[any]("File type is not allowed", nil))
+	}
+	log.Error().Err(err).Msg("tus handler: write chunk: could not write upload chunk")
+	return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't write upload chunk", nil))
+}
+
+// GetTusOptions godoc
+// @Summary      Get tus server options
+// @Description  Supported tus protocol version, extensions and maximum upload size
+// @Tags         files
+// @Success      204
+// @Router       /files/tus  [options]
+func (h *TusHandlers) GetTusOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Max-Size", strconv.FormatInt(h.config.UploadMaxSize, 10))
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// CreateUpload godoc
+// @Summary      Create resumable upload
+// @Description  Create tus upload, file name is passed as filename key of Upload-Metadata header.
+// @Description  First chunk could be sent within the request body
+// @Tags         files
+// @Param        Tus-Resumable    header  string  true   "Protocol version, 1.0.0"
+// @Param        Upload-Length    header  int     true   "Size of the file in bytes"
+// @Param        Upload-Metadata  header  string  true   "Upload metadata"
+// @Success      201
+// @Failure      400  {object}  HttpError[any]
+// @Failure      412  {object}  HttpError[any]
+// @Failure      413  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /files/tus  [post]
+func (h *TusHandlers) CreateUpload(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Upload-Length header is required", nil))
+	}
+
+	metadata, err := tools.ParseTusMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid Upload-Metadata header", nil))
+	}
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+
+	upload, err := h.fileService.CreateUpload(user, fileName, length)
+	if errors.Is(err, services.ErrInvalidFileName) {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Invalid file name", nil))
+	}
+	if errors.Is(err, services.ErrUploadTooLarge) {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(NewHttpError[any]("Upload is too large", nil))
+	}
+	if errors.Is(err, services.ErrNoSpaceLeft) {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(NewHttpError[any]("No space left", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("tus handler: create upload: could not create upload")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't create upload", nil))
+	}
+
+	c.Set(fiber.HeaderLocation, h.config.BackendHost()+"/files/tus/"+upload.ID.Hex())
+
+	size := int64(c.Request().Header.ContentLength())
+	if c.Get(fiber.HeaderContentType) == tusChunkContentType && size > 0 {
+		upload, err = h.fileService.WriteUploadChunk(user, upload.ID.Hex(), 0, getRequestBodyReader(c), size)
+		if err != nil {
+			return h.writeChunkError(c, err)
+		}
+	}
+
+	h.setUploadHeaders(c, upload)
+	return c.SendStatus(http.StatusCreated)
+}
+
+// GetUploadOffset godoc
+// @Summary      Get upload offset
+// @Description  Return number of received bytes of the resumable upload
+// @Tags         files
+// @Param        id             path    string  true  "Upload id"
+// @Param        Tus-Resumable  header  string  true  "Protocol version, 1.0.0"
+// @Success      200
+// @Failure      404  {object}  HttpError[any]
+// @Failure      412  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /files/tus/{id}  [head]
+func (h *TusHandlers) GetUploadOffset(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	upload, err := h.fileService.GetUpload(user, c.Params("id"))
+	if errors.Is(err, services.ErrUploadNotFound) {
+		return c.SendStatus(http.StatusNotFound)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("tus handler: get upload offset: could not get upload")
+		return c.SendStatus(http.StatusInternalServerError)
+	}
+
+	h.setUploadHeaders(c, upload)
+	c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.SendStatus(http.StatusOK)
+}
+
+// WriteUploadChunk godoc
+// @Summary      Write upload chunk
+// @Description  Append chunk to the resumable upload. Chunk is streamed into storage, so Content-Length header is required.
+// @Description  File is created when the last chunk is received
+// @Tags         files
+// @Accept       application/offset+octet-stream
+// @Param        id             path    string  true  "Upload id"
+// @Param        Tus-Resumable  header  string  true  "Protocol version, 1.0.0"
+// @Param        Upload-Offset  header  int     true  "Offset of the chunk"
+// @Success      204
+// @Failure      400  {object}  HttpError[any]
+// @Failure      404  {object}  HttpError[any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      411  {object}  HttpError[any]
+// @Failure      412  {object}  HttpError[any]
+// @Failure      413  {object}  HttpError[any]
+// @Failure      415  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /files/tus/{id}  [patch]
+func (h *TusHandlers) WriteUploadChunk(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	if c.Get(fiber.HeaderContentType) != tusChunkContentType {
+		return c.Status(http.StatusUnsupportedMediaType).JSON(NewHttpError[any]("Content-Type should be "+tusChunkContentType, nil))
+	}
+
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Upload-Offset header is required", nil))
+	}
+
+	size := int64(c.Request().Header.ContentLength())
+	if size < 0 {
+		return c.Status(http.StatusLengthRequired).JSON(NewHttpError[any]("Content-Length header is required", nil))
+	}
+
+	upload, err := h.fileService.WriteUploadChunk(user, c.Params("id"), offset, getRequestBodyReader(c), size)
+	if err != nil {
+		return h.writeChunkError(c, err)
+	}
+
+	h.setUploadHeaders(c, upload)
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// DeleteUpload godoc
+// @Summary      Delete upload
+// @Description  Terminate resumable upload and remove received chunks
+// @Tags         files
+// @Param        id             path    string  true  "Upload id"
+// @Param        Tus-Resumable  header  string  true  "Protocol version, 1.0.0"
+// @Success      204
+// @Failure      404  {object}  HttpError[any]
+// @Failure      412  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /files/tus/{id}  [delete]
+func (h *TusHandlers) DeleteUpload(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	err := h.fileService.DeleteUpload(user, c.Params("id"))
+	if errors.Is(err, services.ErrUploadNotFound) {
+		return c.Status(http.StatusNotFound).JSON(NewHttpError[any]("Upload not found", nil))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("tus handler: delete upload: could not delete upload")
+		return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any]("Can't delete upload", nil))
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+func RegisterTusHandler(
+	app fiber.Router,
+	fileService *services.FileService,
+	config configs.Config,
+	authMiddleware func(*fiber.Ctx) error,
+	accessMiddleware func(*fiber.Ctx) error,
+) {
+	tusHandlers := &TusHandlers{
+		fileService: fileService,
+		config:      config,
+	}
+	writeScope := NewScopeMiddleware(models.ScopeFilesWrite)
+
+	app.Options("/files/tus", tusHandlers.GetTusOptions)
+	app.Post("/files/tus", authMiddleware, writeScope, tusResumableMiddleware, accessMiddleware, tusHandlers.CreateUpload)
+	app.Head("/files/tus/:id", authMiddleware, writeScope, tusResumableMiddleware, tusHandlers.GetUploadOffset)
+	app.Patch("/files/tus/:id", authMiddleware, writeScope, tusResumableMiddleware, accessMiddleware, tusHandlers.WriteUploadChunk)
+	app.Delete("/files/tus/:id", authMiddleware, writeScope, tusResumableMiddleware, tusHandlers.DeleteUpload)
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"orgnote/app/models"
+	"orgnote/app/services"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+type TwoFactorHandlers struct {
+	twoFactorService *services.TwoFactorService
+}
+
+func (h *TwoFactorHandlers) writeError(c *fiber.Ctx, err error, msg string) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode):
+		return c.Status(http.StatusUnauthorized).JSON(NewHttpError[any]("Invalid two factor code", nil))
+	case errors.Is(err, services.ErrTwoFactorLocked):
+		return c.Status(http.StatusTooManyRequests).JSON(NewHttpError[any]("Too many failed two factor attempts, try again later", nil))
+	case errors.Is(err, services.ErrInvalidActionToken):
+		return c.Status(http.StatusUnauthorized).JSON(NewHttpError[any]("Invalid or expired two factor challenge", nil))
+	case errors.Is(err, services.ErrTwoFactorEnabled):
+		return c.Status(http.StatusConflict).JSON(NewHttpError[any]("Two factor authentication is already enabled", nil))
+	case errors.Is(err, services.ErrTwoFactorNotEnabled):
+		return c.Status(http.StatusConflict).JSON(NewHttpError[any]("Two factor authentication is not enabled", nil))
+	case errors.Is(err, services.ErrTwoFactorNotSetUp):
+		return c.Status(http.StatusConflict).JSON(NewHttpError[any]("Two factor authentication setup is not started", nil))
+	case errors.Is(err, services.ErrUserDisabled):
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any](ErrUserDisabled, nil))
+	case errors.Is(err, services.ErrTwoFactorRequired):
+		return c.Status(http.StatusForbidden).JSON(NewHttpError[any]("Two factor authentication is required", nil))
+	}
+	log.Error().Err(err).Msgf("two factor handler: %s", msg)
+	return c.Status(http.StatusInternalServerError).JSON(NewHttpError[any](msg, nil))
+}
+
+type TwoFactorCodeBody struct {
+	Code string `json:"code"` // Code from authenticator app or recovery code
+}
+
+func parseTwoFactorCodeBody(c *fiber.Ctx) (*TwoFactorCodeBody, error) {
+	body := new(TwoFactorCodeBody)
+	if err := c.BodyParser(body); err != nil {
+		return nil, err
+	}
+	if body.Code == "" {
+		return nil, errors.New("code is required")
+	}
+	return body, nil
+}
+
+// GetTwoFactorStatus godoc
+// @Summary      Get two factor status
+// @Description
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.TwoFactorStatus, any]
+// @Router       /auth/2fa  [get]
+func (h *TwoFactorHandlers) GetStatus(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	status := h.twoFactorService.GetStatus(user)
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.TwoFactorStatus, any](status, nil))
+}
+
+// SetupTwoFactor godoc
+// @Summary      Setup two factor authentication
+// @Description  Generate secret for authenticator app. Two factor authentication is enabled after confirmation by code
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.TwoFactorSetup, any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/2fa/setup  [post]
+func (h *TwoFactorHandlers) Setup(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	setup, err := h.twoFactorService.Setup(user)
+	if err != nil {
+		return h.writeError(c, err, "Can't setup two factor authentication")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.TwoFactorSetup, any](setup, nil))
+}
+
+// EnableTwoFactor godoc
+// @Summary      Enable two factor authentication
+// @Description  Confirm secret by code from authenticator app. Recovery codes are returned only once
+// @Tags         auth
+// @Param        data  body  TwoFactorCodeBody  true  "Code from authenticator app"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.RecoveryCodes, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      401  {object}  HttpError[any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/2fa/enable  [post]
+func (h *TwoFactorHandlers) Enable(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	body, err := parseTwoFactorCodeBody(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	recoveryCodes, err := h.twoFactorService.Enable(user, body.Code)
+	if err != nil {
+		return h.writeError(c, err, "Can't enable two factor authentication")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.RecoveryCodes, any](recoveryCodes, nil))
+}
+
+// DisableTwoFactor godoc
+// @Summary      Disable two factor authentication
+// @Description  It's not possible when two factor authentication is required by the instance
+// @Tags         auth
+// @Param        data  body  TwoFactorCodeBody  true  "Code from authenticator app or recovery code"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  any
+// @Failure      400  {object}  HttpError[any]
+// @Failure      401  {object}  HttpError[any]
+// @Failure      403  {object}  HttpError[any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      429  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/2fa/disable  [post]
+func (h *TwoFactorHandlers) Disable(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	body, err := parseTwoFactorCodeBody(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	if err := h.twoFactorService.Disable(user, body.Code); err != nil {
+		return h.writeError(c, err, "Can't disable two factor authentication")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[any, any](nil, nil))
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      Regenerate recovery codes
+// @Description  Previous recovery codes become invalid
+// @Tags         auth
+// @Param        data  body  TwoFactorCodeBody  true  "Code from authenticator app or recovery code"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.RecoveryCodes, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      401  {object}  HttpError[any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      429  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/2fa/recovery-codes  [post]
+func (h *TwoFactorHandlers) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	body, err := parseTwoFactorCodeBody(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	recoveryCodes, err := h.twoFactorService.RegenerateRecoveryCodes(user, body.Code)
+	if err != nil {
+		return h.writeError(c, err, "Can't regenerate recovery codes")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.RecoveryCodes, any](recoveryCodes, nil))
+}
+
+type TwoFactorChallengeBody struct {
+	Token      string `json:"token"` // Challenge token returned by login
+	Code       string `json:"code"`
+	DeviceName string `json:"deviceName"` // Name of the created session
+}
+
+// SetupTwoFactorChallenge godoc
+// @Summary      Setup two factor authentication during login
+// @Description  Generate secret for authenticator app when challenge requires setup. Login is completed by code for this secret
+// @Tags         auth
+// @Param        data  body  ActionTokenBody  true  "Challenge token"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.TwoFactorSetup, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      401  {object}  HttpError[any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/2fa/challenge/setup  [post]
+func (h *TwoFactorHandlers) SetupChallenge(c *fiber.Ctx) error {
+	body := new(ActionTokenBody)
+	if err := c.BodyParser(body); err != nil || body.Token == "" {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any](ErrTokenNotProvided, nil))
+	}
+
+	setup, err := h.twoFactorService.SetupChallenge(body.Token)
+	if err != nil {
+		return h.writeError(c, err, "Can't setup two factor authentication")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.TwoFactorSetup, any](setup, nil))
+}
+
+// CompleteTwoFactorChallenge godoc
+// @Summary      Complete login by two factor code
+// @Description  Verify code from authenticator app or recovery code and return session token.
+// @Description  Challenge becomes invalid after several wrong codes
+// @Tags         auth
+// @Param        data  body  TwoFactorChallengeBody  true  "Challenge token and code"
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  HttpResponse[models.AuthSession, any]
+// @Failure      400  {object}  HttpError[any]
+// @Failure      401  {object}  HttpError[any]
+// @Failure      409  {object}  HttpError[any]
+// @Failure      429  {object}  HttpError[any]
+// @Failure      500  {object}  HttpError[any]
+// @Router       /auth/2fa/challenge  [post]
+func (h *TwoFactorHandlers) CompleteChallenge(c *fiber.Ctx) error {
+	body := new(TwoFactorChallengeBody)
+	if err := c.BodyParser(body); err != nil || body.Token == "" || body.Code == "" {
+		return c.Status(http.StatusBadRequest).JSON(NewHttpError[any]("Incorrect input body", nil))
+	}
+
+	session, err := h.twoFactorService.CompleteChallenge(body.Token, body.Code, services.LoginParams{
+		DeviceName: body.DeviceName,
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+	})
+	if err != nil {
+		return h.writeError(c, err, "Can't complete login")
+	}
+	return c.Status(http.StatusOK).JSON(NewHttpResponse[*models.AuthSession, any](session, nil))
+}
+
+func RegisterTwoFactorHandler(app fiber.Router, twoFactorService *services.TwoFactorService, authMiddleware fiber.Handler) {
+	twoFactorHandlers := &TwoFactorHandlers{
+		twoFactorService: twoFactorService,
+	}
+	accountScope := NewScopeMiddleware(models.ScopeAccount)
+
+	app.Get("/auth/2fa", authMiddleware, accountScope, twoFactorHandlers.GetStatus)
+	app.Post("/auth/2fa/setup", authMiddleware, accountScope, twoFactorHandlers.Setup)
+	app.Post("/auth/2fa/enable", authMiddleware, accountScope, twoFactorHandlers.Enable)
+	app.Post("/auth/2fa/disable", authMiddleware, accountScope, twoFactorHandlers.Disable)
+	app.Post("/auth/2fa/recovery-codes", authMiddleware, accountScope, twoFactorHandlers.RegenerateRecoveryCodes)
+	app.Post("/auth/2fa/challenge/setup", twoFactorHandlers.SetupChallenge)
+	app.Post("/auth/2fa/challenge", twoFactorHandlers.CompleteChallenge)
+}
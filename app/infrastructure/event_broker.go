@@ -0,0 +1,63 @@
+package infrastructure
+
+import (
+	"orgnote/app/models"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const eventSubscriberBufferSize = 32
+
+// In-process broker for user events.
+// Delivers events only to subscribers of the same instance, slow subscribers lose events
+type EventBroker struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan models.Event]struct{}
+}
+
+func (b *EventBroker) Publish(event models.Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			log.Warn().Msgf("event broker: publish: subscriber of user %s is too slow, event %s dropped", event.UserID, event.Type)
+		}
+	}
+}
+
+// Subscribe for user events. Returned function should be called to release the subscription
+func (b *EventBroker) Subscribe(userID string) (<-chan models.Event, func()) {
+	ch := make(chan models.Event, eventSubscriberBufferSize)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = map[chan models.Event]struct{}{}
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers[userID], ch)
+			if len(b.subscribers[userID]) == 0 {
+				delete(b.subscribers, userID)
+			}
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: map[string]map[chan models.Event]struct{}{},
+	}
+}
@@ -0,0 +1,96 @@
+package infrastructure
+
+import (
+	"orgnote/app/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveEvent(t *testing.T, events <-chan models.Event) models.Event {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "subscription is closed")
+		return event
+	case <-time.After(time.Second):
+		require.Fail(t, "event is not received")
+		return models.Event{}
+	}
+}
+
+func TestEventBrokerDeliversEventsToSubscriber(t *testing.T) {
+	broker := NewEventBroker()
+	events, unsubscribe := broker.Subscribe("user1")
+	defer unsubscribe()
+
+	broker.Publish(models.Event{Type: models.EventNoteUpdated, UserID: "user1", IDs: []string{"note1"}})
+
+	event := receiveEvent(t, events)
+	assert.Equal(t, models.EventNoteUpdated, event.Type)
+	assert.Equal(t, []string{"note1"}, event.IDs)
+}
+
+func TestEventBrokerFansOutEventsPerUser(t *testing.T) {
+	broker := NewEventBroker()
+	firstDevice, unsubscribeFirst := broker.Subscribe("user1")
+	defer unsubscribeFirst()
+	secondDevice, unsubscribeSecond := broker.Subscribe("user1")
+	defer unsubscribeSecond()
+	otherUser, unsubscribeOther := broker.Subscribe("user2")
+	defer unsubscribeOther()
+
+	broker.Publish(models.Event{Type: models.EventFileDeleted, UserID: "user1", IDs: []string{"a.png"}})
+
+	assert.Equal(t, models.EventFileDeleted, receiveEvent(t, firstDevice).Type)
+	assert.Equal(t, models.EventFileDeleted, receiveEvent(t, secondDevice).Type)
+	assert.Empty(t, otherUser)
+}
+
+func TestEventBrokerDoesNotBlockOnFullSubscriber(t *testing.T) {
+	broker := NewEventBroker()
+	events, unsubscribe := broker.Subscribe("user1")
+	defer unsubscribe()
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < eventSubscriberBufferSize+10; i++ {
+			broker.Publish(models.Event{Type: models.EventNoteUpdated, UserID: "user1"})
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		require.Fail(t, "publish is blocked by full subscriber")
+	}
+	assert.Len(t, events, eventSubscriberBufferSize)
+}
+
+func TestEventBrokerUnsubscribe(t *testing.T) {
+	broker := NewEventBroker()
+	events, unsubscribe := broker.Subscribe("user1")
+
+	unsubscribe()
+	unsubscribe()
+	broker.Publish(models.Event{Type: models.EventNoteDeleted, UserID: "user1"})
+
+	_, ok := <-events
+	assert.False(t, ok)
+	assert.NotContains(t, broker.subscribers, "user1")
+}
+
+func TestEventBrokerUnsubscribeKeepsOtherSubscribers(t *testing.T) {
+	broker := NewEventBroker()
+	_, unsubscribeFirst := broker.Subscribe("user1")
+	secondDevice, unsubscribeSecond := broker.Subscribe("user1")
+	defer unsubscribeSecond()
+
+	unsubscribeFirst()
+	broker.Publish(models.Event{Type: models.EventNoteDeleted, UserID: "user1"})
+
+	assert.Len(t, broker.subscribers["user1"], 1)
+	assert.Equal(t, models.EventNoteDeleted, receiveEvent(t, secondDevice).Type)
+}
@@ -1,82 +1,181 @@
 package infrastructure
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
+	"orgnote/app/models"
 	"os"
 	"path"
-
-	"github.com/rs/zerolog/log"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-type FileStorage struct {
+var errInvalidFilePath = errors.New("file path is outside of storage directory")
+
+// Local disk storage driver, files are streamed by the media handler
+type LocalFileStorage struct {
 	dirPath string
 }
 
-func (f *FileStorage) Upload(folder string, fileName string, file io.Reader) error {
-	fileData, err := io.ReadAll(file)
+func NewLocalFileStorage(dirPath string) *LocalFileStorage {
+	return &LocalFileStorage{
+		dirPath: dirPath,
+	}
+}
+
+func (f *LocalFileStorage) Put(folder string, fileName string, file io.Reader, size int64) error {
+	filePath, err := f.getFullPath(folder, fileName)
 	if err != nil {
-		return fmt.Errorf("file storage: upload: could not read file: %v", err)
+		return fmt.Errorf("file storage: put: %v", err)
 	}
 
-	finalFolder := f.getFullPath(folder)
-	err = os.MkdirAll(finalFolder, os.ModePerm)
+	err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("file storage: put: could not create file directory: %v", err)
+	}
+
+	// Write into temporary file first, so partially uploaded file is never visible
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), "."+fileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("file storage: put: could not create temporary file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
 
+	_, err = io.Copy(tmpFile, file)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return fmt.Errorf("file storage: upload: could not create file directory: %v", err)
+		return fmt.Errorf("file storage: put: could not write file: %v", err)
 	}
 
-	filePath := f.getFullPath(folder, fileName)
-	err = os.WriteFile(filePath, fileData, 0644)
+	err = os.Chmod(tmpFile.Name(), 0644)
 	if err != nil {
-		return fmt.Errorf("file storage: upload: could not write file: %v", err)
+		return fmt.Errorf("file storage: put: could not change file mode: %v", err)
+	}
+
+	err = os.Rename(tmpFile.Name(), filePath)
+	if err != nil {
+		return fmt.Errorf("file storage: put: could not move file: %v", err)
 	}
 
 	return nil
 }
 
-func (f *FileStorage) getFullPath(filePath ...string) string {
-	return "./" + path.Join(f.dirPath, path.Join(filePath...))
+func (f *LocalFileStorage) Get(folder string, fileName string) (io.ReadCloser, error) {
+	filePath, err := f.getFullPath(folder, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("file storage: get: %v", err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("file storage: get: could not open file: %w", err)
+	}
+	return file, nil
+}
+
+func (f *LocalFileStorage) Stat(folder string, fileName string) (*models.StoredFile, error) {
+	filePath, err := f.getFullPath(folder, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("file storage: stat: %v", err)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("file storage: stat: could not get file info: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("file storage: stat: %s is a directory: %w", fileName, fs.ErrNotExist)
+	}
+
+	return &models.StoredFile{
+		Name:      fileName,
+		Size:      info.Size(),
+		MimeType:  mime.TypeByExtension(path.Ext(fileName)),
+		UpdatedAt: info.ModTime(),
+	}, nil
 }
 
-// Return file size in bytes
-func (f *FileStorage) CalculateFileSize(folder string, fileName ...string) (int64, error) {
-	fileSize := int64(0)
+// Return files of the folder, missing folder has no files
+func (f *LocalFileStorage) List(folder string) ([]models.StoredFile, error) {
+	folderPath, err := f.getFullPath(folder)
+	if err != nil {
+		return nil, fmt.Errorf("file storage: list: %v", err)
+	}
 
-	for _, name := range fileName {
-		errCh := make(chan error)
-		resCh := make(chan int64)
-		go f.getFileSize(folder, name, resCh, errCh)
+	entries, err := os.ReadDir(folderPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.StoredFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file storage: list: could not read directory: %v", err)
+	}
 
-		select {
-		case err := <-errCh:
-			log.Err(err).Msg("file storage: calculate file size: could not get file size")
-		case res := <-resCh:
-			fileSize += res
+	files := []models.StoredFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("file storage: list: could not get file info: %v", err)
 		}
+		files = append(files, models.StoredFile{
+			Name:      entry.Name(),
+			Size:      info.Size(),
+			MimeType:  mime.TypeByExtension(path.Ext(entry.Name())),
+			UpdatedAt: info.ModTime(),
+		})
 	}
-
-	return fileSize, nil
+	return files, nil
 }
 
-func (f *FileStorage) getFileSize(folder string, fileName string, resCh chan<- int64, errCh chan<- error) {
-	file, err := os.Open(f.getFullPath(folder, fileName))
+// Return names of top level folders
+func (f *LocalFileStorage) ListFolders() ([]string, error) {
+	entries, err := os.ReadDir(f.dirPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
 	if err != nil {
-		errCh <- fmt.Errorf("file storage: get file size: could not open file: %v", err)
-		return
+		return nil, fmt.Errorf("file storage: list folders: could not read directory: %v", err)
+	}
+
+	folders := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			folders = append(folders, entry.Name())
+		}
 	}
-	defer file.Close()
+	return folders, nil
+}
 
-	fileInfo, err := file.Stat()
+func (f *LocalFileStorage) Delete(folder string, fileName string) error {
+	filePath, err := f.getFullPath(folder, fileName)
 	if err != nil {
-		errCh <- fmt.Errorf("file storage: get file size: could not get file info: %v", err)
+		return fmt.Errorf("file storage: delete: %v", err)
 	}
 
-	resCh <- fileInfo.Size()
+	err = os.Remove(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("file storage: delete: could not remove file: %v", err)
+	}
+	return nil
 }
 
-func NewFileStorage(dirPath string) *FileStorage {
-	return &FileStorage{
-		dirPath: dirPath,
+// Local files have no direct urls, they should be read with Get
+func (f *LocalFileStorage) Presign(folder string, fileName string, expires time.Duration, contentType string) (string, error) {
+	return "", fmt.Errorf("file storage: presign: %w", errors.ErrUnsupported)
+}
+
+func (f *LocalFileStorage) getFullPath(filePath ...string) (string, error) {
+	relativePath := filepath.Join(filePath...)
+	if !filepath.IsLocal(relativePath) {
+		return "", errInvalidFilePath
 	}
+	return filepath.Join(f.dirPath, relativePath), nil
 }
@@ -0,0 +1,80 @@
+package infrastructure
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fileStorageDriver interface {
+	Put(folder string, fileName string, file io.Reader, size int64) error
+	Get(folder string, fileName string) (io.ReadCloser, error)
+	Delete(folder string, fileName string) error
+	ListFolders() ([]string, error)
+}
+
+// Common behaviour of storage drivers
+func testFileStorageDriver(t *testing.T, storage fileStorageDriver, stat func(folder, fileName string) (int64, string, error)) {
+	content := "image content"
+	err := storage.Put("user", "image.png", strings.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	file, err := storage.Get("user", "image.png")
+	require.NoError(t, err)
+	data, err := io.ReadAll(file)
+	file.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	size, mimeType, err := stat("user", "image.png")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, "image/png", mimeType)
+
+	folders, err := storage.ListFolders()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user"}, folders)
+
+	_, _, err = stat("user", "missing.png")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = storage.Get("user", "missing.png")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	assert.NoError(t, storage.Delete("user", "image.png"))
+	_, _, err = stat("user", "image.png")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestLocalFileStorage(t *testing.T) {
+	storage := NewLocalFileStorage(t.TempDir())
+
+	testFileStorageDriver(t, storage, func(folder, fileName string) (int64, string, error) {
+		file, err := storage.Stat(folder, fileName)
+		if err != nil {
+			return 0, "", err
+		}
+		return file.Size, file.MimeType, nil
+	})
+
+	files, err := storage.List("missing")
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	_, err = storage.Presign("user", "image.png", time.Hour, "image/png")
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestLocalFileStorageRejectsPathTraversal(t *testing.T) {
+	storage := NewLocalFileStorage(t.TempDir())
+
+	err := storage.Put("user", "../../image.png", strings.NewReader("data"), 4)
+
+	assert.ErrorContains(t, err, errInvalidFilePath.Error())
+}
@@ -0,0 +1,188 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+	"golang.org/x/oauth2"
+)
+
+// Time between discovery attempts of the issuer which was not available
+const oidcDiscoveryRetryInterval = 30 * time.Second
+
+// Claims used for user fields, the first not empty claim is used. Standard claims are used for empty lists
+type OIDCClaims struct {
+	ID        []string
+	Name      []string
+	NickName  []string
+	Email     []string
+	AvatarURL []string
+	FirstName []string
+	LastName  []string
+}
+
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	CallbackURL  string
+	Scopes       []string
+	Claims       OIDCClaims
+}
+
+// Create goth provider from issuer discovery document, provider is registered by config name
+func NewOIDCProvider(config OIDCConfig) (*openidConnect.Provider, error) {
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	provider, err := openidConnect.New(config.ClientID, config.ClientSecret, config.CallbackURL, discoveryURL, config.Scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("oidc provider: %s: discover configuration: %v", config.Name, err)
+	}
+	if strings.TrimSuffix(provider.OpenIDConfig.Issuer, "/") != strings.TrimSuffix(config.Issuer, "/") {
+		return nil, fmt.Errorf("oidc provider: %s: discovered issuer %s doesn't match configured one", config.Name, provider.OpenIDConfig.Issuer)
+	}
+	provider.SetName(config.Name)
+
+	setClaims := func(target *[]string, claims []string) {
+		if len(claims) > 0 {
+			*target = claims
+		}
+	}
+	setClaims(&provider.UserIdClaims, config.Claims.ID)
+	setClaims(&provider.NameClaims, config.Claims.Name)
+	setClaims(&provider.NickNameClaims, config.Claims.NickName)
+	setClaims(&provider.EmailClaims, config.Claims.Email)
+	setClaims(&provider.AvatarURLClaims, config.Claims.AvatarURL)
+	setClaims(&provider.FirstNameClaims, config.Claims.FirstName)
+	setClaims(&provider.LastNameClaims, config.Claims.LastName)
+	return provider, nil
+}
+
+// Provider which discovers issuer configuration on first use. Issuer which is not available
+// on start doesn't block other login methods, discovery is retried on the next login
+type LazyOIDCProvider struct {
+	config        OIDCConfig
+	retryInterval time.Duration
+	mu            sync.Mutex
+	provider      *openidConnect.Provider
+	lastErr       error
+	lastAttemptAt time.Time
+	debug         bool
+}
+
+func NewLazyOIDCProvider(config OIDCConfig) *LazyOIDCProvider {
+	return &LazyOIDCProvider{config: config, retryInterval: oidcDiscoveryRetryInterval}
+}
+
+// Return discovered provider, failed discovery is retried after retry interval
+func (p *LazyOIDCProvider) Discover() (*openidConnect.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return p.provider, nil
+	}
+	if p.lastErr != nil && time.Since(p.lastAttemptAt) < p.retryInterval {
+		return nil, p.lastErr
+	}
+
+	p.lastAttemptAt = time.Now()
+	provider, err := NewOIDCProvider(p.config)
+	if err != nil {
+		p.lastErr = err
+		return nil, err
+	}
+	provider.Debug(p.debug)
+	p.provider = provider
+	p.lastErr = nil
+	return provider, nil
+}
+
+func (p *LazyOIDCProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *LazyOIDCProvider) SetName(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.config.Name = name
+	if p.provider != nil {
+		p.provider.SetName(name)
+	}
+}
+
+func (p *LazyOIDCProvider) BeginAuth(state string) (goth.Session, error) {
+	provider, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	session, err := provider.BeginAuth(state)
+	if err != nil {
+		return nil, err
+	}
+	return &lazyOIDCSession{Session: session, provider: provider}, nil
+}
+
+func (p *LazyOIDCProvider) UnmarshalSession(data string) (goth.Session, error) {
+	provider, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	session, err := provider.UnmarshalSession(data)
+	if err != nil {
+		return nil, err
+	}
+	return &lazyOIDCSession{Session: session, provider: provider}, nil
+}
+
+func (p *LazyOIDCProvider) FetchUser(session goth.Session) (goth.User, error) {
+	provider, err := p.Discover()
+	if err != nil {
+		return goth.User{}, err
+	}
+	if lazySession, ok := session.(*lazyOIDCSession); ok {
+		session = lazySession.Session
+	}
+	return provider.FetchUser(session)
+}
+
+func (p *LazyOIDCProvider) Debug(debug bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.debug = debug
+	if p.provider != nil {
+		p.provider.Debug(debug)
+	}
+}
+
+func (p *LazyOIDCProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	provider, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	return provider.RefreshToken(refreshToken)
+}
+
+func (p *LazyOIDCProvider) RefreshTokenAvailable() bool {
+	provider, err := p.Discover()
+	if err != nil {
+		return false
+	}
+	return provider.RefreshTokenAvailable()
+}
+
+// OpenID Connect session is authorized only by the discovered provider, not by its lazy wrapper
+type lazyOIDCSession struct {
+	goth.Session
+	provider *openidConnect.Provider
+}
+
+func (s *lazyOIDCSession) Authorize(_ goth.Provider, params goth.Params) (string, error) {
+	return s.Session.Authorize(s.provider, params)
+}
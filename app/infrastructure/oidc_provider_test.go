@@ -0,0 +1,188 @@
+package infrastructure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeJWTPart(t *testing.T, value any) string {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Issuer with discovery, token and userinfo endpoints, it returns the same user for every code
+func startMockOIDCIssuer(t *testing.T, clientID string) *httptest.Server {
+	available := &atomic.Bool{}
+	available.Store(true)
+	return startToggledMockOIDCIssuer(t, clientID, available)
+}
+
+// Mock issuer which responds with service unavailable while available is false
+func startToggledMockOIDCIssuer(t *testing.T, clientID string, available *atomic.Bool) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/auth",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idToken := encodeJWTPart(t, map[string]string{"alg": "none"}) + "." +
+			encodeJWTPart(t, map[string]any{
+				"iss": server.URL,
+				"aud": clientID,
+				"sub": "user-1",
+				"exp": time.Now().Add(time.Hour).Unix(),
+				"upn": "jdoe@corp.example.com",
+			}) + "."
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken + "signature",
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"sub":                "user-1",
+			"name":               "John Doe",
+			"preferred_username": "jdoe",
+			"email":              "john@example.com",
+			"profile":            "https://corp.example.com/people/jdoe",
+		})
+	})
+	return server
+}
+
+func TestOIDCProviderLogin(t *testing.T) {
+	issuer := startMockOIDCIssuer(t, "orgnote")
+
+	provider, err := NewOIDCProvider(OIDCConfig{
+		Name:         "keycloak",
+		Issuer:       issuer.URL,
+		ClientID:     "orgnote",
+		ClientSecret: "secret",
+		CallbackURL:  "http://localhost/v1/auth/keycloak/callback",
+		Scopes:       []string{"profile", "email"},
+		Claims:       OIDCClaims{Email: []string{"upn", "email"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", provider.Name())
+
+	session, err := provider.BeginAuth("state")
+	require.NoError(t, err)
+	authURL, err := session.GetAuthURL()
+	require.NoError(t, err)
+	parsedAuthURL, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, issuer.URL+"/auth", parsedAuthURL.Scheme+"://"+parsedAuthURL.Host+parsedAuthURL.Path)
+	assert.Equal(t, "orgnote", parsedAuthURL.Query().Get("client_id"))
+	assert.Equal(t, "profile email openid", parsedAuthURL.Query().Get("scope"))
+
+	_, err = session.Authorize(provider, url.Values{"code": {"valid-code"}})
+	require.NoError(t, err)
+
+	user, err := provider.FetchUser(session)
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", user.Provider)
+	assert.Equal(t, "user-1", user.UserID)
+	assert.Equal(t, "John Doe", user.Name)
+	assert.Equal(t, "jdoe", user.NickName)
+	assert.Equal(t, "jdoe@corp.example.com", user.Email)
+	assert.Equal(t, "https://corp.example.com/people/jdoe", user.RawData["profile"])
+}
+
+func TestOIDCProviderInvalidCode(t *testing.T) {
+	issuer := startMockOIDCIssuer(t, "orgnote")
+	provider, err := NewOIDCProvider(OIDCConfig{Name: "keycloak", Issuer: issuer.URL, ClientID: "orgnote", ClientSecret: "secret"})
+	require.NoError(t, err)
+
+	session, err := provider.BeginAuth("state")
+	require.NoError(t, err)
+	_, err = session.Authorize(provider, url.Values{"code": {"invalid-code"}})
+	assert.Error(t, err)
+}
+
+func TestOIDCProviderWrongAudience(t *testing.T) {
+	issuer := startMockOIDCIssuer(t, "another-client")
+	provider, err := NewOIDCProvider(OIDCConfig{Name: "keycloak", Issuer: issuer.URL, ClientID: "orgnote", ClientSecret: "secret"})
+	require.NoError(t, err)
+
+	session, err := provider.BeginAuth("state")
+	require.NoError(t, err)
+	_, err = session.Authorize(provider, url.Values{"code": {"valid-code"}})
+	require.NoError(t, err)
+	_, err = provider.FetchUser(session)
+	assert.Error(t, err)
+}
+
+func TestOIDCProviderDiscoveryFailed(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := NewOIDCProvider(OIDCConfig{Name: "keycloak", Issuer: server.URL, ClientID: "orgnote", ClientSecret: "secret"})
+	assert.Error(t, err)
+}
+
+func TestLazyOIDCProviderRetriesDiscovery(t *testing.T) {
+	available := &atomic.Bool{}
+	issuer := startToggledMockOIDCIssuer(t, "orgnote", available)
+	provider := NewLazyOIDCProvider(OIDCConfig{Name: "keycloak", Issuer: issuer.URL, ClientID: "orgnote", ClientSecret: "secret"})
+
+	_, err := provider.Discover()
+	require.Error(t, err)
+	_, err = provider.BeginAuth("state")
+	assert.Error(t, err)
+	assert.False(t, provider.RefreshTokenAvailable())
+
+	available.Store(true)
+	_, err = provider.BeginAuth("state")
+	assert.Error(t, err, "discovery is not retried before retry interval")
+
+	provider.retryInterval = 0
+	session, err := provider.BeginAuth("state")
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", provider.Name())
+
+	restoredSession, err := provider.UnmarshalSession(session.Marshal())
+	require.NoError(t, err)
+	_, err = restoredSession.Authorize(provider, url.Values{"code": {"valid-code"}})
+	require.NoError(t, err)
+
+	user, err := provider.FetchUser(restoredSession)
+	require.NoError(t, err)
+	assert.Equal(t, "keycloak", user.Provider)
+	assert.Equal(t, "user-1", user.UserID)
+}
@@ -0,0 +1,190 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"orgnote/app/models"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	s3RequestTimeout = 30 * time.Second
+	s3UploadTimeout  = 10 * time.Minute
+)
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3 compatible storage driver (AWS S3, MinIO, etc.), files are stored by folder/fileName keys
+type S3FileStorage struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3FileStorage(config S3Config) (*S3FileStorage, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3 file storage: new s3 file storage: could not create client: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("s3 file storage: new s3 file storage: could not check bucket: %v", err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region})
+		if err != nil {
+			return nil, fmt.Errorf("s3 file storage: new s3 file storage: could not create bucket: %v", err)
+		}
+	}
+
+	return &S3FileStorage{
+		client: client,
+		bucket: config.Bucket,
+	}, nil
+}
+
+func (s *S3FileStorage) getKey(folder string, fileName string) string {
+	return folder + "/" + fileName
+}
+
+// Convert missing object errors into fs.ErrNotExist, as it's done by local storage
+func (s *S3FileStorage) wrapError(err error) error {
+	code := minio.ToErrorResponse(err).Code
+	if code == "NoSuchKey" || code == "NotFound" {
+		return fmt.Errorf("%v: %w", err, fs.ErrNotExist)
+	}
+	return err
+}
+
+func (s *S3FileStorage) Put(folder string, fileName string, file io.Reader, size int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.getKey(folder, fileName), file, size, minio.PutObjectOptions{
+		ContentType: mime.TypeByExtension(path.Ext(fileName)),
+	})
+	if err != nil {
+		return fmt.Errorf("s3 file storage: put: could not put object: %v", err)
+	}
+	return nil
+}
+
+func (s *S3FileStorage) Get(folder string, fileName string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(context.Background(), s.bucket, s.getKey(folder, fileName), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("s3 file storage: get: could not get object: %w", s.wrapError(err))
+	}
+
+	// Object is loaded lazily, stat reports missing object before reading
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, fmt.Errorf("s3 file storage: get: could not get object: %w", s.wrapError(err))
+	}
+	return object, nil
+}
+
+func (s *S3FileStorage) Stat(folder string, fileName string) (*models.StoredFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	info, err := s.client.StatObject(ctx, s.bucket, s.getKey(folder, fileName), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("s3 file storage: stat: could not stat object: %w", s.wrapError(err))
+	}
+
+	return &models.StoredFile{
+		Name:      fileName,
+		Size:      info.Size,
+		MimeType:  info.ContentType,
+		UpdatedAt: info.LastModified,
+	}, nil
+}
+
+func (s *S3FileStorage) List(folder string) ([]models.StoredFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	prefix := folder + "/"
+	files := []models.StoredFile{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("s3 file storage: list: could not list objects: %v", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		name := strings.TrimPrefix(object.Key, prefix)
+		files = append(files, models.StoredFile{
+			Name:      name,
+			Size:      object.Size,
+			MimeType:  mime.TypeByExtension(path.Ext(name)),
+			UpdatedAt: object.LastModified,
+		})
+	}
+	return files, nil
+}
+
+func (s *S3FileStorage) ListFolders() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	folders := []string{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("s3 file storage: list folders: could not list objects: %v", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			folders = append(folders, strings.TrimSuffix(object.Key, "/"))
+		}
+	}
+	return folders, nil
+}
+
+func (s *S3FileStorage) Delete(folder string, fileName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	err := s.client.RemoveObject(ctx, s.bucket, s.getKey(folder, fileName), minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("s3 file storage: delete: could not remove object: %v", err)
+	}
+	return nil
+}
+
+func (s *S3FileStorage) Presign(folder string, fileName string, expires time.Duration, contentType string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+
+	params := url.Values{}
+	if contentType != "" {
+		params.Set("response-content-type", contentType)
+	}
+	presignedURL, err := s.client.PresignedGetObject(ctx, s.bucket, s.getKey(folder, fileName), expires, params)
+	if err != nil {
+		return "", fmt.Errorf("s3 file storage: presign: could not presign object: %v", err)
+	}
+	return presignedURL.String(), nil
+}
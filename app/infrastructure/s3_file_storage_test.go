@@ -0,0 +1,214 @@
+package infrastructure
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeS3Object struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+// Minimal in-memory S3 compatible server, supports single bucket object operations used by S3FileStorage
+type fakeS3Server struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeS3Object
+}
+
+type fakeS3ListContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type fakeS3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type fakeS3ListResult struct {
+	XMLName        xml.Name             `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string               `xml:"Name"`
+	Prefix         string               `xml:"Prefix"`
+	Delimiter      string               `xml:"Delimiter"`
+	KeyCount       int                  `xml:"KeyCount"`
+	MaxKeys        int                  `xml:"MaxKeys"`
+	IsTruncated    bool                 `xml:"IsTruncated"`
+	Contents       []fakeS3ListContent  `xml:"Contents"`
+	CommonPrefixes []fakeS3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+func (s *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	bucket, bucketExists := s.buckets[bucketName]
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead && bucketExists:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut:
+			s.buckets[bucketName] = map[string]fakeS3Object{}
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && bucketExists:
+			s.listObjects(w, bucketName, bucket, r.URL.Query())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	object, objectExists := bucket[key]
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data = decodeAWSChunked(data)
+		}
+		bucket[key] = fakeS3Object{data: data, contentType: r.Header.Get("Content-Type"), lastModified: time.Now().UTC()}
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodHead, http.MethodGet:
+		if !objectExists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Last-Modified", object.lastModified.Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case http.MethodDelete:
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Decode body with chunked signature, chunks look like "<hex size>;chunk-signature=<sig>\r\n<data>\r\n"
+func decodeAWSChunked(body []byte) []byte {
+	decoded := []byte{}
+	rest := string(body)
+	for {
+		header, tail, found := strings.Cut(rest, "\r\n")
+		if !found {
+			return decoded
+		}
+		sizeHex, _, _ := strings.Cut(header, ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 || int64(len(tail)) < size {
+			return decoded
+		}
+		decoded = append(decoded, tail[:size]...)
+		rest = strings.TrimPrefix(tail[size:], "\r\n")
+	}
+}
+
+func (s *fakeS3Server) listObjects(w http.ResponseWriter, bucketName string, bucket map[string]fakeS3Object, query url.Values) {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	result := fakeS3ListResult{Name: bucketName, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+
+	keys := []string{}
+	for key := range bucket {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	addedPrefixes := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := prefix + rest[:i+len(delimiter)]
+			if !addedPrefixes[commonPrefix] {
+				addedPrefixes[commonPrefix] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, fakeS3CommonPrefix{Prefix: commonPrefix})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, fakeS3ListContent{
+			Key:          key,
+			LastModified: bucket[key].lastModified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"etag"`,
+			Size:         int64(len(bucket[key].data)),
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3FileStorage(t *testing.T) *S3FileStorage {
+	server := httptest.NewServer(&fakeS3Server{buckets: map[string]map[string]fakeS3Object{}})
+	t.Cleanup(server.Close)
+
+	storage, err := NewS3FileStorage(S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "media",
+		AccessKey: "access-key",
+		SecretKey: "secret-key",
+	})
+	require.NoError(t, err)
+	return storage
+}
+
+func TestS3FileStorage(t *testing.T) {
+	storage := newTestS3FileStorage(t)
+
+	testFileStorageDriver(t, storage, func(folder, fileName string) (int64, string, error) {
+		file, err := storage.Stat(folder, fileName)
+		if err != nil {
+			return 0, "", err
+		}
+		return file.Size, file.MimeType, nil
+	})
+
+	presignedURL, err := storage.Presign("user", "image.png", time.Hour, "image/png")
+	assert.NoError(t, err)
+	assert.Contains(t, presignedURL, "/media/user/image.png?")
+	assert.Contains(t, presignedURL, "X-Amz-Signature=")
+	assert.Contains(t, presignedURL, "response-content-type=image%2Fpng")
+}
+
+func TestS3FileStorageList(t *testing.T) {
+	storage := newTestS3FileStorage(t)
+	require.NoError(t, storage.Put("user", "a.png", strings.NewReader("a"), 1))
+	require.NoError(t, storage.Put("user", "b.jpg", strings.NewReader("bb"), 2))
+	require.NoError(t, storage.Put("another-user", "c.png", strings.NewReader("c"), 1))
+
+	files, err := storage.List("user")
+
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "a.png", files[0].Name)
+	assert.Equal(t, int64(2), files[1].Size)
+	assert.Equal(t, "image/jpeg", files[1].MimeType)
+}
@@ -0,0 +1,69 @@
+package infrastructure
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send emails through SMTP server, STARTTLS is used when server supports it
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from mail.Address
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("smtp mailer: parse sender address: %v", err)
+	}
+	var auth smtp.Auth
+	if config.Username != "" {
+		auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return &SMTPMailer{
+		addr: net.JoinHostPort(config.Host, config.Port),
+		auth: auth,
+		from: *from,
+	}, nil
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("smtp mailer: send: parse recipient address: %v", err)
+	}
+	message := buildMailMessage(m.from, *recipient, subject, body, time.Now())
+	err = smtp.SendMail(m.addr, m.auth, m.from.Address, []string{recipient.Address}, message)
+	if err != nil {
+		return fmt.Errorf("smtp mailer: send: %v", err)
+	}
+	return nil
+}
+
+func buildMailMessage(from mail.Address, to mail.Address, subject string, body string, date time.Time) []byte {
+	var message strings.Builder
+	message.WriteString("From: " + from.String() + "\r\n")
+	message.WriteString("To: " + to.String() + "\r\n")
+	message.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	message.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(message.String())
+}
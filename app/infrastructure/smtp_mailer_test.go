@@ -0,0 +1,126 @@
+package infrastructure
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// Minimal SMTP server without extensions, it accepts every message
+func startFakeSMTPServer(t *testing.T) (string, <-chan receivedMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	mails := make(chan receivedMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		received := receivedMail{}
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL FROM:"):
+				received.from = strings.Trim(strings.TrimPrefix(command, "MAIL FROM:"), "<>")
+				reply("250 OK")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				received.to = append(received.to, strings.Trim(strings.TrimPrefix(command, "RCPT TO:"), "<>"))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				received.data = data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				mails <- received
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), mails
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, mails := startFakeSMTPServer(t)
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	mailer, err := NewSMTPMailer(SMTPConfig{
+		Host: host,
+		Port: port,
+		From: "Org Note <noreply@example.com>",
+	})
+	require.NoError(t, err)
+
+	err = mailer.Send("user@example.com", "Verify email", "Open link:\nhttps://example.com/verify")
+	require.NoError(t, err)
+
+	received := <-mails
+	assert.Equal(t, "noreply@example.com", received.from)
+	assert.Equal(t, []string{"user@example.com"}, received.to)
+	assert.Contains(t, received.data, "From: \"Org Note\" <noreply@example.com>\r\n")
+	assert.Contains(t, received.data, "To: <user@example.com>\r\n")
+	assert.Contains(t, received.data, "Subject: Verify email\r\n")
+	assert.Contains(t, received.data, "\r\n\r\nOpen link:\r\nhttps://example.com/verify")
+}
+
+func TestSMTPMailerInvalidAddress(t *testing.T) {
+	_, err := NewSMTPMailer(SMTPConfig{Host: "localhost", Port: "25", From: "invalid"})
+	assert.Error(t, err)
+
+	mailer, err := NewSMTPMailer(SMTPConfig{Host: "localhost", Port: "25", From: "noreply@example.com"})
+	require.NoError(t, err)
+	assert.Error(t, mailer.Send("invalid", "subject", "body"))
+}
+
+func TestBuildMailMessageEncodesSubject(t *testing.T) {
+	message := string(buildMailMessage(
+		mail.Address{Address: "noreply@example.com"},
+		mail.Address{Address: "user@example.com"},
+		"Reset\r\nBcc: attacker@example.com",
+		"body",
+		time.Now(),
+	))
+
+	assert.NotContains(t, message, "\r\nBcc:")
+	assert.Contains(t, message, "Subject: =?utf-8?q?")
+}
@@ -9,6 +9,7 @@ import (
 	"orgnote/app/repositories"
 	"orgnote/app/services"
 	"os"
+	"strings"
 	"time"
 
 	cache "github.com/Code-Hex/go-generics-cache"
@@ -80,7 +81,9 @@ func main() {
 	}
 
 	app := fiber.New(fiber.Config{
-		BodyLimit: config.MaximumFileSize,
+		BodyLimit:                    config.MaximumFileSize,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
 	api := app.Group("/v1")
 
@@ -88,23 +91,86 @@ func main() {
 	noteRepository := repositories.NewNoteRepository(database)
 	tagRepository := repositories.NewTagRepository(database)
 	userRepository := repositories.NewUserRepository(database)
-	fileStorage := infrastructure.NewFileStorage(config.MediaPath)
+	sessionExpiresAt := time.Now().Add(config.SessionLifetime)
+	migratedTokens, err := userRepository.MigratePlainTokens(sessionExpiresAt)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to migrate plain tokens")
+	} else if migratedTokens > 0 {
+		log.Info().Msgf("hashed tokens of %d users", migratedTokens)
+	}
+	migratedIdentities, err := userRepository.MigrateIdentities()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to migrate user identities")
+	} else if migratedIdentities > 0 {
+		log.Info().Msgf("created identities of %d users", migratedIdentities)
+	}
+	// Without SMTP local accounts are registered with verified emails, so the emails can't be trusted
+	adminEmails := config.AdminEmails
+	if config.SMTPHost == "" && len(adminEmails) > 0 {
+		log.Warn().Msg("SMTP is not configured, admin role is not granted by ADMIN_EMAILS, use ADMIN_USER_IDS instead")
+		adminEmails = nil
+	}
+	promotedAdmins, err := userRepository.PromoteAdmins(adminEmails, config.AdminUserIDs)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to grant admin role")
+	} else if promotedAdmins > 0 {
+		log.Info().Msgf("granted admin role to %d users", promotedAdmins)
+	}
+	fileRepository := repositories.NewFileRepository(database)
+	fileStorage, err := newFileStorage(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create file storage")
+		return
+	}
+	eventBroker := infrastructure.NewEventBroker()
+	userService := services.NewUserService(userRepository, noteRepository, subscriptionAPI, config)
 
 	app.Use(recover.New(recover.Config{
 		EnableStackTrace: true,
 	}))
-	app.Use(cors.New())
+	app.Use(handlers.NewBodyLimitMiddleware(config.MaximumFileSize, func(c *fiber.Ctx) bool {
+		return strings.HasPrefix(c.Path(), "/v1/files/tus")
+	}))
+	app.Use(cors.New(cors.Config{
+		ExposeHeaders: handlers.TusExposedHeaders,
+	}))
 	app.Use(handlers.NewUserInjectMiddleware(handlers.Config{
-		GetUser: userRepository.FindUserByToken,
+		GetUser:        userRepository.FindUserByToken,
+		OnAPITokenUsed: userService.TouchAPIToken,
+		OnSessionUsed:  userService.TouchSession,
+		AllowQueryToken: func(c *fiber.Ctx) bool {
+			return strings.HasPrefix(c.Path(), "/v1/events") ||
+				strings.HasPrefix(c.Path(), "/media") ||
+				strings.HasPrefix(c.Path(), "/v1/media")
+		},
 	}))
 
 	authMiddleware := handlers.NewAuthMiddleware()
 	accessMiddleware := handlers.NewAccessMiddleware(subscriptionAPI)
 
-	noteService := services.NewNoteService(noteRepository, userRepository, tagRepository, fileStorage)
+	noteService := services.NewNoteService(noteRepository, userRepository, tagRepository, fileRepository, config, eventBroker)
 	tagService := services.NewTagService(tagRepository)
-	userService := services.NewUserService(userRepository, noteRepository, subscriptionAPI)
-	fileService := services.NewFileService(fileStorage, userRepository)
+	migratedSequenceNotes, err := noteRepository.MigrateChangeSequences()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to migrate note change sequences")
+	} else if migratedSequenceNotes > 0 {
+		log.Info().Msgf("assigned change sequences to %d notes", migratedSequenceNotes)
+	}
+	migratedAgendaNotes, err := noteService.MigrateAgenda()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to migrate agenda items")
+	} else if migratedAgendaNotes > 0 {
+		log.Info().Msgf("projected agenda items of %d notes", migratedAgendaNotes)
+	}
+	fileService := services.NewFileService(fileStorage, fileRepository, userRepository, noteRepository, noteService, config, eventBroker)
+	fileService.RunScheduler()
+	importedFiles, err := fileService.ImportLegacyFiles()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to import legacy files")
+	} else if importedFiles > 0 {
+		log.Info().Msgf("moved %d legacy files into blob storage", importedFiles)
+	}
+	calendarService := services.NewCalendarService(noteRepository, userRepository, config)
 
 	orgNoteMetaService := services.NewOrgNoteMetaService(services.OrgNoteMetaConfig{
 		ClientRepoName:  config.GithubClientRepoName,
@@ -115,20 +181,64 @@ func main() {
 	// TODO: expose to external fn
 
 	handlers.RegisterSwagger(api, config)
-	handlers.RegisterNoteHandler(api, noteService, authMiddleware, accessMiddleware)
+	handlers.RegisterNoteHandler(api, noteService, fileService, authMiddleware, accessMiddleware)
 	handlers.RegisterTagHandler(api, tagService)
-	handlers.RegisterAuthHandler(api, userService, config, authMiddleware)
-	handlers.RegisterFileHandler(api, fileService, authMiddleware, accessMiddleware)
+	handlers.RegisterAuthHandler(api, userService, fileService, config, authMiddleware)
+	handlers.RegisterTwoFactorHandler(api, services.NewTwoFactorService(userRepository, config), authMiddleware)
+	if config.LocalAuthEnabled {
+		mailer, err := newMailer(config)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create mailer")
+			return
+		}
+		localAuthService := services.NewLocalAuthService(userRepository, mailer, config)
+		handlers.RegisterLocalAuthHandler(api, localAuthService, authMiddleware)
+	}
+	handlers.RegisterFileHandler(api, fileService, config, authMiddleware, accessMiddleware)
+	handlers.RegisterTusHandler(api, fileService, config, authMiddleware, accessMiddleware)
 	handlers.RegisterSystemInfoHandler(api, orgNoteMetaService)
+	handlers.RegisterEventsHandler(api, eventBroker, authMiddleware)
+	handlers.RegisterAgendaHandler(api, noteService, authMiddleware)
+	handlers.RegisterCalendarHandler(api, calendarService, authMiddleware)
+	handlers.RegisterAdminHandler(api, services.NewAdminService(userRepository, noteRepository, fileRepository), authMiddleware)
 	// handlers.RegisterUserHandlers(app)
 	// handlers.RegisterTagHandlers(app)
-	app.Static("media", config.MediaPath)
+	handlers.RegisterMediaHandler(app, fileService)
 
 	// NOTE: for local file uploading (tmp quick hack)
 	if config.Debug {
-		app.Static("v1/media", config.MediaPath)
+		handlers.RegisterMediaHandler(api, fileService)
 	}
 
 	log.Info().Msg("Application start debug mode: " + config.AppAddress)
 	app.Listen(config.AppAddress)
 }
+
+func newFileStorage(config configs.Config) (services.FileStorage, error) {
+	if config.StorageDriver == "s3" {
+		return infrastructure.NewS3FileStorage(infrastructure.S3Config{
+			Endpoint:  config.S3Endpoint,
+			Region:    config.S3Region,
+			Bucket:    config.S3Bucket,
+			AccessKey: config.S3AccessKey,
+			SecretKey: config.S3SecretKey,
+			UseSSL:    config.S3UseSSL,
+		})
+	}
+	return infrastructure.NewLocalFileStorage(config.MediaPath), nil
+}
+
+// Emails are not sent when SMTP is not configured
+func newMailer(config configs.Config) (services.Mailer, error) {
+	if config.SMTPHost == "" {
+		log.Warn().Msg("SMTP is not configured, emails of local accounts will not be verified")
+		return nil, nil
+	}
+	return infrastructure.NewSMTPMailer(infrastructure.SMTPConfig{
+		Host:     config.SMTPHost,
+		Port:     config.SMTPPort,
+		Username: config.SMTPUsername,
+		Password: config.SMTPPassword,
+		From:     config.SMTPFrom,
+	})
+}
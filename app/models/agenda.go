@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AgendaRepeater struct {
+	Type  string `json:"type" bson:"type" enums:"+,++,.+"`
+	Value int    `json:"value" bson:"value"`
+	Unit  string `json:"unit" bson:"unit" enums:"h,d,w,m,y"`
+}
+
+type AgendaTimestamp struct {
+	Date     time.Time       `json:"date" bson:"date"` // Org timestamps have no time zone and are stored as UTC
+	HasTime  bool            `json:"hasTime" bson:"hasTime"`
+	Repeater *AgendaRepeater `json:"repeater" bson:"repeater"`
+}
+
+// Headline with TODO keyword or planning info, projected from note content
+type AgendaItem struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id"`
+	AuthorID  string             `json:"-" bson:"authorId"`
+	NoteID    string             `json:"noteId" bson:"noteId"` // External id of the owning note
+	Title     string             `json:"title" bson:"title"`
+	Keyword   string             `json:"todoKeyword" bson:"keyword"`
+	Done      bool               `json:"done" bson:"done"`
+	Priority  string             `json:"priority" bson:"priority"`
+	Tags      []string           `json:"tags" bson:"tags"`
+	Level     int                `json:"level" bson:"level"`
+	Scheduled *AgendaTimestamp   `json:"scheduled" bson:"scheduled"`
+	Deadline  *AgendaTimestamp   `json:"deadline" bson:"deadline"`
+}
+
+type AgendaFilter struct {
+	From   *time.Time
+	To     *time.Time
+	States []string
+}
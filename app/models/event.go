@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type EventType string
+
+const (
+	EventNoteUpdated  EventType = "note.updated"
+	EventNoteDeleted  EventType = "note.deleted"
+	EventFileUploaded EventType = "file.uploaded"
+	EventFileDeleted  EventType = "file.deleted"
+)
+
+// Notification about changes of user data for connected devices
+type Event struct {
+	Type      EventType `json:"type" enums:"note.updated,note.deleted,file.uploaded,file.deleted"`
+	UserID    string    `json:"-"`
+	IDs       []string  `json:"ids"` // Note external ids or file names
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// User file name mapped to content addressed blob
+type UserFile struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID    string             `json:"-" bson:"userId"`
+	Name      string             `json:"name" bson:"name"`
+	Hash      string             `json:"hash" bson:"hash"` // SHA-256 of the content, hex encoded
+	Size      int64              `json:"size" bson:"size"`
+	MimeType  string             `json:"mimeType" bson:"mimeType"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+type StoredFile struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash,omitempty"` // SHA-256 of the content
+	Size      int64     `json:"size"`
+	MimeType  string    `json:"mimeType"`
+	Notes     []string  `json:"notes"` // Ids of notes which use this file
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Unfinished resumable upload, received content is stored as separate chunks
+type FileUpload struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    string             `json:"-" bson:"userId"`
+	FileName  string             `json:"fileName" bson:"fileName"`
+	MimeType  string             `json:"mimeType" bson:"mimeType"` // Detected by content of the first chunk
+	Length    int64              `json:"length" bson:"length"`
+	Offset    int64              `json:"offset" bson:"offset"`
+	Chunks    []string           `json:"-" bson:"chunks"`    // Names of stored chunks in order of offset
+	HashState []byte             `json:"-" bson:"hashState"` // Serialized SHA-256 state of received content
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+}
+
+type UploadResult struct {
+	OriginalName string `json:"originalName"` // File name provided by client
+	Name         string `json:"name"`         // Sanitized name of stored file
+	Size         int64  `json:"size"`
+	Uploaded     bool   `json:"uploaded"`
+	Error        string `json:"error,omitempty" enums:"invalid_name,type_not_allowed,quota_exceeded,internal_error"`
+}
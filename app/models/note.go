@@ -56,7 +56,11 @@ type Note struct {
 	Views          int                `json:"views" bson:"views"`
 	Likes          int                `json:"likes" bson:"likes"`
 	DeletedAt      *time.Time         `json:"deletedAt" bson:"deletedAt"`
-	Size           int64              `json:"size" bson"size"`
+	Size           int64              `json:"size" bson:"size"`
+	Revision       int64              `json:"revision" bson:"revision"`   // Incremented on each note change
+	BaseRevision   *int64             `json:"-" bson:"-"`                 // Revision of the note which was edited by the client
+	ChangeSeq      int64              `json:"changeSeq" bson:"changeSeq"` // Per user monotonically increasing number of the last change
+	Agenda         []AgendaItem       `json:"-" bson:"agenda"`            // Agenda items projected from content, written together with the note
 }
 
 type PublicNote struct {
@@ -71,7 +75,16 @@ type PublicNote struct {
 	CreatedAt      time.Time  `json:"createdAt"`
 	TouchedAt      time.Time  `json:"touchedAt"`
 	IsMy           bool       `json:"isMy"`
-	Size           int64      `json:"size" bson"size"`
+	Size           int64      `json:"size" bson:"size"`
+	Revision       int64      `json:"revision"`
+}
+
+// Note which was changed on the server and the client simultaneously and couldn't be merged automatically
+type NoteConflict struct {
+	ID           string     `json:"id"`
+	BaseRevision int64      `json:"baseRevision"`
+	ServerNote   PublicNote `json:"serverNote"`
+	ClientNote   PublicNote `json:"clientNote"`
 }
 
 type NoteFilter struct {
@@ -84,3 +97,21 @@ type NoteFilter struct {
 	IncludeDeleted *bool      `json:"includeDeleted"`
 	DeletedAt      *time.Time `json:"deletedAt"`
 }
+
+// Range of matched text inside snippet, offsets are in unicode characters
+type TextRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type SearchHighlight struct {
+	Field   string      `json:"field" enums:"title,description,fileTags,content"`
+	Snippet string      `json:"snippet"`
+	Matches []TextRange `json:"matches"`
+}
+
+type NoteSearchResult struct {
+	PublicNote
+	Score      float64           `json:"score"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
@@ -1,9 +1,10 @@
 package models
 
 type GraphNoteNode struct {
-	ExternalID string `json:"externalId" bson:"externalId"`
-	Title      string `json:"title" bson:"title"`
-	Weight     int    `json:"weight" bson:"weight"`
+	ExternalID string   `json:"externalId" bson:"externalId"`
+	Title      string   `json:"title" bson:"title"`
+	Tags       []string `json:"tags" bson:"tags"` // File tags of the note
+	Weight     int      `json:"weight" bson:"weight"`
 }
 
 type GraphNoteLink struct {
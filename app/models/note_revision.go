@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Snapshot of the note state before it was overwritten
+type NoteRevision struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	NoteID         string             `json:"noteId" bson:"noteId"` // External id of the original note
+	AuthorID       string             `json:"-" bson:"authorId"`
+	Revision       int64              `json:"revision" bson:"revision"` // Revision number of the snapshotted note
+	EncryptionType *string            `json:"encryptionType" bson:"encryptionType" enums:"gpgKeys,gpgPassword,disabled"`
+	Encrypted      bool               `json:"encrypted" bson:"encrypted"`
+	Content        string             `json:"content" bson:"content"`
+	Meta           NoteMeta           `json:"meta" bson:"meta"`
+	FilePath       []string           `json:"filePath" bson:"filePath"`
+	UpdatedAt      time.Time          `json:"updatedAt" bson:"updatedAt"` // Last update time of the snapshotted note
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"` // Time when the revision was taken
+}
@@ -6,12 +6,131 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+	ScopeFilesWrite = "files:write"
+	ScopePublish    = "publish"
+	ScopeAccount    = "account"
+)
+
+var APITokenScopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeFilesWrite, ScopePublish, ScopeAccount}
+
+// Scopes of tokens created before scopes were introduced, they were used for notes sync
+var DefaultAPITokenScopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeFilesWrite, ScopePublish}
+
+// Only salted hash of the token is stored, plain token is returned once after creation
 type APIToken struct {
-	ID          primitive.ObjectID `bson:"_id" json:"id"`
-	Permissions string             `json:"permission" bson:"permission"`
-	Token       string             `json:"token" bson:"token"`
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Name       string             `json:"name" bson:"name"`
+	Scopes     []string           `json:"scopes" bson:"scopes" enums:"notes:read,notes:write,files:write,publish,account"`
+	Token      string             `json:"token,omitempty" bson:"token,omitempty"` // Plain token, it's stored only by tokens created before hashing
+	Prefix     string             `json:"prefix" bson:"prefix"`                   // Beginning of the token for lookup
+	Hash       string             `json:"-" bson:"hash"`
+	Salt       string             `json:"-" bson:"salt"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  *time.Time         `json:"expiresAt" bson:"expiresAt"` // Token without expiration time never expires
+	LastUsedAt *time.Time         `json:"lastUsedAt" bson:"lastUsedAt"`
+	LastUsedIP string             `json:"lastUsedIp" bson:"lastUsedIp"`
+}
+
+func (t *APIToken) IsExpired(now time.Time) bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(now)
+}
+
+func (t *APIToken) GetScopes() []string {
+	if len(t.Scopes) == 0 {
+		return DefaultAPITokenScopes
+	}
+	return t.Scopes
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.GetScopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Read-only token, gives access to the calendar feed only and can't be used for API authorization.
+// Only salted hash of the token is stored, plain token and feed url are returned once after creation
+type CalendarToken struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Token     string             `json:"token,omitempty" bson:"token,omitempty"` // Plain token, it's stored only by tokens created before hashing
+	Prefix    string             `json:"prefix" bson:"prefix"`                   // Beginning of the token for lookup
+	Hash      string             `json:"-" bson:"hash"`
+	Salt      string             `json:"-" bson:"salt"`
+	URL       string             `json:"url,omitempty" bson:"-"` // Secret url of the calendar feed
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// Login session of the device, only salted hash of the token is stored
+type Session struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Name       string             `json:"name" bson:"name"` // Device name provided by client
+	UserAgent  string             `json:"userAgent" bson:"userAgent"`
+	Prefix     string             `json:"-" bson:"prefix"` // Beginning of the token for lookup
+	Hash       string             `json:"-" bson:"hash"`
+	Salt       string             `json:"-" bson:"salt"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	LastSeenAt time.Time          `json:"lastSeenAt" bson:"lastSeenAt"`
+	LastSeenIP string             `json:"lastSeenIp" bson:"lastSeenIp"`
+	ExpiresAt  time.Time          `json:"expiresAt" bson:"expiresAt"`
+	Current    bool               `json:"current" bson:"-"` // Request is authorized by this session
+}
+
+func (s *Session) IsExpired(now time.Time) bool {
+	return !s.ExpiresAt.After(now)
 }
 
+// Provider of accounts registered by email and password
+const LocalProvider = "local"
+
+// Login method attached to the account, one account could be used with several providers
+type Identity struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Provider   string             `json:"provider" bson:"provider"`
+	ExternalID string             `json:"externalId" bson:"externalId"`
+	Email      string             `json:"email" bson:"email"`
+	NickName   string             `json:"nickName" bson:"nickName"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// TOTP second factor. Secret is stored in plain text because codes are computed from it
+type TwoFactor struct {
+	Enabled       bool           `bson:"enabled"`      // Secret is not confirmed by code until it's enabled
+	Secret        string         `bson:"secret"`       // Base32 encoded
+	LastUsedStep  int64          `bson:"lastUsedStep"` // Code of the time step is accepted once
+	RecoveryCodes []RecoveryCode `bson:"recoveryCodes"`
+	EnabledAt     *time.Time     `bson:"enabledAt"`
+	// Failed codes since the last successful one, they are counted for the user, not for the login challenge
+	FailedAttempts int        `bson:"failedAttempts"`
+	LockedUntil    *time.Time `bson:"lockedUntil"` // Codes are not checked until this time after too many failed attempts
+}
+
+// One time code for login without authenticator app, only salted hash is stored
+type RecoveryCode struct {
+	Hash string `bson:"hash"`
+	Salt string `bson:"salt"`
+}
+
+func (t *TwoFactor) IsEnabled() bool {
+	return t != nil && t.Enabled
+}
+
+func (t *TwoFactor) IsLocked(now time.Time) bool {
+	return t != nil && t.LockedUntil != nil && t.LockedUntil.After(now)
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin" // Access to administration API
+)
+
+var UserRoles = []string{RoleUser, RoleAdmin}
+
 // TODO: master add migrations
 type User struct {
 	ID                  primitive.ObjectID `json:"id" bson:"_id,omitempty"`
@@ -23,16 +142,46 @@ type User struct {
 	NickName            string             `json:"nickName" bson:"nickName"`
 	ExternalID          string             `json:"externalId" bson:"externalId"`
 	AvatarURL           string             `json:"avatarUrl" bson:"avatarUrl"`
-	Token               string             `json:"token" bson:"token"`
+	Token               string             `json:"token" bson:"token,omitempty"` // Plain session token, it's available only after login
 	RefreshToken        *string            `json:"refreshToken" bson:"refreshToken"`
 	TokenExpirationDate time.Time          `json:"tokenExpiration" bson:"tokenExpiration"`
 	ProfileURL          string             `json:"profileUrl" bson:"profileUrl"`
 	APITokens           []APIToken         `json:"apiTokens" bson:"apiTokens"`
+	CalendarTokens      []CalendarToken    `json:"-" bson:"calendarTokens"`
 	Notes               []Note             `json:"notes" bson:"notes"`
 	NoteGraph           NoteGraph          `json:"noteGraph" bson:"noteGraph"`
 	SpaceLimit          int64              `json:"spaceLimit" bson:"spaceLimit"`
 	UsedSpace           int64              `json:"usedSpace" bson:"usedSpace"`
 	Active              *string            `json:"active" bson:"active"`
+	PasswordHash        string             `json:"-" bson:"passwordHash,omitempty"` // Argon2id hash, only local accounts have password
+	EmailVerified       bool               `json:"emailVerified" bson:"emailVerified"`
+	Identities          []Identity         `json:"identities" bson:"identities"` // Provider and ExternalID are kept for the identity used for registration
+	Sessions            []Session          `json:"-" bson:"sessions,omitempty"`
+	TwoFactor           *TwoFactor         `json:"-" bson:"twoFactor,omitempty"`
+	Role                string             `json:"role" bson:"role,omitempty"`            // Empty role means user
+	Disabled            bool               `json:"disabled" bson:"disabled,omitempty"`    // Disabled user can't login and tokens are not accepted
+	SpaceLimitOverride  bool               `json:"-" bson:"spaceLimitOverride,omitempty"` // Space limit is set by admin and isn't changed by subscription
+}
+
+func (u *User) GetRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Return attached identity of the provider user, nil when it isn't attached
+func (u *User) FindIdentity(provider string, externalID string) *Identity {
+	for i := range u.Identities {
+		if u.Identities[i].Provider == provider && u.Identities[i].ExternalID == externalID {
+			return &u.Identities[i]
+		}
+	}
+	return nil
 }
 
 type PublicUser struct {
@@ -54,4 +203,115 @@ type UserPersonalInfo struct {
 	SpaceLimit int64   `json:"spaceLimit"`
 	UsedSpace  int64   `json:"usedSpace"`
 	Active     *string `json:"active"`
+	// Login requires code from authenticator app
+	TwoFactorEnabled bool   `json:"twoFactorEnabled"`
+	Role             string `json:"role" enums:"user,admin"`
+}
+
+// User info for administration
+type AdminUser struct {
+	ID                 string     `json:"id"`
+	Provider           string     `json:"provider"`
+	Name               string     `json:"name"`
+	NickName           string     `json:"nickName"`
+	Email              string     `json:"email"`
+	AvatarURL          string     `json:"avatarUrl"`
+	Role               string     `json:"role" enums:"user,admin"`
+	Disabled           bool       `json:"disabled"`
+	SpaceLimit         int64      `json:"spaceLimit"`
+	SpaceLimitOverride bool       `json:"spaceLimitOverride"`
+	UsedSpace          int64      `json:"usedSpace"`
+	Active             *string    `json:"active"`
+	TwoFactorEnabled   bool       `json:"twoFactorEnabled"`
+	Identities         []Identity `json:"identities"`
+	SessionsCount      int        `json:"sessionsCount"`
+	APITokensCount     int        `json:"apiTokensCount"`
+	CreatedAt          time.Time  `json:"createdAt"`
+}
+
+type UserFilter struct {
+	Limit      *int64
+	Offset     *int64
+	SearchText *string // Part of email, name or nickname
+	Role       *string
+	Disabled   *bool
+}
+
+type InstanceStats struct {
+	Users         int64 `json:"users"`
+	AdminUsers    int64 `json:"adminUsers"`
+	DisabledUsers int64 `json:"disabledUsers"`
+	Notes         int64 `json:"notes"`       // Not deleted notes of all users
+	UsedSpace     int64 `json:"usedSpace"`   // Sum of space used by users in bytes
+	StoredSpace   int64 `json:"storedSpace"` // Size of stored files in bytes, content shared by several users is counted once
+}
+
+type LocalRegistration struct {
+	User                      *UserPersonalInfo `json:"user"`
+	EmailVerificationRequired bool              `json:"emailVerificationRequired"`
+}
+
+// Result of login, challenge is returned instead of the session when second factor is required
+type AuthSession struct {
+	Token              string              `json:"token,omitempty"`
+	ExpiresAt          *time.Time          `json:"expiresAt,omitempty"`
+	User               *UserPersonalInfo   `json:"user,omitempty"`
+	TwoFactorChallenge *TwoFactorChallenge `json:"twoFactorChallenge,omitempty"`
+	RecoveryCodes      []string            `json:"recoveryCodes,omitempty"` // Returned once when two factor is enabled during login
+}
+
+// Second step of login, session is created after code from authenticator app is verified
+type TwoFactorChallenge struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Two factor authentication is required by the instance but it's not enabled by the user yet
+	SetupRequired bool `json:"setupRequired"`
+}
+
+// Secret for authenticator app, provisioning uri is usually shown as QR code
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"` // Required by the instance, so it can't be disabled
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// Plain recovery codes, they are returned only once
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+type SessionToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type Invite struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const (
+	UserActionVerifyEmail   = "verify-email"
+	UserActionResetPassword = "reset-password"
+	UserActionInvite        = "invite"
+	UserActionLinkIdentity  = "link-identity"
+	UserActionTwoFactor     = "two-factor"
+)
+
+// One time token sent by email or shared with invited user, only its salted hash is stored
+type UserActionToken struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"userId"` // Owner of the token, creator for invites
+	Action    string             `bson:"action"`
+	Prefix    string             `bson:"prefix"`
+	Hash      string             `bson:"hash"`
+	Salt      string             `bson:"salt"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	Attempts  int                `bson:"attempts"` // Failed attempts of the token usage with wrong code
 }
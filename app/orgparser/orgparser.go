@@ -0,0 +1,340 @@
+// Package orgparser extracts document structure and metadata from org-mode content
+package orgparser
+
+import (
+	"orgnote/app/tools"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Link struct {
+	URL  string
+	Name string
+}
+
+type Repeater struct {
+	Type  string // +, ++ or .+
+	Value int
+	Unit  string // h, d, w, m or y
+}
+
+func (r Repeater) String() string {
+	return r.Type + strconv.Itoa(r.Value) + r.Unit
+}
+
+type Timestamp struct {
+	Time     time.Time // Org timestamps have no time zone, they are parsed as UTC
+	HasTime  bool
+	Active   bool
+	Repeater *Repeater
+}
+
+type Heading struct {
+	Level      int
+	Keyword    string // TODO keyword, empty when heading is not a task
+	Done       bool   // Keyword belongs to done states
+	Priority   string
+	Title      string
+	Tags       []string
+	Properties map[string]string
+	Scheduled  *Timestamp
+	Deadline   *Timestamp
+	Closed     *Timestamp
+	Line       int // Zero based line number of the heading
+}
+
+type Document struct {
+	Title       string
+	Description string
+	FileTags    []string
+	Startup     string
+	Keywords    map[string]string // All file keywords (#+KEY: value) with upper cased keys, first value wins
+	Properties  map[string]string // Properties from the drawer placed before the first heading
+	Headings    []Heading
+	IDLinks     []Link // Links to other notes, URL contains note id
+	Images      []Link
+	Links       []Link // External links which are not images or note links
+}
+
+var (
+	keywordRe     = regexp.MustCompile(`^\s*#\+([A-Za-z_]+):\s*(.*?)\s*$`)
+	headingRe     = regexp.MustCompile(`^(\*+)\s+(.*?)\s*$`)
+	priorityRe    = regexp.MustCompile(`^\[#([A-Za-z0-9])\]\s*`)
+	tagsRe        = regexp.MustCompile(`\s+(:[^\s]+:)$`)
+	propertyRe    = regexp.MustCompile(`^\s*:([^:\s]+):\s*(.*?)\s*$`)
+	linkRe        = regexp.MustCompile(`\[\[([^\]]+)\](?:\[([^\]]*)\])?\]`)
+	blockBeginRe  = regexp.MustCompile(`(?i)^\s*#\+begin_(src|example)\b`)
+	blockEndRe    = regexp.MustCompile(`(?i)^\s*#\+end_(src|example)\b`)
+	drawerBeginRe = regexp.MustCompile(`(?i)^\s*:properties:\s*$`)
+	drawerEndRe   = regexp.MustCompile(`(?i)^\s*:end:\s*$`)
+	planningRe    = regexp.MustCompile(`(SCHEDULED|DEADLINE|CLOSED):\s*([<\[][^>\]]+[>\]])`)
+	timestampRe   = regexp.MustCompile(`^([<\[])(\d{4}-\d{2}-\d{2})(?:\s+[^\s\d>\]+.]+)?(?:\s+(\d{1,2}:\d{2})(?:-\d{1,2}:\d{2})?)?(?:\s+(\.\+|\+\+|\+)(\d+)([hdwmy]))?[^>\]]*[>\]]$`)
+)
+
+var defaultTodoKeywords = []string{"TODO", "DONE"}
+
+var imageExtensions = map[string]struct{}{
+	".png": {}, ".jpg": {}, ".jpeg": {}, ".gif": {}, ".svg": {}, ".webp": {}, ".bmp": {}, ".tiff": {},
+}
+
+type parser struct {
+	doc          *Document
+	todoKeywords map[string]bool // Keyword to done state
+	inBlock      bool
+	inDrawer     bool
+	drawer       map[string]string
+}
+
+func Parse(content string) *Document {
+	lines := strings.Split(content, "\n")
+	p := &parser{
+		doc: &Document{
+			Keywords:   map[string]string{},
+			Properties: map[string]string{},
+			Headings:   []Heading{},
+			FileTags:   []string{},
+			IDLinks:    []Link{},
+			Images:     []Link{},
+			Links:      []Link{},
+		},
+		todoKeywords: collectTodoKeywords(lines),
+	}
+
+	for i, line := range lines {
+		p.parseLine(i, line)
+	}
+
+	p.doc.Title = p.doc.Keywords["TITLE"]
+	p.doc.Description = p.doc.Keywords["DESCRIPTION"]
+	p.doc.Startup = p.doc.Keywords["STARTUP"]
+	p.doc.FileTags = splitTags(p.doc.Keywords["FILETAGS"])
+
+	return p.doc
+}
+
+func (p *parser) parseLine(lineNumber int, line string) {
+	if p.inBlock {
+		if blockEndRe.MatchString(line) {
+			p.inBlock = false
+		}
+		return
+	}
+
+	if blockBeginRe.MatchString(line) {
+		p.inBlock = true
+		return
+	}
+
+	if p.isPlanningLine(lineNumber, line) {
+		p.parsePlanning(line)
+		return
+	}
+
+	if p.inDrawer {
+		if drawerEndRe.MatchString(line) {
+			p.inDrawer = false
+			return
+		}
+		if m := propertyRe.FindStringSubmatch(line); m != nil {
+			p.drawer[strings.ToUpper(m[1])] = m[2]
+		}
+		return
+	}
+
+	if drawerBeginRe.MatchString(line) {
+		p.inDrawer = true
+		p.drawer = p.doc.Properties
+		if len(p.doc.Headings) > 0 {
+			p.drawer = p.doc.Headings[len(p.doc.Headings)-1].Properties
+		}
+		return
+	}
+
+	if m := keywordRe.FindStringSubmatch(line); m != nil {
+		key := strings.ToUpper(m[1])
+		if _, exists := p.doc.Keywords[key]; !exists {
+			p.doc.Keywords[key] = m[2]
+		}
+		return
+	}
+
+	if m := headingRe.FindStringSubmatch(line); m != nil {
+		heading := p.parseHeading(len(m[1]), m[2])
+		heading.Line = lineNumber
+		p.doc.Headings = append(p.doc.Headings, heading)
+	}
+
+	p.parseLinks(line)
+}
+
+func (p *parser) parseHeading(level int, text string) Heading {
+	heading := Heading{
+		Level:      level,
+		Tags:       []string{},
+		Properties: map[string]string{},
+	}
+
+	keyword, rest, _ := strings.Cut(text, " ")
+	if done, isTodo := p.todoKeywords[keyword]; isTodo {
+		heading.Keyword = keyword
+		heading.Done = done
+		text = strings.TrimSpace(rest)
+	}
+
+	if m := priorityRe.FindStringSubmatch(text); m != nil {
+		heading.Priority = m[1]
+		text = text[len(m[0]):]
+	}
+
+	if m := tagsRe.FindStringSubmatch(text); m != nil {
+		heading.Tags = splitTags(m[1])
+		text = text[:len(text)-len(m[0])]
+	}
+
+	heading.Title = strings.TrimSpace(text)
+	return heading
+}
+
+// Planning line should follow the heading line
+func (p *parser) isPlanningLine(lineNumber int, line string) bool {
+	if len(p.doc.Headings) == 0 {
+		return false
+	}
+	lastHeading := p.doc.Headings[len(p.doc.Headings)-1]
+	return lastHeading.Line == lineNumber-1 && planningRe.MatchString(line)
+}
+
+func (p *parser) parsePlanning(line string) {
+	heading := &p.doc.Headings[len(p.doc.Headings)-1]
+
+	for _, m := range planningRe.FindAllStringSubmatch(line, -1) {
+		timestamp := ParseTimestamp(m[2])
+		if timestamp == nil {
+			continue
+		}
+		switch m[1] {
+		case "SCHEDULED":
+			heading.Scheduled = timestamp
+		case "DEADLINE":
+			heading.Deadline = timestamp
+		case "CLOSED":
+			heading.Closed = timestamp
+		}
+	}
+}
+
+// Parse org timestamp like <2023-10-05 Thu 10:00 +1w>. Return nil for malformed timestamps
+func ParseTimestamp(raw string) *Timestamp {
+	m := timestampRe.FindStringSubmatch(strings.TrimSpace(raw))
+	if m == nil {
+		return nil
+	}
+
+	layout, value := "2006-01-02", m[2]
+	if m[3] != "" {
+		layout, value = "2006-01-02 15:04", m[2]+" "+m[3]
+	}
+
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return nil
+	}
+
+	timestamp := &Timestamp{
+		Time:    t,
+		HasTime: m[3] != "",
+		Active:  m[1] == "<",
+	}
+
+	if m[4] != "" {
+		repeatValue, err := strconv.Atoi(m[5])
+		if err == nil && repeatValue > 0 {
+			timestamp.Repeater = &Repeater{Type: m[4], Value: repeatValue, Unit: m[6]}
+		}
+	}
+
+	return timestamp
+}
+
+func (p *parser) parseLinks(line string) {
+	for _, m := range linkRe.FindAllStringSubmatch(line, -1) {
+		link := Link{URL: m[1], Name: m[2]}
+
+		if id, ok := tools.ExportLinkID(link.URL); ok {
+			link.URL = id
+			p.doc.IDLinks = append(p.doc.IDLinks, link)
+			continue
+		}
+
+		if isImageLink(link.URL) {
+			p.doc.Images = append(p.doc.Images, link)
+			continue
+		}
+
+		p.doc.Links = append(p.doc.Links, link)
+	}
+}
+
+func isImageLink(url string) bool {
+	if strings.Contains(url, "://") {
+		return false
+	}
+	url = strings.TrimPrefix(url, "file:")
+	_, isImage := imageExtensions[strings.ToLower(path.Ext(url))]
+	return isImage
+}
+
+// Collect TODO keywords from #+TODO and #+SEQ_TODO lines, default keywords are used when none are defined.
+// Keywords after | are done states, without | only the last keyword is done
+func collectTodoKeywords(lines []string) map[string]bool {
+	keywords := map[string]bool{}
+
+	for _, line := range lines {
+		m := keywordRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key := strings.ToUpper(m[1])
+		if key != "TODO" && key != "SEQ_TODO" && key != "TYP_TODO" {
+			continue
+		}
+		sequence := strings.Fields(m[2])
+		hasDoneSeparator := false
+		for _, keyword := range sequence {
+			hasDoneSeparator = hasDoneSeparator || keyword == "|"
+		}
+
+		done := false
+		for i, keyword := range sequence {
+			if keyword == "|" {
+				done = true
+				continue
+			}
+			// NOTE: fast access keys, e.g. TODO(t)
+			keyword, _, _ = strings.Cut(keyword, "(")
+			keywords[keyword] = done || (!hasDoneSeparator && i == len(sequence)-1)
+		}
+	}
+
+	if len(keywords) == 0 {
+		for i, keyword := range defaultTodoKeywords {
+			keywords[keyword] = i == len(defaultTodoKeywords)-1
+		}
+	}
+
+	return keywords
+}
+
+func splitTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ':' || r == ' ' || r == '\t'
+	})
+}
+
+// Return file name of the image link
+func ImageFileName(link Link) string {
+	return path.Base(strings.TrimPrefix(link.URL, "file:"))
+}
@@ -0,0 +1,103 @@
+package orgparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const note = `:PROPERTIES:
+:ID: my-note
+:END:
+#+TITLE: My note
+#+DESCRIPTION: Note description
+#+FILETAGS: :emacs:org:
+#+STARTUP: overview
+
+* Introduction :intro:
+Link to [[id:another-note][Another note]] and [[https://orgmode.org][Org mode]].
+** TODO [#A] Write parser
+:PROPERTIES:
+:EFFORT: 1h
+:END:
+[[./images/screenshot.png]]
+#+BEGIN_SRC org
+* Not a heading [[id:ignored]]
+#+END_SRC
+* DONE Finished`
+
+func TestParseKeywords(t *testing.T) {
+	doc := Parse(note)
+
+	assert.Equal(t, "My note", doc.Title)
+	assert.Equal(t, "Note description", doc.Description)
+	assert.Equal(t, []string{"emacs", "org"}, doc.FileTags)
+	assert.Equal(t, "overview", doc.Startup)
+	assert.Equal(t, map[string]string{"ID": "my-note"}, doc.Properties)
+}
+
+func TestParseHeadings(t *testing.T) {
+	doc := Parse(note)
+
+	assert.Equal(t, []Heading{
+		{Level: 1, Title: "Introduction", Tags: []string{"intro"}, Properties: map[string]string{}, Line: 8},
+		{
+			Level:      2,
+			Keyword:    "TODO",
+			Priority:   "A",
+			Title:      "Write parser",
+			Tags:       []string{},
+			Properties: map[string]string{"EFFORT": "1h"},
+			Line:       10,
+		},
+		{Level: 1, Keyword: "DONE", Done: true, Title: "Finished", Tags: []string{}, Properties: map[string]string{}, Line: 18},
+	}, doc.Headings)
+}
+
+func TestParseLinks(t *testing.T) {
+	doc := Parse(note)
+
+	assert.Equal(t, []Link{{URL: "another-note", Name: "Another note"}}, doc.IDLinks)
+	assert.Equal(t, []Link{{URL: "https://orgmode.org", Name: "Org mode"}}, doc.Links)
+	assert.Equal(t, []Link{{URL: "./images/screenshot.png"}}, doc.Images)
+	assert.Equal(t, "screenshot.png", ImageFileName(doc.Images[0]))
+}
+
+func TestParseCustomTodoKeywords(t *testing.T) {
+	doc := Parse("#+TODO: NEXT(n) | CANCELLED(c)\n* NEXT Task\n* TODO Not a keyword")
+
+	assert.Equal(t, "NEXT", doc.Headings[0].Keyword)
+	assert.Equal(t, "", doc.Headings[1].Keyword)
+	assert.Equal(t, "TODO Not a keyword", doc.Headings[1].Title)
+}
+
+func TestParsePlanning(t *testing.T) {
+	doc := Parse("* TODO Weekly review\nSCHEDULED: <2023-10-05 Thu 10:00 +1w> DEADLINE: <2023-10-06 Fri>\n* Not planned\ntext\nSCHEDULED: <2023-10-05 Thu>")
+
+	assert.Equal(t, &Timestamp{
+		Time:     time.Date(2023, 10, 5, 10, 0, 0, 0, time.UTC),
+		HasTime:  true,
+		Active:   true,
+		Repeater: &Repeater{Type: "+", Value: 1, Unit: "w"},
+	}, doc.Headings[0].Scheduled)
+	assert.Equal(t, &Timestamp{
+		Time:   time.Date(2023, 10, 6, 0, 0, 0, 0, time.UTC),
+		Active: true,
+	}, doc.Headings[0].Deadline)
+	assert.Nil(t, doc.Headings[1].Scheduled)
+}
+
+func TestParseTimestampWithCatchUpRepeater(t *testing.T) {
+	timestamp := ParseTimestamp("<2023-01-31 Tue .+1m>")
+
+	assert.Equal(t, ".+1m", timestamp.Repeater.String())
+	assert.False(t, timestamp.HasTime)
+}
+
+func TestParseDoneKeywordsWithoutSeparator(t *testing.T) {
+	doc := Parse("#+TODO: TODO WAIT FINISHED\n* WAIT Task\n* FINISHED Task")
+
+	assert.False(t, doc.Headings[0].Done)
+	assert.True(t, doc.Headings[1].Done)
+}
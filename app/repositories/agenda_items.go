@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Return notes whose agenda was never projected, oldest first
+func (n *NoteRepository) GetNotesWithoutAgenda(limit int64) ([]models.Note, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().
+		SetSort(bson.D{bson.E{Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	cur, err := n.collection.Find(ctx, bson.M{"agenda": bson.M{"$exists": false}}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get notes without agenda: failed to find notes: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	notes := []models.Note{}
+	if err := cur.All(ctx, &notes); err != nil {
+		return nil, fmt.Errorf("note repository: get notes without agenda: failed to decode notes: %v", err)
+	}
+	return notes, nil
+}
+
+// Save agenda items of the note unless the note was changed since it was read,
+// returns false when the note was not updated
+func (n *NoteRepository) SetNoteAgenda(note models.Note, items []models.AgendaItem) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := n.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": note.ID, "revision": getRevisionFilter(note.Revision), "agenda": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"agenda": items}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("note repository: set note agenda: failed to update note: %v", err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// Drop the standalone agenda collection used before agenda items were stored inside notes
+func (n *NoteRepository) DropLegacyAgendaItems() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := n.db.Collection("agenda_items").Drop(ctx)
+	if err != nil {
+		return fmt.Errorf("note repository: drop legacy agenda items: %v", err)
+	}
+	return nil
+}
+
+func getAgendaDateFilter(field string, from *time.Time, to *time.Time) bson.M {
+	dateRange := bson.M{}
+	if from != nil {
+		dateRange["$gte"] = *from
+	}
+	if to != nil {
+		dateRange["$lte"] = *to
+	}
+	return bson.M{field + ".date": dateRange}
+}
+
+func getRepeatedAgendaFilter(field string, to *time.Time) bson.M {
+	filter := bson.M{field + ".repeater": bson.M{"$ne": nil}}
+	if to != nil {
+		filter[field+".date"] = bson.M{"$lte": *to}
+	}
+	return filter
+}
+
+// Return agenda items planned within the filter range.
+// Items with repeaters started before the range are returned too, their occurrences should be checked by caller
+func (n *NoteRepository) GetAgendaItems(authorID string, f models.AgendaFilter) ([]models.AgendaItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+
+	if len(f.States) > 0 {
+		filter["keyword"] = bson.M{"$in": f.States}
+	}
+
+	if f.From != nil || f.To != nil {
+		filter["$or"] = []bson.M{
+			getAgendaDateFilter("scheduled", f.From, f.To),
+			getAgendaDateFilter("deadline", f.From, f.To),
+			getRepeatedAgendaFilter("scheduled", f.To),
+			getRepeatedAgendaFilter("deadline", f.To),
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"authorId":  authorID,
+			"deletedAt": bson.M{"$eq": nil},
+			"agenda.0":  bson.M{"$exists": true},
+		}}},
+		{{Key: "$unwind", Value: "$agenda"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$agenda"}}},
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.D{
+			bson.E{Key: "scheduled.date", Value: 1},
+			bson.E{Key: "deadline.date", Value: 1},
+		}}},
+	}
+
+	cur, err := n.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get agenda items: failed to aggregate items: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	items := []models.AgendaItem{}
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, fmt.Errorf("note repository: get agenda items: failed to decode items: %v", err)
+	}
+	return items, nil
+}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSetAgendaOfNoteWithoutRevision(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("set agenda", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		noteID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mockFound("notes", bson.D{{Key: "_id", Value: noteID}, {Key: "externalId", Value: "note"}}),
+			mockWritten(1),
+		)
+
+		notes, err := repository.GetNotesWithoutAgenda(100)
+		require.NoError(t, err)
+		require.Len(t, notes, 1)
+
+		updated, err := repository.SetNoteAgenda(notes[0], []models.AgendaItem{})
+		require.NoError(t, err)
+		assert.True(t, updated)
+
+		updates := getStartedCommands(mt, "update")
+		require.Len(t, updates, 1)
+		filter := updates[0].Lookup("updates", "0", "q", "revision")
+		assert.Equal(t, `{"$in": [null,{"$numberLong":"0"}]}`, filter.String())
+	})
+
+	mt.Run("note was changed", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		mt.AddMockResponses(mockWritten(0))
+
+		updated, err := repository.SetNoteAgenda(models.Note{ID: primitive.NewObjectID(), Revision: 2}, []models.AgendaItem{})
+		require.NoError(t, err)
+		assert.False(t, updated)
+	})
+}
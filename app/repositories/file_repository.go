@@ -0,0 +1,295 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store user file names mapped to content addressed blobs, reference counters of blobs
+// and unfinished resumable uploads
+type FileRepository struct {
+	db      *mongo.Database
+	files   *mongo.Collection
+	blobs   *mongo.Collection
+	uploads *mongo.Collection
+}
+
+type blobModel struct {
+	Hash       string     `bson:"_id"`
+	Size       int64      `bson:"size"`
+	Refs       int64      `bson:"refs"`
+	DeletingAt *time.Time `bson:"deletingAt,omitempty"` // Set while blob content is being removed from storage
+}
+
+func NewFileRepository(db *mongo.Database) *FileRepository {
+	fileRepo := &FileRepository{
+		db:      db,
+		files:   db.Collection("files"),
+		blobs:   db.Collection("blobs"),
+		uploads: db.Collection("uploads"),
+	}
+	fileRepo.initIndexes()
+	return fileRepo
+}
+
+func (f *FileRepository) initIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	model := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				bson.E{Key: "userId", Value: 1},
+				bson.E{Key: "name", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{bson.E{Key: "hash", Value: 1}}},
+	}
+
+	name, err := f.files.Indexes().CreateMany(ctx, model)
+	if err != nil {
+		log.Error().Msgf("file repository: failed to create indexes: %v", err)
+		return
+	}
+	log.Info().Msgf("file repository: created indexes: %v", name)
+
+	uploadsModel := []mongo.IndexModel{
+		{Keys: bson.D{bson.E{Key: "userId", Value: 1}}},
+		{Keys: bson.D{bson.E{Key: "expiresAt", Value: 1}}},
+	}
+	name, err = f.uploads.Indexes().CreateMany(ctx, uploadsModel)
+	if err != nil {
+		log.Error().Msgf("file repository: failed to create upload indexes: %v", err)
+		return
+	}
+	log.Info().Msgf("file repository: created upload indexes: %v", name)
+}
+
+func (f *FileRepository) GetFile(userID string, name string) (*models.UserFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	file := models.UserFile{}
+	err := f.files.FindOne(ctx, bson.M{"userId": userID, "name": name}).Decode(&file)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file repository: get file: failed to find file: %v", err)
+	}
+	return &file, nil
+}
+
+func (f *FileRepository) GetFiles(userID string) ([]models.UserFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := f.files.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("file repository: get files: failed to find files: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	files := []models.UserFile{}
+	if err := cur.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("file repository: get files: failed to decode files: %v", err)
+	}
+	return files, nil
+}
+
+// Return ids of users which have at least one file
+func (f *FileRepository) GetFileOwners() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	owners, err := f.files.Distinct(ctx, "userId", bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("file repository: get file owners: failed to get distinct users: %v", err)
+	}
+
+	userIDs := []string{}
+	for _, owner := range owners {
+		if userID, ok := owner.(string); ok {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs, nil
+}
+
+// Create or replace user file, previous version of the file is returned
+func (f *FileRepository) UpsertFile(file models.UserFile) (*models.UserFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"hash":      file.Hash,
+			"size":      file.Size,
+			"mimeType":  file.MimeType,
+			"updatedAt": now,
+		},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	findOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	previousFile := models.UserFile{}
+	err := f.files.FindOneAndUpdate(ctx, bson.M{"userId": file.UserID, "name": file.Name}, update, findOptions).Decode(&previousFile)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file repository: upsert file: failed to update file: %v", err)
+	}
+	return &previousFile, nil
+}
+
+// Delete user file, deleted file is returned
+func (f *FileRepository) DeleteFile(userID string, name string) (*models.UserFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deletedFile := models.UserFile{}
+	err := f.files.FindOneAndDelete(ctx, bson.M{"userId": userID, "name": name}).Decode(&deletedFile)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file repository: delete file: failed to delete file: %v", err)
+	}
+	return &deletedFile, nil
+}
+
+// Change number of references to the blob, returns number of references after update
+func (f *FileRepository) AddBlobRefs(hash string, size int64, delta int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$inc":         bson.M{"refs": delta},
+		"$setOnInsert": bson.M{"size": size},
+	}
+	findOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	blob := blobModel{}
+	err := f.blobs.FindOneAndUpdate(ctx, bson.M{"_id": hash}, update, findOptions).Decode(&blob)
+	if err != nil {
+		return 0, fmt.Errorf("file repository: add blob refs: failed to update blob: %v", err)
+	}
+	return blob.Refs, nil
+}
+
+// Mark blob without references as being deleted, returns false when blob is referenced or already being deleted
+func (f *FileRepository) MarkBlobDeleting(hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := f.blobs.UpdateOne(
+		ctx,
+		bson.M{"_id": hash, "refs": bson.M{"$lte": 0}, "deletingAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletingAt": time.Now()}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("file repository: mark blob deleting: failed to update blob: %v", err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+func (f *FileRepository) UnmarkBlobDeleting(hash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := f.blobs.UpdateOne(ctx, bson.M{"_id": hash}, bson.M{"$unset": bson.M{"deletingAt": ""}})
+	if err != nil {
+		return fmt.Errorf("file repository: unmark blob deleting: failed to update blob: %v", err)
+	}
+	return nil
+}
+
+// Return time when removal of the blob content was started, nil when blob is not being deleted
+func (f *FileRepository) GetBlobDeletingAt(hash string) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	blob := blobModel{}
+	err := f.blobs.FindOne(ctx, bson.M{"_id": hash}).Decode(&blob)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file repository: get blob deleting at: failed to find blob: %v", err)
+	}
+	return blob.DeletingAt, nil
+}
+
+// Delete blob record when nothing references it, returns true when record was deleted
+func (f *FileRepository) DeleteUnreferencedBlob(hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := f.blobs.DeleteOne(ctx, bson.M{"_id": hash, "refs": bson.M{"$lte": 0}})
+	if err != nil {
+		return false, fmt.Errorf("file repository: delete unreferenced blob: failed to delete blob: %v", err)
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// Return size of unique user files, files with the same content are counted once
+func (f *FileRepository) GetUsedSpace(userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := f.files.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$hash", "size": bson.M{"$first": "$size"}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("file repository: get used space: failed to aggregate: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	var res struct {
+		Total int64 `bson:"total"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&res); err != nil {
+			return 0, fmt.Errorf("file repository: get used space: failed to decode: %v", err)
+		}
+	}
+	return res.Total, nil
+}
+
+// Return size of all stored blobs, content shared by several files is counted once
+func (f *FileRepository) GetStoredSpace() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := f.blobs.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"refs": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("file repository: get stored space: failed to aggregate: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	var res struct {
+		Total int64 `bson:"total"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&res); err != nil {
+			return 0, fmt.Errorf("file repository: get stored space: failed to decode: %v", err)
+		}
+	}
+	return res.Total, nil
+}
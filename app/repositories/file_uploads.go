@@ -0,0 +1,159 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (f *FileRepository) CreateUpload(upload models.FileUpload) (*models.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upload.ID = primitive.NewObjectID()
+	if upload.Chunks == nil {
+		upload.Chunks = []string{}
+	}
+	_, err := f.uploads.InsertOne(ctx, upload)
+	if err != nil {
+		return nil, fmt.Errorf("file repository: create upload: failed to insert upload: %v", err)
+	}
+	return &upload, nil
+}
+
+func (f *FileRepository) GetUpload(userID string, uploadID string) (*models.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(uploadID)
+	if err != nil {
+		return nil, nil
+	}
+
+	upload := models.FileUpload{}
+	err = f.uploads.FindOne(ctx, bson.M{"_id": id, "userId": userID}).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file repository: get upload: failed to find upload: %v", err)
+	}
+	return &upload, nil
+}
+
+// Append chunk when upload still has the expected offset, returns nil when upload was changed concurrently
+func (f *FileRepository) AppendUploadChunk(
+	uploadID primitive.ObjectID,
+	expectedOffset int64,
+	offset int64,
+	chunk string,
+	mimeType string,
+	hashState []byte,
+	expiresAt time.Time,
+) (*models.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"offset":    offset,
+			"mimeType":  mimeType,
+			"hashState": hashState,
+			"expiresAt": expiresAt,
+		},
+		"$push": bson.M{"chunks": chunk},
+	}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	upload := models.FileUpload{}
+	err := f.uploads.FindOneAndUpdate(ctx, bson.M{"_id": uploadID, "offset": expectedOffset}, update, updateOptions).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file repository: append upload chunk: failed to update upload: %v", err)
+	}
+	return &upload, nil
+}
+
+// Delete upload record, returns deleted upload or nil when it doesn't exist
+func (f *FileRepository) DeleteUpload(uploadID primitive.ObjectID) (*models.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	upload := models.FileUpload{}
+	err := f.uploads.FindOneAndDelete(ctx, bson.M{"_id": uploadID}).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file repository: delete upload: failed to delete upload: %v", err)
+	}
+	return &upload, nil
+}
+
+func (f *FileRepository) GetExpiredUploads(now time.Time) ([]models.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := f.uploads.Find(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	if err != nil {
+		return nil, fmt.Errorf("file repository: get expired uploads: failed to find uploads: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	uploads := []models.FileUpload{}
+	if err := cur.All(ctx, &uploads); err != nil {
+		return nil, fmt.Errorf("file repository: get expired uploads: failed to decode uploads: %v", err)
+	}
+	return uploads, nil
+}
+
+func (f *FileRepository) GetUserUploads(userID string) ([]models.FileUpload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := f.uploads.Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, fmt.Errorf("file repository: get user uploads: failed to find uploads: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	uploads := []models.FileUpload{}
+	if err := cur.All(ctx, &uploads); err != nil {
+		return nil, fmt.Errorf("file repository: get user uploads: failed to decode uploads: %v", err)
+	}
+	return uploads, nil
+}
+
+// Return total declared length of unfinished user uploads
+func (f *FileRepository) GetReservedUploadSpace(userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := f.uploads.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userID}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$length"}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("file repository: get reserved upload space: failed to aggregate: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	var res struct {
+		Total int64 `bson:"total"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&res); err != nil {
+			return 0, fmt.Errorf("file repository: get reserved upload space: failed to decode: %v", err)
+		}
+	}
+	return res.Total, nil
+}
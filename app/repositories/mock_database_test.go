@@ -0,0 +1,40 @@
+package repositories
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func newMockNoteRepository(mt *mtest.T) *NoteRepository {
+	return &NoteRepository{
+		db:         mt.DB,
+		collection: mt.DB.Collection("notes"),
+		revisions:  mt.DB.Collection("note_revisions"),
+		sequences:  mt.DB.Collection("note_sequences"),
+	}
+}
+
+// Response of FindOneAndUpdate which locked the change sequence
+func mockLockedSequence(seq int64) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "seq", Value: seq}}})
+}
+
+// Response of update or delete command which matched n documents
+func mockWritten(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+func mockFound(collection string, docs ...bson.D) bson.D {
+	return mtest.CreateCursorResponse(0, "test."+collection, mtest.FirstBatch, docs...)
+}
+
+// Return sent commands with provided name, like "update" or "insert"
+func getStartedCommands(mt *mtest.T, name string) []bson.Raw {
+	commands := []bson.Raw{}
+	for _, event := range mt.GetAllStartedEvents() {
+		if event.CommandName == name {
+			commands = append(commands, event.Command)
+		}
+	}
+	return commands
+}
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type fileReference struct {
+	FileName string   `bson:"_id"`
+	NoteIDs  []string `bson:"notes"`
+}
+
+// Return map of file names used by not deleted notes to the ids of these notes
+func (n *NoteRepository) GetFileReferences(userID string) (map[string][]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := n.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"authorId":    userID,
+			"deletedAt":   bson.M{"$eq": nil},
+			"meta.images": bson.M{"$ne": nil},
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$meta.images"}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$meta.images",
+			"notes": bson.M{"$addToSet": "$externalId"},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get file references: failed to aggregate: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	references := []fileReference{}
+	if err := cur.All(ctx, &references); err != nil {
+		return nil, fmt.Errorf("note repository: get file references: failed to decode: %v", err)
+	}
+
+	fileNotes := map[string][]string{}
+	for _, reference := range references {
+		fileNotes[reference.FileName] = reference.NoteIDs
+	}
+	return fileNotes, nil
+}
+
+// Return file names used by stored note revisions, these files are required to restore revisions
+func (n *NoteRepository) GetRevisionFiles(userID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	files, err := n.revisions.Distinct(ctx, "meta.images", bson.M{"authorId": userID})
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get revision files: failed to get distinct images: %v", err)
+	}
+
+	fileNames := []string{}
+	for _, file := range files {
+		if fileName, ok := file.(string); ok {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	return fileNames, nil
+}
+
+// Check that the file is used by at least one published note of the author
+func (n *NoteRepository) IsFilePublished(authorID string, fileName string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := n.collection.CountDocuments(ctx, bson.M{
+		"authorId":       authorID,
+		"deletedAt":      bson.M{"$eq": nil},
+		"meta.published": true,
+		"meta.images":    fileName,
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("note repository: is file published: failed to count notes: %v", err)
+	}
+	return count > 0, nil
+}
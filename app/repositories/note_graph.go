@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var graphNoteProjection = bson.M{
+	"externalId":          1,
+	"filePath":            1,
+	"meta.title":          1,
+	"meta.fileTags":       1,
+	"meta.connectedNotes": 1,
+}
+
+func getGraphNotesFilter(authorID string) bson.M {
+	return bson.M{
+		"authorId":  authorID,
+		"deletedAt": bson.M{"$eq": nil},
+	}
+}
+
+// Return not deleted notes of the author with fields required to build note graph
+func (n *NoteRepository) GetGraphNotes(authorID string) ([]models.Note, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetProjection(graphNoteProjection)
+
+	cur, err := n.collection.Find(ctx, getGraphNotesFilter(authorID), findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get graph notes: failed to find notes: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	notes := []models.Note{}
+	if err := cur.All(ctx, &notes); err != nil {
+		return nil, fmt.Errorf("note repository: get graph notes: failed to decode notes: %v", err)
+	}
+	return notes, nil
+}
+
+// Call fn for each not deleted note of the author with fields required to build note graph.
+// Notes are read with a cursor, so they are never loaded in memory all at once
+func (n *NoteRepository) ForEachGraphNote(authorID string, fn func(note models.Note) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	findOptions := options.Find().
+		SetProjection(graphNoteProjection).
+		SetSort(bson.D{bson.E{Key: "_id", Value: 1}})
+
+	cur, err := n.collection.Find(ctx, getGraphNotesFilter(authorID), findOptions)
+	if err != nil {
+		return fmt.Errorf("note repository: for each graph note: failed to find notes: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var note models.Note
+		if err := cur.Decode(&note); err != nil {
+			return fmt.Errorf("note repository: for each graph note: failed to decode note: %v", err)
+		}
+		if err := fn(note); err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return fmt.Errorf("note repository: for each graph note: cursor failed: %v", err)
+	}
+	return nil
+}
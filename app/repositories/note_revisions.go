@@ -0,0 +1,304 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (n *NoteRepository) initRevisionIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	model := []mongo.IndexModel{
+		{Keys: bson.D{
+			bson.E{Key: "authorId", Value: 1},
+			bson.E{Key: "noteId", Value: 1},
+			bson.E{Key: "createdAt", Value: -1},
+		}}}
+
+	name, err := n.revisions.Indexes().CreateMany(ctx, model)
+	if err != nil {
+		log.Error().Msgf("note repository: failed to create revision indexes: %v", err)
+		return
+	}
+	log.Info().Msgf("note repository: created revision indexes: %v", name)
+}
+
+func mapNoteToRevision(note models.Note) models.NoteRevision {
+	return models.NoteRevision{
+		ID:             primitive.NewObjectID(),
+		NoteID:         note.ExternalID,
+		AuthorID:       note.AuthorID,
+		Revision:       note.Revision,
+		EncryptionType: note.EncryptionType,
+		Encrypted:      note.Encrypted,
+		Content:        note.Content,
+		Meta:           note.Meta,
+		FilePath:       note.FilePath,
+		UpdatedAt:      note.UpdatedAt,
+		CreatedAt:      time.Now(),
+	}
+}
+
+func (n *NoteRepository) saveRevisions(ctx context.Context, notes []models.Note) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	revisions := make([]interface{}, 0, len(notes))
+	for _, note := range notes {
+		if note.DeletedAt != nil {
+			continue
+		}
+		revisions = append(revisions, mapNoteToRevision(note))
+	}
+
+	if len(revisions) == 0 {
+		return nil
+	}
+
+	_, err := n.revisions.InsertMany(ctx, revisions)
+	if err != nil {
+		return fmt.Errorf("note repository: save revisions: failed to insert revisions: %v", err)
+	}
+	return nil
+}
+
+func (n *NoteRepository) snapshotNotes(ctx context.Context, authorID string, externalIDs []string) error {
+	cur, err := n.collection.Find(ctx, bson.M{
+		"authorId":   authorID,
+		"externalId": bson.M{"$in": externalIDs},
+	})
+	if err != nil {
+		return fmt.Errorf("note repository: snapshot notes: failed to find notes: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	notes := []models.Note{}
+	if err := cur.All(ctx, &notes); err != nil {
+		return fmt.Errorf("note repository: snapshot notes: failed to decode notes: %v", err)
+	}
+
+	return n.saveRevisions(ctx, notes)
+}
+
+func (n *NoteRepository) GetNoteRevisions(externalID string, authorID string) ([]models.NoteRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().
+		SetSort(bson.D{bson.E{Key: "createdAt", Value: -1}}).
+		SetProjection(bson.M{"content": 0})
+
+	cur, err := n.revisions.Find(ctx, bson.M{"noteId": externalID, "authorId": authorID}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get note revisions: failed to find revisions: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	revisions := []models.NoteRevision{}
+	if err := cur.All(ctx, &revisions); err != nil {
+		return nil, fmt.Errorf("note repository: get note revisions: failed to decode revisions: %v", err)
+	}
+	return revisions, nil
+}
+
+func (n *NoteRepository) GetNoteRevision(externalID string, authorID string, revisionID string) (*models.NoteRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(revisionID)
+	if err != nil {
+		return nil, nil
+	}
+
+	revision := models.NoteRevision{}
+	err = n.revisions.FindOne(ctx, bson.M{
+		"_id":      objID,
+		"noteId":   externalID,
+		"authorId": authorID,
+	}).Decode(&revision)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get note revision: failed to find revision: %v", err)
+	}
+	return &revision, nil
+}
+
+// Return snapshot of the note with provided revision number
+func (n *NoteRepository) GetNoteRevisionByNumber(externalID string, authorID string, revision int64) (*models.NoteRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	noteRevision := models.NoteRevision{}
+	err := n.revisions.FindOne(ctx, bson.M{
+		"noteId":   externalID,
+		"authorId": authorID,
+		"revision": revision,
+	}).Decode(&noteRevision)
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get note revision by number: failed to find revision: %v", err)
+	}
+	return &noteRevision, nil
+}
+
+// Remove revisions older than maxAge and keep only last limit revisions for each provided note
+func (n *NoteRepository) PruneRevisions(authorID string, externalIDs []string, limit int, maxAge time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if maxAge > 0 {
+		_, err := n.revisions.DeleteMany(ctx, bson.M{
+			"authorId":  authorID,
+			"noteId":    bson.M{"$in": externalIDs},
+			"createdAt": bson.M{"$lt": time.Now().Add(-maxAge)},
+		})
+		if err != nil {
+			return fmt.Errorf("note repository: prune revisions: failed to delete outdated revisions: %v", err)
+		}
+	}
+
+	if limit <= 0 {
+		return nil
+	}
+
+	for _, externalID := range externalIDs {
+		findOptions := options.Find().
+			SetSort(bson.D{bson.E{Key: "createdAt", Value: -1}}).
+			SetSkip(int64(limit)).
+			SetProjection(bson.M{"_id": 1})
+
+		cur, err := n.revisions.Find(ctx, bson.M{"authorId": authorID, "noteId": externalID}, findOptions)
+		if err != nil {
+			return fmt.Errorf("note repository: prune revisions: failed to find redundant revisions: %v", err)
+		}
+
+		var redundant []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err = cur.All(ctx, &redundant)
+		if err != nil {
+			return fmt.Errorf("note repository: prune revisions: failed to decode redundant revisions: %v", err)
+		}
+		if len(redundant) == 0 {
+			continue
+		}
+
+		ids := make([]primitive.ObjectID, len(redundant))
+		for i, r := range redundant {
+			ids[i] = r.ID
+		}
+
+		_, err = n.revisions.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return fmt.Errorf("note repository: prune revisions: failed to delete redundant revisions: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (n *NoteRepository) getRevisionsUsedSpace(userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cur, err := n.revisions.Aggregate(ctx, mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{"authorId": userID}}},
+		bson.D{{Key: "$project", Value: bson.M{"size": bson.M{"$bsonSize": "$$ROOT"}}}},
+		bson.D{{Key: "$group", Value: bson.M{"_id": nil, "usedSpace": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("note repository: get revisions used space: failed to aggregate: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	var res struct {
+		UsedSpace int64 `bson:"usedSpace"`
+	}
+
+	if cur.Next(ctx) {
+		err := cur.Decode(&res)
+		if err != nil {
+			return 0, fmt.Errorf("note repository: get revisions used space: failed to decode: %v", err)
+		}
+	}
+
+	return res.UsedSpace, nil
+}
+
+func (n *NoteRepository) deleteUserRevisions(ctx context.Context, userID string) error {
+	_, err := n.revisions.DeleteMany(ctx, bson.M{"authorId": userID})
+	if err != nil {
+		return fmt.Errorf("note repository: delete user revisions: failed to delete revisions: %v", err)
+	}
+	return nil
+}
+
+// Replace the note with the content of provided revision. Current note state will be saved as a new revision
+func (n *NoteRepository) RestoreRevision(revision models.NoteRevision, agenda []models.AgendaItem) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return n.withChangeSequence(ctx, revision.AuthorID, 1, func(changeSeq int64) error {
+		err := n.snapshotNotes(ctx, revision.AuthorID, []string{revision.NoteID})
+		if err != nil {
+			return fmt.Errorf("note repository: restore revision: failed to snapshot note: %v", err)
+		}
+		return n.restoreRevision(ctx, revision, agenda, changeSeq)
+	})
+}
+
+func (n *NoteRepository) restoreRevision(
+	ctx context.Context,
+	revision models.NoteRevision,
+	agenda []models.AgendaItem,
+	changeSeq int64,
+) error {
+	now := time.Now()
+	note := models.Note{
+		ChangeSeq:      changeSeq,
+		ExternalID:     revision.NoteID,
+		AuthorID:       revision.AuthorID,
+		EncryptionType: revision.EncryptionType,
+		Encrypted:      revision.Encrypted,
+		Content:        revision.Content,
+		Meta:           revision.Meta,
+		FilePath:       revision.FilePath,
+		UpdatedAt:      now,
+		TouchedAt:      now,
+		Agenda:         agenda,
+	}
+
+	_, err := n.collection.UpdateOne(
+		ctx,
+		bson.M{"externalId": revision.NoteID, "authorId": revision.AuthorID},
+		bson.M{
+			"$set":         n.getUpdateNote(note),
+			"$inc":         bson.M{"revision": 1},
+			"$unset":       bson.M{"deletedAt": nil},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "createdAt": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("note repository: restore revision: failed to update note: %v", err)
+	}
+
+	return nil
+}
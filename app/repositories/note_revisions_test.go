@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"context"
+	"orgnote/app/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSaveRevisions(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("skip deleted notes", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		mt.AddMockResponses(mockWritten(1))
+		deletedAt := time.Now()
+
+		err := repository.saveRevisions(context.Background(), []models.Note{
+			{ExternalID: "note", AuthorID: "user", Revision: 3, Content: "content"},
+			{ExternalID: "deleted", AuthorID: "user", Revision: 5, DeletedAt: &deletedAt},
+		})
+		require.NoError(t, err)
+
+		inserts := getStartedCommands(mt, "insert")
+		require.Len(t, inserts, 1)
+		documents, err := inserts[0].Lookup("documents").Array().Values()
+		require.NoError(t, err)
+		require.Len(t, documents, 1)
+		assert.Equal(t, "note", documents[0].Document().Lookup("noteId").StringValue())
+		assert.Equal(t, int64(3), documents[0].Document().Lookup("revision").Int64())
+		assert.Equal(t, "content", documents[0].Document().Lookup("content").StringValue())
+	})
+
+	mt.Run("nothing to save", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		deletedAt := time.Now()
+
+		err := repository.saveRevisions(context.Background(), []models.Note{{ExternalID: "deleted", DeletedAt: &deletedAt}})
+		require.NoError(t, err)
+		assert.Empty(t, mt.GetAllStartedEvents())
+	})
+}
+
+func TestPruneRevisions(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("remove old and redundant revisions", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		redundantID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mockWritten(1),
+			mockFound("note_revisions", bson.D{{Key: "_id", Value: redundantID}}),
+			mockWritten(1),
+		)
+
+		err := repository.PruneRevisions("user", []string{"note"}, 2, time.Hour)
+		require.NoError(t, err)
+
+		deletes := getStartedCommands(mt, "delete")
+		require.Len(t, deletes, 2)
+		_, ok := deletes[0].Lookup("deletes", "0", "q", "createdAt", "$lt").TimeOK()
+		assert.True(t, ok, "revisions older than max age should be deleted")
+		assert.Equal(t, redundantID, deletes[1].Lookup("deletes", "0", "q", "_id", "$in", "0").ObjectID())
+
+		finds := getStartedCommands(mt, "find")
+		require.Len(t, finds, 1)
+		assert.Equal(t, int64(2), finds[0].Lookup("skip").Int64(), "last revisions should be kept")
+	})
+
+	mt.Run("without limit", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		mt.AddMockResponses(mockWritten(0))
+
+		err := repository.PruneRevisions("user", []string{"note"}, 0, time.Hour)
+		require.NoError(t, err)
+		assert.Len(t, getStartedCommands(mt, "delete"), 1)
+		assert.Empty(t, getStartedCommands(mt, "find"))
+	})
+}
+
+func TestRestoreRevision(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("snapshot note under sequence lock", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		currentNote := bson.D{
+			{Key: "externalId", Value: "note"},
+			{Key: "authorId", Value: "user"},
+			{Key: "revision", Value: int64(4)},
+			{Key: "content", Value: "current"},
+		}
+		mt.AddMockResponses(
+			mockLockedSequence(7),
+			mockFound("notes", currentNote),
+			mockWritten(1),
+			mockWritten(1),
+			mockWritten(1),
+		)
+
+		err := repository.RestoreRevision(models.NoteRevision{NoteID: "note", AuthorID: "user", Content: "restored"}, nil)
+		require.NoError(t, err)
+
+		commandNames := []string{}
+		for _, event := range mt.GetAllStartedEvents() {
+			commandNames = append(commandNames, event.CommandName)
+		}
+		assert.Equal(t, []string{"findAndModify", "find", "insert", "update", "update"}, commandNames)
+
+		revision := getStartedCommands(mt, "insert")[0].Lookup("documents", "0")
+		assert.Equal(t, "current", revision.Document().Lookup("content").StringValue())
+
+		updates := getStartedCommands(mt, "update")
+		set := updates[0].Lookup("updates", "0", "u", "$set")
+		assert.Equal(t, "restored", set.Document().Lookup("content").StringValue())
+		assert.Equal(t, int64(7), set.Document().Lookup("changeSeq").Int64())
+		assert.Equal(t, "note_sequences", updates[1].Lookup("update").StringValue(), "sequence should be unlocked")
+	})
+}
@@ -0,0 +1,235 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Change sequence lock lease. It must outlive the write timeout so that a lock
+// is never taken over while its holder can still commit.
+const changeSequenceLease = 30 * time.Second
+
+// Reserve count sequential change numbers for the user and run write with the first one.
+// The user's sequence stays locked until write returns, so changes are committed in
+// the order of their sequence numbers and a sync cursor can never skip a change
+// that was reserved earlier but committed later.
+func (n *NoteRepository) withChangeSequence(
+	ctx context.Context,
+	userID string,
+	count int,
+	write func(firstSeq int64) error,
+) error {
+	if count <= 0 {
+		return write(0)
+	}
+
+	lockID := primitive.NewObjectID()
+	lastSeq, err := n.lockChangeSequence(ctx, userID, lockID, count)
+	if err != nil {
+		return err
+	}
+	defer n.unlockChangeSequence(userID, lockID)
+
+	return write(lastSeq - int64(count) + 1)
+}
+
+// Take the user's sequence lock and increment the sequence in a single update.
+// While another writer holds the lock the filter does not match and the upsert
+// fails with a duplicate key, so the lock is retried until ctx expires.
+func (n *NoteRepository) lockChangeSequence(
+	ctx context.Context,
+	userID string,
+	lockID primitive.ObjectID,
+	count int,
+) (int64, error) {
+	delay := 5 * time.Millisecond
+
+	for {
+		now := time.Now()
+		res := n.sequences.FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"_id": userID,
+				"$or": bson.A{
+					bson.M{"lockedUntil": bson.M{"$exists": false}},
+					bson.M{"lockedUntil": bson.M{"$lt": now}},
+				},
+			},
+			bson.M{
+				"$inc": bson.M{"seq": int64(count)},
+				"$set": bson.M{"lockId": lockID, "lockedUntil": now.Add(changeSequenceLease)},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		)
+
+		var sequence struct {
+			Seq int64 `bson:"seq"`
+		}
+		err := res.Decode(&sequence)
+		if err == nil {
+			return sequence.Seq, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return 0, fmt.Errorf("note repository: lock change sequence: failed to increment sequence: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("note repository: lock change sequence: sequence is locked: %v", ctx.Err())
+		case <-time.After(delay):
+		}
+		if delay < 100*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+func (n *NoteRepository) unlockChangeSequence(userID string, lockID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := n.sequences.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "lockId": lockID},
+		bson.M{"$unset": bson.M{"lockId": "", "lockedUntil": ""}},
+	)
+	if err != nil {
+		log.Error().Err(err).Msgf("note repository: unlock change sequence: failed to unlock sequence for user %v", userID)
+	}
+}
+
+// Return notes (including deleted ones) changed after provided change sequence ordered by change sequence
+func (n *NoteRepository) GetChangesAfter(userID string, changeSeq int64, limit int64) ([]models.Note, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().
+		SetSort(bson.D{bson.E{Key: "changeSeq", Value: 1}}).
+		SetLimit(limit)
+
+	cur, err := n.collection.Find(ctx, bson.M{
+		"authorId":  userID,
+		"changeSeq": bson.M{"$gt": changeSeq},
+	}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get changes after: failed to find notes: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	notes := []models.Note{}
+	if err := cur.All(ctx, &notes); err != nil {
+		return nil, fmt.Errorf("note repository: get changes after: failed to decode notes: %v", err)
+	}
+	return notes, nil
+}
+
+// Mark notes as deleted when they were not changed after provided change sequence
+func (n *NoteRepository) DeleteNotesUpToSequence(noteIDs []string, authorID string, changeSeq int64) error {
+	if len(noteIDs) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return n.withChangeSequence(ctx, authorID, len(noteIDs), func(firstSeq int64) error {
+		deletedTime := time.Now()
+		notesModel := make([]mongo.WriteModel, len(noteIDs))
+
+		for i, noteID := range noteIDs {
+			notesModel[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{
+					"externalId": noteID,
+					"authorId":   authorID,
+					"changeSeq":  bson.M{"$lte": changeSeq},
+				}).
+				SetUpdate(bson.M{
+					"$set": bson.M{
+						"deletedAt":  deletedTime,
+						"lastSyncAt": deletedTime,
+						"changeSeq":  firstSeq + int64(i),
+					},
+					"$inc": bson.M{"revision": 1},
+				})
+		}
+
+		_, err := n.collection.BulkWrite(ctx, notesModel)
+		if err != nil {
+			return fmt.Errorf("note repository: delete notes up to sequence: failed to bulk update notes: %v", err)
+		}
+		return nil
+	})
+}
+
+// Assign change sequence numbers to notes saved before the change sequence was introduced,
+// so they are returned by cursor sync. Returns number of migrated notes
+func (n *NoteRepository) MigrateChangeSequences() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	authorIDs, err := n.collection.Distinct(ctx, "authorId", bson.M{"changeSeq": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, fmt.Errorf("note repository: migrate change sequences: failed to find authors: %v", err)
+	}
+
+	migratedNotes := 0
+	for _, authorID := range authorIDs {
+		userID, ok := authorID.(string)
+		if !ok {
+			continue
+		}
+		migrated, err := n.migrateUserChangeSequences(ctx, userID)
+		migratedNotes += migrated
+		if err != nil {
+			return migratedNotes, err
+		}
+	}
+	return migratedNotes, nil
+}
+
+// Notes are numbered in the order of their update under the user's sequence lock
+func (n *NoteRepository) migrateUserChangeSequences(ctx context.Context, userID string) (int, error) {
+	filter := bson.M{"authorId": userID, "changeSeq": bson.M{"$exists": false}}
+	findOptions := options.Find().
+		SetSort(bson.D{bson.E{Key: "updatedAt", Value: 1}, bson.E{Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"_id": 1})
+
+	cur, err := n.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return 0, fmt.Errorf("note repository: migrate change sequences: failed to find notes: %v", err)
+	}
+	var notes []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &notes); err != nil {
+		return 0, fmt.Errorf("note repository: migrate change sequences: failed to decode notes: %v", err)
+	}
+	if len(notes) == 0 {
+		return 0, nil
+	}
+
+	migrated := 0
+	err = n.withChangeSequence(ctx, userID, len(notes), func(firstSeq int64) error {
+		notesModel := make([]mongo.WriteModel, len(notes))
+		for i, note := range notes {
+			notesModel[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": note.ID, "changeSeq": bson.M{"$exists": false}}).
+				SetUpdate(bson.M{"$set": bson.M{"changeSeq": firstSeq + int64(i)}})
+		}
+
+		res, err := n.collection.BulkWrite(ctx, notesModel)
+		if err != nil {
+			return fmt.Errorf("note repository: migrate change sequences: failed to update notes: %v", err)
+		}
+		migrated = int(res.ModifiedCount)
+		return nil
+	})
+	return migrated, err
+}
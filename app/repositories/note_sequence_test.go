@@ -0,0 +1,46 @@
+package repositories
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMigrateChangeSequences(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("assign sequences", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{"user"}}),
+			mockFound("notes", bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, bson.D{{Key: "_id", Value: primitive.NewObjectID()}}),
+			mockLockedSequence(12),
+			mockWritten(2),
+			mockWritten(1),
+		)
+
+		migrated, err := repository.MigrateChangeSequences()
+		require.NoError(t, err)
+		assert.Equal(t, 2, migrated)
+
+		updates := getStartedCommands(mt, "update")
+		require.Len(t, updates, 2)
+		assert.Equal(t, int64(11), updates[0].Lookup("updates", "0", "u", "$set", "changeSeq").Int64())
+		assert.Equal(t, int64(12), updates[0].Lookup("updates", "1", "u", "$set", "changeSeq").Int64())
+		assert.Equal(t, "note_sequences", updates[1].Lookup("update").StringValue(), "sequence should be unlocked")
+	})
+
+	mt.Run("nothing to migrate", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{}}))
+
+		migrated, err := repository.MigrateChangeSequences()
+		require.NoError(t, err)
+		assert.Equal(t, 0, migrated)
+	})
+}
@@ -7,6 +7,14 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// Notes saved before revisions were introduced have no revision field, it's the same as revision 0
+func getRevisionFilter(revision int64) any {
+	if revision == 0 {
+		return bson.M{"$in": bson.A{nil, int64(0)}}
+	}
+	return revision
+}
+
 func addDeletedFilter(filter bson.M, modelFilter models.NoteFilter) {
 	if modelFilter.DeletedAt != nil {
 		t := true
@@ -24,7 +32,7 @@ func addDeletedFilter(filter bson.M, modelFilter models.NoteFilter) {
 		orQuery = append(orQuery, bson.M{"deletedAt": bson.M{"$eq": nil}})
 	}
 
-	filter["$or"] = orQuery
+	appendOrFilter(filter, orQuery)
 }
 
 func addPublishedFilter(filter bson.M, modelFilter models.NoteFilter) {
@@ -36,7 +44,18 @@ func addPublishedFilter(filter bson.M, modelFilter models.NoteFilter) {
 	if !*modelFilter.Published {
 		orQuery = append(orQuery, bson.M{"meta.published": bson.M{"$exists": false}})
 	}
-	filter["$or"] = orQuery
+	appendOrFilter(filter, orQuery)
+}
+
+// Several $or conditions should be combined with $and instead of overwriting each other
+func appendOrFilter(filter bson.M, orQuery []bson.M) {
+	existingOr, ok := filter["$or"]
+	if !ok {
+		filter["$or"] = orQuery
+		return
+	}
+	delete(filter, "$or")
+	filter["$and"] = []bson.M{{"$or": existingOr}, {"$or": orQuery}}
 }
 
 func addAuthorIdFilter(filter bson.M, modelFilter models.NoteFilter) {
@@ -54,10 +73,11 @@ func addUpdatedTimeFilter(filter bson.M, modelFilter models.NoteFilter) {
 	filter["lastSyncAt"] = bson.M{"$gte": *modelFilter.From}
 }
 
-func addSearchFilter(filter *bson.M, modelFilter models.NoteFilter) {
+func addSearchFilter(filter bson.M, modelFilter models.NoteFilter) {
 	if modelFilter.SearchText != nil && *modelFilter.SearchText != "" {
-		f := *filter
-		f["$text"] = bson.D{bson.E{Key: "$search", Value: *modelFilter.SearchText}}
+		filter["$text"] = bson.D{bson.E{Key: "$search", Value: *modelFilter.SearchText}}
+		// Text of encrypted notes is not searchable, it would only leak matches of the ciphertext
+		filter["encrypted"] = bson.M{"$ne": true}
 	}
 }
 
@@ -74,6 +94,7 @@ var filterBuilders = []func(filter bson.M, modelFilter models.NoteFilter){
 	addAuthorIdFilter,
 	addUpdatedTimeFilter,
 	addDeletedAtFilter,
+	addSearchFilter,
 }
 
 func getNotesFilter(modelFilter models.NoteFilter) bson.M {
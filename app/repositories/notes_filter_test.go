@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearchFilterExcludesEncryptedNotes(t *testing.T) {
+	searchText := "emacs"
+
+	filter := getNotesFilter(models.NoteFilter{SearchText: &searchText})
+
+	assert.Equal(t, bson.D{bson.E{Key: "$search", Value: "emacs"}}, filter["$text"])
+	assert.Equal(t, bson.M{"$ne": true}, filter["encrypted"])
+	assert.NotContains(t, getNotesFilter(models.NoteFilter{}), "encrypted")
+}
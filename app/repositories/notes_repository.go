@@ -17,11 +17,19 @@ import (
 type NoteRepository struct {
 	db         *mongo.Database
 	collection *mongo.Collection
+	revisions  *mongo.Collection
+	sequences  *mongo.Collection
 }
 
 func NewNoteRepository(db *mongo.Database) *NoteRepository {
-	noteRepo := &NoteRepository{db: db, collection: db.Collection("notes")}
+	noteRepo := &NoteRepository{
+		db:         db,
+		collection: db.Collection("notes"),
+		revisions:  db.Collection("note_revisions"),
+		sequences:  db.Collection("note_sequences"),
+	}
 	noteRepo.initIndexes()
+	noteRepo.initRevisionIndexes()
 	return noteRepo
 }
 
@@ -33,11 +41,30 @@ func (a *NoteRepository) initIndexes() {
 		log.Error().Msgf("note repository: failed to drop indexes: %v", err)
 	}
 	model := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				bson.E{Key: "meta.title", Value: "text"},
+				bson.E{Key: "meta.description", Value: "text"},
+				bson.E{Key: "meta.fileTags", Value: "text"},
+				bson.E{Key: "meta.headings.text", Value: "text"},
+				bson.E{Key: "content", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("notes_search").
+				SetDefaultLanguage("none").
+				SetWeights(bson.M{
+					"meta.title":         10,
+					"meta.fileTags":      8,
+					"meta.headings.text": 5,
+					"meta.description":   3,
+					"content":            1,
+				}),
+		},
 		{Keys: bson.D{
-			bson.E{Key: "meta.title", Value: "text"},
-			bson.E{Key: "meta.description", Value: "text"},
-			bson.E{Key: "meta.tags", Value: "text"},
-		}}}
+			bson.E{Key: "authorId", Value: 1},
+			bson.E{Key: "changeSeq", Value: 1},
+		}},
+	}
 
 	name, err := a.collection.Indexes().CreateMany(context.TODO(), model)
 	if err != nil {
@@ -83,6 +110,47 @@ func (a *NoteRepository) GetNotes(f models.NoteFilter) ([]models.Note, error) {
 	return notes, nil
 }
 
+type NoteSearchHit struct {
+	models.Note `bson:",inline"`
+	Score       float64 `bson:"score"`
+}
+
+// Return notes matched by filter search text sorted by relevance
+func (a *NoteRepository) SearchNotes(f models.NoteFilter) ([]NoteSearchHit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if f.SearchText == nil || *f.SearchText == "" {
+		return nil, errors.New("note repository: search notes: search text is required")
+	}
+
+	filter := getNotesFilter(f)
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{bson.E{Key: "score", Value: score}, bson.E{Key: "createdAt", Value: -1}})
+
+	if f.Limit != nil {
+		findOptions.SetLimit(*f.Limit)
+	}
+
+	if f.Offset != nil {
+		findOptions.SetSkip(*f.Offset)
+	}
+
+	cur, err := a.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("note repository: search notes: failed to find notes: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	hits := []NoteSearchHit{}
+	if err := cur.All(ctx, &hits); err != nil {
+		return nil, fmt.Errorf("note repository: search notes: failed to decode notes: %v", err)
+	}
+	return hits, nil
+}
+
 func (a *NoteRepository) NotesCount(f models.NoteFilter) (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -100,13 +168,17 @@ func (a *NoteRepository) AddNote(note models.Note) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := a.collection.InsertOne(ctx, note)
+	return a.withChangeSequence(ctx, note.AuthorID, 1, func(changeSeq int64) error {
+		note.Revision = 1
+		note.ChangeSeq = changeSeq
+		_, err := a.collection.InsertOne(ctx, note)
 
-	if err != nil {
-		return fmt.Errorf("note repository: failed to add note: %v", err)
-	}
+		if err != nil {
+			return fmt.Errorf("note repository: failed to add note: %v", err)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (a *NoteRepository) BulkUpsert(userID string, notes []models.Note) error {
@@ -117,24 +189,34 @@ func (a *NoteRepository) BulkUpsert(userID string, notes []models.Note) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	notesModels := make([]mongo.WriteModel, len(notes))
+	return a.withChangeSequence(ctx, userID, len(notes), func(firstSeq int64) error {
+		// Notes are overwritten under the sequence lock, so the snapshot is the replaced document
+		err := a.snapshotNotes(ctx, userID, getNoteExternalIDs(notes))
+		if err != nil {
+			return fmt.Errorf("note repository: failed to snapshot notes before upsert: %v", err)
+		}
 
-	for i, note := range notes {
-		// TODO: master id should be unique for each user
-		notesModels[i] = mongo.NewUpdateOneModel().
-			SetFilter(bson.M{"externalId": note.ExternalID, "authorId": userID}).
-			SetUpdate(bson.M{
-				"$set":         a.getUpdateNote(note),
-				"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "createdAt": note.CreatedAt},
-			}).
-			SetUpsert(true)
-	}
+		notesModels := make([]mongo.WriteModel, len(notes))
+
+		for i, note := range notes {
+			note.ChangeSeq = firstSeq + int64(i)
+			// TODO: master id should be unique for each user
+			notesModels[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"externalId": note.ExternalID, "authorId": userID}).
+				SetUpdate(bson.M{
+					"$set":         a.getUpdateNote(note),
+					"$inc":         bson.M{"revision": 1},
+					"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "createdAt": note.CreatedAt},
+				}).
+				SetUpsert(true)
+		}
 
-	_, err := a.collection.BulkWrite(ctx, notesModels)
-	if err != nil {
-		return fmt.Errorf("note repository: failed to bulk upsert notes: %v", err)
-	}
-	return nil
+		_, err = a.collection.BulkWrite(ctx, notesModels)
+		if err != nil {
+			return fmt.Errorf("note repository: failed to bulk upsert notes: %v", err)
+		}
+		return nil
+	})
 }
 
 func (a *NoteRepository) getUpdateNote(note models.Note) bson.M {
@@ -151,6 +233,8 @@ func (a *NoteRepository) getUpdateNote(note models.Note) bson.M {
 		"filePath":       note.FilePath,
 		"encryptionType": note.EncryptionType,
 		"encrypted":      note.Encrypted,
+		"changeSeq":      note.ChangeSeq,
+		"agenda":         note.Agenda,
 	}
 
 	return update
@@ -190,49 +274,146 @@ func (n *NoteRepository) MarkNotesAsDeleted(noteIds []string, authorId string) e
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	notesModel := make([]mongo.WriteModel, len(noteIds))
+	return n.withChangeSequence(ctx, authorId, len(noteIds), func(firstSeq int64) error {
+		notesModel := make([]mongo.WriteModel, len(noteIds))
 
-	for i, noteId := range noteIds {
-		notesModel[i] = mongo.NewUpdateOneModel().
-			SetFilter(bson.M{"externalId": noteId, "authorId": authorId}).
-			SetUpdate(bson.M{"$set": bson.M{"deletedAt": time.Now()}})
-	}
+		for i, noteId := range noteIds {
+			notesModel[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"externalId": noteId, "authorId": authorId}).
+				SetUpdate(bson.M{
+					"$set": bson.M{"deletedAt": time.Now(), "changeSeq": firstSeq + int64(i)},
+					"$inc": bson.M{"revision": 1},
+				})
+		}
 
-	_, err := n.collection.BulkWrite(ctx, notesModel)
-	if err != nil {
-		return fmt.Errorf("note repository: failed to bulk update notes: %v", err)
-	}
+		_, err := n.collection.BulkWrite(ctx, notesModel)
+		if err != nil {
+			return fmt.Errorf("note repository: failed to bulk update notes: %v", err)
+		}
 
-	return nil
+		return nil
+	})
 }
 
-func (n *NoteRepository) BulkUpdateOutdated(notes []models.Note, authorID string) error {
+// Write notes which are newer than saved ones and return ids of notes which were skipped
+// because the saved note was changed after their base revision or their update time
+func (n *NoteRepository) BulkUpdateOutdated(notes []models.Note, authorID string) ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	notesModel := []mongo.WriteModel{}
+	var skippedIDs []string
+	err := n.withChangeSequence(ctx, authorID, len(notes), func(firstSeq int64) error {
+		notesModel := []mongo.WriteModel{}
+		outdatedNotes := []models.Note{}
+		sequencedNotes := make([]models.Note, len(notes))
+
+		for i, note := range notes {
+			note.ChangeSeq = firstSeq + int64(i)
+			sequencedNotes[i] = note
+			model, outdatedNote, err := n.getUpdateOutdatedModel(note, authorID)
+			if err != nil {
+				return fmt.Errorf("note repository: failed to get update outdated model: %v", err)
+			}
+			notesModel = append(notesModel, model)
+			if outdatedNote != nil {
+				outdatedNotes = append(outdatedNotes, *outdatedNote)
+			}
+		}
 
-	for _, note := range notes {
-		model, err := n.getUpdateOutdatedModel(note, authorID)
+		res, err := n.collection.BulkWrite(ctx, notesModel)
+		if err != nil {
+			return fmt.Errorf("note repository: failed to bulk update notes: %v", err)
+		}
+
+		skippedIDs, err = n.getSkippedNoteIDs(ctx, authorID, sequencedNotes, res)
+		if err != nil {
+			return err
+		}
+
+		// Only overwritten notes are saved as revisions
+		err = n.saveRevisions(ctx, excludeNotes(outdatedNotes, skippedIDs))
 		if err != nil {
-			return fmt.Errorf("note repository: failed to get update outdated model: %v", err)
+			return fmt.Errorf("note repository: failed to snapshot outdated notes: %v", err)
+		}
+		return nil
+	})
+	return skippedIDs, err
+}
+
+func excludeNotes(notes []models.Note, externalIDs []string) []models.Note {
+	if len(externalIDs) == 0 {
+		return notes
+	}
+	excluded := make(map[string]struct{}, len(externalIDs))
+	for _, id := range externalIDs {
+		excluded[id] = struct{}{}
+	}
+
+	filteredNotes := []models.Note{}
+	for _, note := range notes {
+		if _, ok := excluded[note.ExternalID]; !ok {
+			filteredNotes = append(filteredNotes, note)
 		}
-		notesModel = append(notesModel, model)
+	}
+	return filteredNotes
+}
+
+// Return ids of written notes which don't have their change sequence, so they were not matched by update filter
+func (n *NoteRepository) getSkippedNoteIDs(
+	ctx context.Context,
+	authorID string,
+	notes []models.Note,
+	res *mongo.BulkWriteResult,
+) ([]string, error) {
+	if res.MatchedCount+res.InsertedCount >= int64(len(notes)) {
+		return nil, nil
 	}
 
-	_, err := n.collection.BulkWrite(ctx, notesModel)
+	cur, err := n.collection.Find(
+		ctx,
+		bson.M{"authorId": authorID, "externalId": bson.M{"$in": getNoteExternalIDs(notes)}},
+		options.Find().SetProjection(bson.M{"externalId": 1, "changeSeq": 1}),
+	)
 	if err != nil {
-		return fmt.Errorf("note repository: failed to bulk update notes: %v", err)
+		return nil, fmt.Errorf("note repository: get skipped notes: failed to find notes: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	savedNotes := []models.Note{}
+	if err := cur.All(ctx, &savedNotes); err != nil {
+		return nil, fmt.Errorf("note repository: get skipped notes: failed to decode notes: %v", err)
+	}
+	return findSkippedNoteIDs(notes, savedNotes), nil
+}
+
+func findSkippedNoteIDs(notes []models.Note, savedNotes []models.Note) []string {
+	savedChangeSeqs := make(map[string]int64, len(savedNotes))
+	for _, savedNote := range savedNotes {
+		savedChangeSeqs[savedNote.ExternalID] = savedNote.ChangeSeq
 	}
 
-	return nil
+	skippedIDs := []string{}
+	for _, note := range notes {
+		if savedChangeSeqs[note.ExternalID] != note.ChangeSeq {
+			skippedIDs = append(skippedIDs, note.ExternalID)
+		}
+	}
+	return skippedIDs
+}
 
+func getNoteExternalIDs(notes []models.Note) []string {
+	externalIDs := make([]string, len(notes))
+	for i, note := range notes {
+		externalIDs[i] = note.ExternalID
+	}
+	return externalIDs
 }
 
-func (n *NoteRepository) getUpdateOutdatedModel(note models.Note, authorID string) (mongo.WriteModel, error) {
+// Return write model for the note and the saved note which will be overwritten by it (if any)
+func (n *NoteRepository) getUpdateOutdatedModel(note models.Note, authorID string) (mongo.WriteModel, *models.Note, error) {
 	savedNote, err := n.GetNote(note.ExternalID, authorID)
 	if err != nil {
-		return nil, fmt.Errorf("note repository: failed to get note: %v", err)
+		return nil, nil, fmt.Errorf("note repository: failed to get note: %v", err)
 	}
 	noteNotExist := savedNote == nil
 	updatedNote := n.getUpdateNote(note)
@@ -240,19 +421,35 @@ func (n *NoteRepository) getUpdateOutdatedModel(note models.Note, authorID strin
 
 	if noteNotExist {
 		updatedNote["createdAt"] = note.CreatedAt
-		return mongo.NewInsertOneModel().SetDocument(updatedNote), nil
+		updatedNote["revision"] = int64(1)
+		return mongo.NewInsertOneModel().SetDocument(updatedNote), nil, nil
+	}
+
+	filter := bson.M{
+		"authorId":   authorID,
+		"externalId": note.ExternalID,
+	}
+	var outdatedNote *models.Note
+
+	if note.BaseRevision != nil {
+		filter["revision"] = getRevisionFilter(*note.BaseRevision)
+		if savedNote.AuthorID == authorID && savedNote.Revision == *note.BaseRevision {
+			outdatedNote = savedNote
+		}
+	} else {
+		filter["lastSyncAt"] = bson.M{"$lt": note.UpdatedAt}
+		if savedNote.AuthorID == authorID && savedNote.LastSyncAt.Before(note.UpdatedAt) {
+			outdatedNote = savedNote
+		}
 	}
 
 	return mongo.NewUpdateOneModel().
-		SetFilter(bson.M{
-			"authorId":   authorID,
-			"externalId": note.ExternalID,
-			"lastSyncAt": bson.M{"$lt": note.UpdatedAt},
-		}).
+		SetFilter(filter).
 		SetUpdate(bson.M{
 			"$set":   updatedNote,
+			"$inc":   bson.M{"revision": 1},
 			"$unset": bson.M{"deletedAt": nil},
-		}), nil
+		}), outdatedNote, nil
 }
 
 func (n *NoteRepository) DeleteOutdatedNotes(noteIDs []string, authorID string, deletedTime time.Time) error {
@@ -262,30 +459,32 @@ func (n *NoteRepository) DeleteOutdatedNotes(noteIDs []string, authorID string,
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	notesModel := []mongo.WriteModel{}
-
-	for _, noteID := range noteIDs {
-		model := mongo.NewUpdateOneModel().
-			SetFilter(bson.M{
-				"externalId": noteID,
-				"authorId":   authorID,
-				"lastSyncAt": bson.M{"$lt": deletedTime},
-			}).
-			SetUpdate(bson.M{
-				"$set":   bson.M{"deletedAt": deletedTime, "lastSyncAt": deletedTime},
-				"$unset": bson.M{"updatedAt": deletedTime},
-			})
-		notesModel = append(notesModel, model)
-	}
+	return n.withChangeSequence(ctx, authorID, len(noteIDs), func(firstSeq int64) error {
+		notesModel := []mongo.WriteModel{}
+
+		for i, noteID := range noteIDs {
+			model := mongo.NewUpdateOneModel().
+				SetFilter(bson.M{
+					"externalId": noteID,
+					"authorId":   authorID,
+					"lastSyncAt": bson.M{"$lt": deletedTime},
+				}).
+				SetUpdate(bson.M{
+					"$set":   bson.M{"deletedAt": deletedTime, "lastSyncAt": deletedTime, "changeSeq": firstSeq + int64(i)},
+					"$inc":   bson.M{"revision": 1},
+					"$unset": bson.M{"updatedAt": deletedTime},
+				})
+			notesModel = append(notesModel, model)
+		}
 
-	_, err := n.collection.BulkWrite(ctx, notesModel)
+		_, err := n.collection.BulkWrite(ctx, notesModel)
 
-	if err != nil {
-		return fmt.Errorf("note repository: failed to bulk update notes: %v", err)
-	}
-
-	return nil
+		if err != nil {
+			return fmt.Errorf("note repository: failed to bulk update notes: %v", err)
+		}
 
+		return nil
+	})
 }
 
 type AvailableSpaceInfo struct {
@@ -297,14 +496,14 @@ func (n *NoteRepository) getUsedSpace(userID string) (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	matchStage := bson.D{{"$match", bson.M{"authorId": userID}}}
+	matchStage := bson.D{{Key: "$match", Value: bson.M{"authorId": userID}}}
 	projectStage := bson.D{{
-		"$project", bson.M{
+		Key: "$project", Value: bson.M{
 			"size": bson.M{"$bsonSize": "$$ROOT"},
 		},
 	}}
 	groupedByUsedSpaceStage := bson.D{{
-		"$group", bson.M{
+		Key: "$group", Value: bson.M{
 			"_id":       nil,
 			"usedSpace": bson.M{"$sum": "$size"},
 		},
@@ -337,18 +536,18 @@ func (n *NoteRepository) getUploadedFiles(userID string) ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	matchStage := bson.D{{"$match", bson.M{"authorId": userID, "meta.images": bson.M{"$ne": nil}}}}
+	matchStage := bson.D{{Key: "$match", Value: bson.M{"authorId": userID, "meta.images": bson.M{"$ne": nil}}}}
 
 	documentsWithImages := bson.D{{
-		"$project", bson.M{"images": "$meta.images"},
+		Key: "$project", Value: bson.M{"images": "$meta.images"},
 	}}
 
 	unwindedImages := bson.D{{
-		"$unwind", bson.M{"path": "$images"},
+		Key: "$unwind", Value: bson.M{"path": "$images"},
 	}}
 
 	groupedImages := bson.D{{
-		"$group", bson.M{
+		Key: "$group", Value: bson.M{
 			"_id":   nil,
 			"files": bson.M{"$addToSet": "$images"},
 		}}}
@@ -384,13 +583,18 @@ func (n *NoteRepository) GetUsedSpaceInfo(userID string) (*AvailableSpaceInfo, e
 		return nil, fmt.Errorf("note repository: get used space info: failed to get used space: %v", err)
 	}
 
+	revisionsUsedSpace, err := n.getRevisionsUsedSpace(userID)
+	if err != nil {
+		return nil, fmt.Errorf("note repository: get used space info: failed to get revisions used space: %v", err)
+	}
+
 	uploadedFiles, err := n.getUploadedFiles(userID)
 	if err != nil {
 		return nil, fmt.Errorf("note repository: get used space info: failed to get uploaded files: %v", err)
 	}
 
 	return &AvailableSpaceInfo{
-		UsedSpace: usedSpace,
+		UsedSpace: usedSpace + revisionsUsedSpace,
 		Files:     uploadedFiles,
 	}, nil
 }
@@ -404,5 +608,5 @@ func (n *NoteRepository) DeleteUserNotes(userID string) error {
 		return fmt.Errorf("note repository: delete user notes: failed to delete notes: %v", err)
 	}
 
-	return nil
+	return n.deleteUserRevisions(ctx, userID)
 }
@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestGetRevisionFilter(t *testing.T) {
+	assert.Equal(t, bson.M{"$in": bson.A{nil, int64(0)}}, getRevisionFilter(0))
+	assert.Equal(t, int64(3), getRevisionFilter(3))
+}
+
+func TestFindSkippedNoteIDs(t *testing.T) {
+	notes := []models.Note{
+		{ExternalID: "written", ChangeSeq: 5},
+		{ExternalID: "changed", ChangeSeq: 6},
+		{ExternalID: "missing", ChangeSeq: 7},
+	}
+	savedNotes := []models.Note{
+		{ExternalID: "written", ChangeSeq: 5},
+		{ExternalID: "changed", ChangeSeq: 2},
+	}
+
+	assert.Equal(t, []string{"changed", "missing"}, findSkippedNoteIDs(notes, savedNotes))
+}
+
+func TestBulkUpdateOutdatedLegacyNote(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("legacy note without revision", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		legacyNote := bson.D{{Key: "externalId", Value: "note"}, {Key: "authorId", Value: "user"}}
+		mt.AddMockResponses(
+			mockLockedSequence(10),
+			mockFound("notes", legacyNote),
+			mockWritten(1),
+			mockWritten(1),
+			mockWritten(1),
+		)
+
+		baseRevision := int64(0)
+		skippedIDs, err := repository.BulkUpdateOutdated([]models.Note{{ExternalID: "note", BaseRevision: &baseRevision}}, "user")
+		require.NoError(t, err)
+		assert.Empty(t, skippedIDs)
+		inserts := getStartedCommands(mt, "insert")
+		require.Len(t, inserts, 1)
+		assert.Equal(t, "note_revisions", inserts[0].Lookup("insert").StringValue())
+
+		updates := getStartedCommands(mt, "update")
+		require.Len(t, updates, 2)
+		filter := updates[0].Lookup("updates", "0", "q", "revision")
+		assert.Equal(t, `{"$in": [null,{"$numberLong":"0"}]}`, filter.String())
+	})
+
+	mt.Run("note changed concurrently", func(mt *mtest.T) {
+		repository := newMockNoteRepository(mt)
+		savedNote := bson.D{{Key: "externalId", Value: "note"}, {Key: "authorId", Value: "user"}, {Key: "revision", Value: 3}}
+		mt.AddMockResponses(
+			mockLockedSequence(10),
+			mockFound("notes", savedNote),
+			mockWritten(0),
+			mockFound("notes", bson.D{{Key: "externalId", Value: "note"}, {Key: "changeSeq", Value: 4}}),
+			mockWritten(1),
+		)
+
+		baseRevision := int64(3)
+		skippedIDs, err := repository.BulkUpdateOutdated([]models.Note{{ExternalID: "note", BaseRevision: &baseRevision}}, "user")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"note"}, skippedIDs)
+		assert.Empty(t, getStartedCommands(mt, "insert"), "revision of not overwritten note should not be saved")
+	})
+}
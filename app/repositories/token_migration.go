@@ -0,0 +1,115 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"orgnote/app/models"
+	"orgnote/app/tools"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Replace plain session, API and calendar tokens stored before hashing with salted hashes,
+// returns number of migrated users. Users without plain tokens are not touched
+func (u *UserRepository) MigratePlainTokens(sessionExpiresAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"token": bson.M{"$exists": true}},
+		bson.M{"apiTokens.token": bson.M{"$exists": true}},
+		bson.M{"calendarTokens.token": bson.M{"$exists": true}},
+	}}
+	cur, err := u.collection.Find(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("user repository: migrate plain tokens: find users: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	migratedUsers := 0
+	for cur.Next(ctx) {
+		user := models.User{}
+		if err := cur.Decode(&user); err != nil {
+			return migratedUsers, fmt.Errorf("user repository: migrate plain tokens: decode user: %v", err)
+		}
+
+		set, err := hashPlainTokens(&user, sessionExpiresAt)
+		if err != nil {
+			return migratedUsers, fmt.Errorf("user repository: migrate plain tokens: %v", err)
+		}
+
+		update := bson.M{"$unset": bson.M{"token": ""}}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		_, err = u.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+		if err != nil {
+			return migratedUsers, fmt.Errorf("user repository: migrate plain tokens: update user: %v", err)
+		}
+		migratedUsers++
+	}
+	return migratedUsers, nil
+}
+
+// Hash plain tokens of the user, returns fields which should be updated.
+// Plain session token becomes a session
+func hashPlainTokens(user *models.User, sessionExpiresAt time.Time) (bson.M, error) {
+	set := bson.M{}
+
+	if user.Token != "" {
+		hashedToken, err := tools.HashToken(user.Token)
+		if err != nil {
+			return nil, fmt.Errorf("hash session token: %v", err)
+		}
+		user.Token = ""
+		user.Sessions = append(user.Sessions, newSession(SessionParams{Name: legacySessionName, ExpiresAt: sessionExpiresAt}, hashedToken, time.Now()))
+		set["sessions"] = user.Sessions
+	}
+
+	hasPlainAPITokens := false
+	for i := range user.APITokens {
+		apiToken := &user.APITokens[i]
+		if apiToken.Token == "" {
+			continue
+		}
+		hashedToken, err := tools.HashToken(apiToken.Token)
+		if err != nil {
+			return nil, fmt.Errorf("hash api token: %v", err)
+		}
+		apiToken.Token = ""
+		apiToken.Prefix = hashedToken.Prefix
+		apiToken.Hash = hashedToken.Hash
+		apiToken.Salt = hashedToken.Salt
+		hasPlainAPITokens = true
+	}
+	if hasPlainAPITokens {
+		set["apiTokens"] = user.APITokens
+	}
+
+	// Feed urls of calendar tokens keep working, the same token is hashed
+	hasPlainCalendarTokens := false
+	for i := range user.CalendarTokens {
+		calendarToken := &user.CalendarTokens[i]
+		if calendarToken.Token == "" {
+			continue
+		}
+		hashedToken, err := tools.HashToken(calendarToken.Token)
+		if err != nil {
+			return nil, fmt.Errorf("hash calendar token: %v", err)
+		}
+		calendarToken.Token = ""
+		calendarToken.Prefix = hashedToken.Prefix
+		calendarToken.Hash = hashedToken.Hash
+		calendarToken.Salt = hashedToken.Salt
+		hasPlainCalendarTokens = true
+	}
+	if hasPlainCalendarTokens {
+		set["calendarTokens"] = user.CalendarTokens
+	}
+
+	return set, nil
+}
+
+// Name of the session created from the single session token of the user
+const legacySessionName = "Previous session"
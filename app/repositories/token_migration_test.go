@@ -0,0 +1,39 @@
+package repositories
+
+import (
+	"orgnote/app/models"
+	"orgnote/app/tools"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHashPlainCalendarTokens(t *testing.T) {
+	plainToken := "7f1a2b3c-aaaa-bbbb-cccc-000000000000"
+	user := &models.User{CalendarTokens: []models.CalendarToken{{ID: primitive.NewObjectID(), Token: plainToken}}}
+
+	set, err := hashPlainTokens(user, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	calendarTokens, ok := set["calendarTokens"].([]models.CalendarToken)
+	require.True(t, ok)
+	require.Len(t, calendarTokens, 1)
+	assert.Empty(t, calendarTokens[0].Token)
+	assert.Equal(t, tools.TokenPrefix(plainToken), calendarTokens[0].Prefix)
+	assert.True(t, matchCalendarToken(user, plainToken), "feed url should keep working after migration")
+	assert.False(t, matchCalendarToken(user, "another-token"))
+}
+
+func TestHashPlainTokensWithoutCalendarTokens(t *testing.T) {
+	hashedToken, err := tools.HashToken("token")
+	require.NoError(t, err)
+	user := &models.User{CalendarTokens: []models.CalendarToken{{Prefix: hashedToken.Prefix, Hash: hashedToken.Hash, Salt: hashedToken.Salt}}}
+
+	set, err := hashPlainTokens(user, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.NotContains(t, set, "calendarTokens")
+	assert.True(t, matchCalendarToken(user, "token"))
+}
@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"orgnote/app/models"
+	"orgnote/app/tools"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Create one time token for the action, plain token is returned
+func (u *UserRepository) CreateActionToken(userID primitive.ObjectID, action string, expiresAt time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := tools.GenerateToken()
+	if err != nil {
+		return "", fmt.Errorf("user repository: create action token: generate token: %v", err)
+	}
+	hashedToken, err := tools.HashToken(token)
+	if err != nil {
+		return "", fmt.Errorf("user repository: create action token: hash token: %v", err)
+	}
+
+	_, err = u.actionTokens.InsertOne(ctx, models.UserActionToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Action:    action,
+		Prefix:    hashedToken.Prefix,
+		Hash:      hashedToken.Hash,
+		Salt:      hashedToken.Salt,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", fmt.Errorf("user repository: create action token: insert token: %v", err)
+	}
+	return token, nil
+}
+
+func (u *UserRepository) findActionToken(ctx context.Context, action string, token string, extraFilter bson.M) (*models.UserActionToken, error) {
+	filter := bson.M{
+		"action":    action,
+		"prefix":    tools.TokenPrefix(token),
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	for key, value := range extraFilter {
+		filter[key] = value
+	}
+	cur, err := u.actionTokens.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("find tokens: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	actionTokens := []models.UserActionToken{}
+	if err := cur.All(ctx, &actionTokens); err != nil {
+		return nil, fmt.Errorf("decode tokens: %v", err)
+	}
+
+	for _, actionToken := range actionTokens {
+		if tools.VerifyToken(token, actionToken.Hash, actionToken.Salt) {
+			return &actionToken, nil
+		}
+	}
+	return nil, nil
+}
+
+// Find not expired token and delete it, nil is returned when token is not found or already used
+func (u *UserRepository) ConsumeActionToken(action string, token string) (*models.UserActionToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	actionToken, err := u.findActionToken(ctx, action, token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: consume action token: %v", err)
+	}
+	if actionToken == nil {
+		return nil, nil
+	}
+	// Token could be consumed by concurrent request
+	deleted, err := u.deleteActionToken(ctx, actionToken.ID)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: consume action token: %v", err)
+	}
+	if !deleted {
+		return nil, nil
+	}
+	return actionToken, nil
+}
+
+// Find not expired token without deleting it
+func (u *UserRepository) FindActionToken(action string, token string) (*models.UserActionToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	actionToken, err := u.findActionToken(ctx, action, token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: find action token: %v", err)
+	}
+	return actionToken, nil
+}
+
+// Count usage attempt of the token which is deleted only after successful usage,
+// nil is returned when token is not found or all attempts are used
+func (u *UserRepository) UseActionTokenAttempt(action string, token string, maxAttempts int) (*models.UserActionToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	attemptsFilter := bson.M{"attempts": bson.M{"$lt": maxAttempts}}
+	actionToken, err := u.findActionToken(ctx, action, token, attemptsFilter)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: use action token attempt: %v", err)
+	}
+	if actionToken == nil {
+		return nil, nil
+	}
+
+	filter := bson.M{"_id": actionToken.ID, "attempts": bson.M{"$lt": maxAttempts}}
+	res, err := u.actionTokens.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"attempts": 1}})
+	if err != nil {
+		return nil, fmt.Errorf("user repository: use action token attempt: failed to update: %v", err)
+	}
+	if res.MatchedCount == 0 {
+		return nil, nil
+	}
+	actionToken.Attempts++
+	return actionToken, nil
+}
+
+func (u *UserRepository) deleteActionToken(ctx context.Context, tokenID primitive.ObjectID) (bool, error) {
+	res, err := u.actionTokens.DeleteOne(ctx, bson.M{"_id": tokenID})
+	if err != nil {
+		return false, fmt.Errorf("delete token: %v", err)
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// Delete used token, false is returned when it's already deleted by concurrent request
+func (u *UserRepository) DeleteActionToken(tokenID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deleted, err := u.deleteActionToken(ctx, tokenID)
+	if err != nil {
+		return false, fmt.Errorf("user repository: delete action token: %v", err)
+	}
+	return deleted, nil
+}
+
+func (u *UserRepository) DeleteActionTokens(userID primitive.ObjectID, action string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := u.actionTokens.DeleteMany(ctx, bson.M{"userId": userID, "action": action})
+	if err != nil {
+		return fmt.Errorf("user repository: delete action tokens: failed to delete: %v", err)
+	}
+	return nil
+}
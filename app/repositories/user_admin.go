@@ -0,0 +1,269 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orgnote/app/models"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notes and graph could be large, they are not needed for administration
+var adminUserProjection = bson.M{"notes": 0, "noteGraph": 0}
+
+func getUsersFilter(f models.UserFilter) bson.M {
+	filter := bson.M{}
+	if f.SearchText != nil && *f.SearchText != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(*f.SearchText), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"email": pattern},
+			bson.M{"name": pattern},
+			bson.M{"nickName": pattern},
+		}
+	}
+	if f.Role != nil {
+		if *f.Role == models.RoleUser {
+			filter["role"] = bson.M{"$in": bson.A{nil, "", models.RoleUser}}
+		} else {
+			filter["role"] = *f.Role
+		}
+	}
+	if f.Disabled != nil {
+		if *f.Disabled {
+			filter["disabled"] = true
+		} else {
+			filter["disabled"] = bson.M{"$ne": true}
+		}
+	}
+	return filter
+}
+
+// Return users without notes, the newest first
+func (u *UserRepository) GetUsers(f models.UserFilter) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().
+		SetProjection(adminUserProjection).
+		SetSort(bson.D{bson.E{Key: "_id", Value: -1}})
+	if f.Limit != nil {
+		findOptions.SetLimit(*f.Limit)
+	}
+	if f.Offset != nil {
+		findOptions.SetSkip(*f.Offset)
+	}
+
+	cur, err := u.collection.Find(ctx, getUsersFilter(f), findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: get users: failed to find users: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	users := []models.User{}
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("user repository: get users: failed to decode users: %v", err)
+	}
+	return users, nil
+}
+
+func (u *UserRepository) UsersCount(f models.UserFilter) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := u.collection.CountDocuments(ctx, getUsersFilter(f))
+	if err != nil {
+		return 0, fmt.Errorf("user repository: users count: failed to count: %v", err)
+	}
+	return count, nil
+}
+
+// Return user without notes, nil is returned when user doesn't exist
+func (u *UserRepository) FindUserByID(userID primitive.ObjectID) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user := models.User{}
+	findOptions := options.FindOne().SetProjection(adminUserProjection)
+	err := u.collection.FindOne(ctx, bson.M{"_id": userID}, findOptions).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user repository: find user by id: find one user: %v", err)
+	}
+	return &user, nil
+}
+
+func (u *UserRepository) updateUser(ctx context.Context, userID primitive.ObjectID, update bson.M) (bool, error) {
+	res, err := u.collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// Return false when user doesn't exist
+func (u *UserRepository) SetRole(userID primitive.ObjectID, role string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	found, err := u.updateUser(ctx, userID, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return false, fmt.Errorf("user repository: set role: failed to update: %v", err)
+	}
+	return found, nil
+}
+
+// Disabled user loses all sessions, API tokens stay but they are not accepted until user is enabled
+func (u *UserRepository) SetDisabled(userID primitive.ObjectID, disabled bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"disabled": ""}}
+	if disabled {
+		update = bson.M{
+			"$set":   bson.M{"disabled": true},
+			"$unset": bson.M{"sessions": ""},
+		}
+	}
+	found, err := u.updateUser(ctx, userID, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: set disabled: failed to update: %v", err)
+	}
+	return found, nil
+}
+
+// Set space limit which isn't changed by subscription, nil limit removes the override
+// and keeps current limit until subscription is updated
+func (u *UserRepository) SetSpaceLimitOverride(userID primitive.ObjectID, spaceLimit *int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"spaceLimitOverride": ""}}
+	if spaceLimit != nil {
+		update = bson.M{"$set": bson.M{"spaceLimit": *spaceLimit, "spaceLimitOverride": true}}
+	}
+	found, err := u.updateUser(ctx, userID, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: set space limit override: failed to update: %v", err)
+	}
+	return found, nil
+}
+
+// Delete all sessions, API and calendar tokens of the user
+func (u *UserRepository) RevokeTokens(userID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"apiTokens":      []models.APIToken{},
+			"calendarTokens": []models.CalendarToken{},
+		},
+		"$unset": bson.M{"sessions": ""},
+	}
+	found, err := u.updateUser(ctx, userID, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: revoke tokens: failed to update: %v", err)
+	}
+	return found, nil
+}
+
+// Grant admin role to local accounts with the verified emails and to users with the ids. Emails of other
+// providers are not promoted because providers don't guarantee they are verified. Emails should be lower cased,
+// malformed ids are skipped. Returns number of promoted users
+func (u *UserRepository) PromoteAdmins(emails []string, userIDs []string) (int64, error) {
+	filter := getPromoteAdminsFilter(emails, userIDs)
+	if filter == nil {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := u.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"role": models.RoleAdmin}})
+	if err != nil {
+		return 0, fmt.Errorf("user repository: promote admins: failed to update: %v", err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// Return filter of users to promote, nil when nobody could match
+func getPromoteAdminsFilter(emails []string, userIDs []string) bson.M {
+	conditions := bson.A{}
+	if len(emails) > 0 {
+		conditions = append(conditions, bson.M{
+			"provider":      models.LocalProvider,
+			"emailVerified": true,
+			"$expr":         bson.M{"$in": bson.A{bson.M{"$toLower": "$email"}, emails}},
+		})
+	}
+
+	objectUserIDs := []primitive.ObjectID{}
+	for _, id := range userIDs {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			log.Warn().Msgf("user repository: promote admins: skip invalid user id %q", id)
+			continue
+		}
+		objectUserIDs = append(objectUserIDs, objID)
+	}
+	if len(objectUserIDs) > 0 {
+		conditions = append(conditions, bson.M{"_id": bson.M{"$in": objectUserIDs}})
+	}
+
+	if len(conditions) == 0 {
+		return nil
+	}
+	return bson.M{
+		"$or":  conditions,
+		"role": bson.M{"$ne": models.RoleAdmin},
+	}
+}
+
+// Fill user counters and used space of instance stats
+func (u *UserRepository) GetUsersStats() (*models.InstanceStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	countIf := func(condition bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{condition, 1, 0}}}
+	}
+	cur, err := u.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"users":         bson.M{"$sum": 1},
+			"adminUsers":    countIf(bson.M{"$eq": bson.A{"$role", models.RoleAdmin}}),
+			"disabledUsers": countIf(bson.M{"$eq": bson.A{"$disabled", true}}),
+			"usedSpace":     bson.M{"$sum": "$usedSpace"},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("user repository: get users stats: failed to aggregate: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	stats := models.InstanceStats{}
+	if cur.Next(ctx) {
+		var res struct {
+			Users         int64 `bson:"users"`
+			AdminUsers    int64 `bson:"adminUsers"`
+			DisabledUsers int64 `bson:"disabledUsers"`
+			UsedSpace     int64 `bson:"usedSpace"`
+		}
+		if err := cur.Decode(&res); err != nil {
+			return nil, fmt.Errorf("user repository: get users stats: failed to decode: %v", err)
+		}
+		stats.Users = res.Users
+		stats.AdminUsers = res.AdminUsers
+		stats.DisabledUsers = res.DisabledUsers
+		stats.UsedSpace = res.UsedSpace
+	}
+	return &stats, nil
+}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPromoteAdminsFilterSkipsInvalidUserIDs(t *testing.T) {
+	userID := primitive.NewObjectID()
+
+	filter := getPromoteAdminsFilter(nil, []string{"invalid", userID.Hex()})
+
+	assert.Equal(t, bson.A{
+		bson.M{"_id": bson.M{"$in": []primitive.ObjectID{userID}}},
+	}, filter["$or"])
+	assert.Equal(t, bson.M{"$ne": models.RoleAdmin}, filter["role"])
+}
+
+func TestPromoteAdminsFilterMatchesVerifiedLocalEmails(t *testing.T) {
+	filter := getPromoteAdminsFilter([]string{"admin@example.com"}, nil)
+
+	assert.Equal(t, bson.A{
+		bson.M{
+			"provider":      models.LocalProvider,
+			"emailVerified": true,
+			"$expr":         bson.M{"$in": bson.A{bson.M{"$toLower": "$email"}, []string{"admin@example.com"}}},
+		},
+	}, filter["$or"])
+}
+
+func TestPromoteAdminsWithoutValidEntries(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("nothing is updated", func(mt *mtest.T) {
+		userRepository := &UserRepository{collection: mt.DB.Collection("users")}
+
+		promoted, err := userRepository.PromoteAdmins(nil, []string{"invalid"})
+
+		assert.NoError(t, err)
+		assert.Zero(t, promoted)
+		assert.Empty(t, getStartedCommands(mt, "update"))
+	})
+}
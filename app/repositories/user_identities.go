@@ -0,0 +1,111 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrIdentityLinked = errors.New("user repository: identity is linked to another user")
+
+func identityFilter(provider string, externalID string) bson.M {
+	return bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "externalId": externalID}}}
+}
+
+// Identity of the provider which user used for login
+func newIdentity(user *models.User) models.Identity {
+	return models.Identity{
+		ID:         primitive.NewObjectID(),
+		Provider:   user.Provider,
+		ExternalID: user.ExternalID,
+		Email:      user.Email,
+		NickName:   user.NickName,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// Attach identity to the user and return the stored one, nothing is changed when it's already attached.
+// Returns ErrIdentityLinked when the identity is attached to another user and nil when the user doesn't exist
+func (u *UserRepository) AddIdentity(userID primitive.ObjectID, user models.User) (*models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	identity := newIdentity(&user)
+	filter := bson.M{
+		"_id":        userID,
+		"identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "externalId": identity.ExternalID}}},
+	}
+	res, err := u.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"identities": identity}})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrIdentityLinked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user repository: add identity: update one user: %v", err)
+	}
+	if res.MatchedCount > 0 {
+		return &identity, nil
+	}
+
+	// Identity was already attached or the user doesn't exist
+	storedUser := models.User{}
+	err = u.collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&storedUser)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user repository: add identity: find user: %v", err)
+	}
+	return storedUser.FindIdentity(identity.Provider, identity.ExternalID), nil
+}
+
+// Detach identity when the user has other identities, returns false when nothing was removed
+func (u *UserRepository) RemoveIdentity(userID primitive.ObjectID, identityID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":            userID,
+		"identities._id": identityID,
+		"identities.1":   bson.M{"$exists": true},
+	}
+	res, err := u.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"identities": bson.M{"_id": identityID}}})
+	if err != nil {
+		return false, fmt.Errorf("user repository: remove identity: update one user: %v", err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// Create identities of users registered before identities were introduced
+// from their provider and external id, returns number of migrated users
+func (u *UserRepository) MigrateIdentities() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cur, err := u.collection.Find(ctx, bson.M{"identities": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, fmt.Errorf("user repository: migrate identities: find users: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	migratedUsers := 0
+	for cur.Next(ctx) {
+		user := models.User{}
+		if err := cur.Decode(&user); err != nil {
+			return migratedUsers, fmt.Errorf("user repository: migrate identities: decode user: %v", err)
+		}
+
+		update := bson.M{"$set": bson.M{"identities": []models.Identity{newIdentity(&user)}}}
+		_, err = u.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+		if err != nil {
+			return migratedUsers, fmt.Errorf("user repository: migrate identities: update user: %v", err)
+		}
+		migratedUsers++
+	}
+	return migratedUsers, nil
+}
@@ -5,24 +5,91 @@ import (
 	"errors"
 	"fmt"
 	"orgnote/app/models"
+	"orgnote/app/tools"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepository struct {
-	db         *mongo.Database
-	collection *mongo.Collection
+	db           *mongo.Database
+	collection   *mongo.Collection
+	actionTokens *mongo.Collection
 }
 
 func NewUserRepository(db *mongo.Database) *UserRepository {
-	return &UserRepository{
-		db:         db,
-		collection: db.Collection("users"),
+	userRepo := &UserRepository{
+		db:           db,
+		collection:   db.Collection("users"),
+		actionTokens: db.Collection("user_action_tokens"),
 	}
+	userRepo.initIndexes()
+	return userRepo
+}
+
+func (u *UserRepository) initIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	model := []mongo.IndexModel{
+		{Keys: bson.D{bson.E{Key: "sessions.prefix", Value: 1}}},
+		{Keys: bson.D{bson.E{Key: "apiTokens.prefix", Value: 1}}},
+		{Keys: bson.D{bson.E{Key: "calendarTokens.prefix", Value: 1}}},
+		// Identity could be linked to one user only
+		{
+			Keys: bson.D{
+				bson.E{Key: "identities.provider", Value: 1},
+				bson.E{Key: "identities.externalId", Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identities.provider": bson.M{"$exists": true}}),
+		},
+	}
+
+	name, err := u.collection.Indexes().CreateMany(ctx, model)
+	if err != nil {
+		log.Error().Msgf("user repository: failed to create indexes: %v", err)
+		return
+	}
+	log.Info().Msgf("user repository: created indexes: %v", name)
+
+	actionTokensModel := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				bson.E{Key: "action", Value: 1},
+				bson.E{Key: "prefix", Value: 1},
+			},
+		},
+		{Keys: bson.D{bson.E{Key: "userId", Value: 1}}},
+		// Expired tokens are removed by mongo
+		{Keys: bson.D{bson.E{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	name, err = u.actionTokens.Indexes().CreateMany(ctx, actionTokensModel)
+	if err != nil {
+		log.Error().Msgf("user repository: failed to create action token indexes: %v", err)
+		return
+	}
+	log.Info().Msgf("user repository: created action token indexes: %v", name)
+}
+
+// Check not expired sessions and API tokens of the user
+func matchUserToken(user *models.User, token string, now time.Time) bool {
+	for _, session := range user.Sessions {
+		if !session.IsExpired(now) && tools.VerifyToken(token, session.Hash, session.Salt) {
+			return true
+		}
+	}
+	for _, apiToken := range user.APITokens {
+		if !apiToken.IsExpired(now) && tools.VerifyToken(token, apiToken.Hash, apiToken.Salt) {
+			return true
+		}
+	}
+	return false
 }
 
 func (u *UserRepository) CreateOrGet(user models.User) (*models.User, error) {
@@ -46,11 +113,10 @@ func (u *UserRepository) CreateOrGet(user models.User) (*models.User, error) {
 func (u *UserRepository) UpdateAuthInfo(user models.User) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	filter := bson.M{"externalId": user.ExternalID, "provider": user.Provider}
+	filter := identityFilter(user.Provider, user.ExternalID)
 
 	_, err := u.collection.UpdateOne(ctx, filter, bson.D{
 		bson.E{Key: "$set", Value: bson.D{
-			bson.E{Key: "token", Value: user.Token},
 			bson.E{Key: "refreshToken", Value: user.RefreshToken},
 			bson.E{Key: "tokenExpiration", Value: user.TokenExpirationDate},
 			bson.E{Key: "profileUrl", Value: user.ProfileURL},
@@ -74,6 +140,12 @@ func (u *UserRepository) Create(user models.User) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	user.ID = primitive.NewObjectID()
+	if len(user.Identities) == 0 {
+		user.Identities = []models.Identity{newIdentity(&user)}
+	}
+	// Sessions are created separately
+	user.Token = ""
+	user.Sessions = nil
 	_, err := u.collection.InsertOne(ctx, user)
 
 	if err != nil {
@@ -88,10 +160,11 @@ func (u *UserRepository) Create(user models.User) (*models.User, error) {
 	return createdUser, nil
 }
 
+// Find user by linked identity with provider and external id of the passed user
 func (u *UserRepository) GetUser(user *models.User) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	filter := bson.M{"externalId": user.ExternalID, "provider": user.Provider}
+	filter := identityFilter(user.Provider, user.ExternalID)
 	err := u.collection.FindOne(ctx, filter).Decode(user)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
@@ -150,16 +223,32 @@ func (u *UserRepository) GetUsersByIDs(userIDs []string) ([]models.User, error)
 func (u *UserRepository) FindUserByToken(token string) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	filter := bson.M{"$or": bson.A{
-		bson.M{"token": token},
-		bson.M{"apiTokens": bson.M{"$elemMatch": bson.M{"token": token}}},
-	}}
-	user := models.User{}
-	err := u.collection.FindOne(ctx, filter).Decode(&user)
+	prefix := tools.TokenPrefix(token)
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"sessions.prefix": prefix},
+			bson.M{"apiTokens.prefix": prefix},
+		},
+		"disabled": bson.M{"$ne": true},
+	}
+	cur, err := u.collection.Find(ctx, filter)
 	if err != nil {
-		return nil, fmt.Errorf("user repository: find user by token: find one user: %v", err)
+		return nil, fmt.Errorf("user repository: find user by token: find users: %v", err)
 	}
-	return &user, nil
+	defer cur.Close(ctx)
+
+	// Several users could have tokens with the same prefix
+	users := []models.User{}
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("user repository: find user by token: decode users: %v", err)
+	}
+	now := time.Now()
+	for i := range users {
+		if matchUserToken(&users[i], token, now) {
+			return &users[i], nil
+		}
+	}
+	return nil, fmt.Errorf("user repository: find user by token: %v", mongo.ErrNoDocuments)
 }
 
 func (u *UserRepository) GetAPITokens(userID string) ([]models.APIToken, error) {
@@ -182,25 +271,54 @@ func (u *UserRepository) GetAPITokens(userID string) ([]models.APIToken, error)
 	return user.APITokens, nil
 }
 
-func (u *UserRepository) CreateAPIToken(user *models.User) (*models.APIToken, error) {
+func (u *UserRepository) CreateAPIToken(user *models.User, name string, scopes []string, expiresAt *time.Time) (*models.APIToken, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	filter := bson.M{"_id": user.ID}
-	token := uuid.New()
+	token, err := tools.GenerateToken()
+	if err != nil {
+		return nil, fmt.Errorf("user repository: create api token: generate token: %v", err)
+	}
+	hashedToken, err := tools.HashToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: create api token: hash token: %v", err)
+	}
 	accessToken := models.APIToken{
-		ID:          primitive.NewObjectID(),
-		Permissions: "w",
-		Token:       token.String(),
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Scopes:    scopes,
+		Prefix:    hashedToken.Prefix,
+		Hash:      hashedToken.Hash,
+		Salt:      hashedToken.Salt,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
 	}
 	update := bson.M{"$push": bson.M{"apiTokens": accessToken}}
 
-	_, err := u.collection.UpdateOne(ctx, filter, update)
+	_, err = u.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return nil, fmt.Errorf("user repository: create api token: update one user: %v", err)
 	}
+	accessToken.Token = token
 	return &accessToken, nil
 }
 
+func (u *UserRepository) TouchAPIToken(userID primitive.ObjectID, tokenID primitive.ObjectID, ip string, usedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": userID, "apiTokens._id": tokenID}
+	update := bson.M{"$set": bson.M{
+		"apiTokens.$.lastUsedAt": usedAt,
+		"apiTokens.$.lastUsedIp": ip,
+	}}
+
+	_, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("user repository: touch api token: update one user: %v", err)
+	}
+	return nil
+}
+
 // Delete user API token from list of tokens
 func (u *UserRepository) DeleteAPIToken(user *models.User, tokenID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -220,6 +338,108 @@ func (u *UserRepository) DeleteAPIToken(user *models.User, tokenID string) error
 	return nil
 }
 
+func matchCalendarToken(user *models.User, token string) bool {
+	for _, calendarToken := range user.CalendarTokens {
+		if tools.VerifyToken(token, calendarToken.Hash, calendarToken.Salt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *UserRepository) FindUserByCalendarToken(token string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{
+		"calendarTokens.prefix": tools.TokenPrefix(token),
+		"disabled":              bson.M{"$ne": true},
+	}
+	cur, err := u.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: find user by calendar token: find users: %v", err)
+	}
+	defer cur.Close(ctx)
+
+	// Several users could have tokens with the same prefix
+	users := []models.User{}
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("user repository: find user by calendar token: decode users: %v", err)
+	}
+	for i := range users {
+		if matchCalendarToken(&users[i], token) {
+			return &users[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (u *UserRepository) GetCalendarTokens(userID string) ([]models.CalendarToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: get calendar tokens: convert user id: %v", err)
+	}
+
+	filter := bson.M{"_id": userObjID}
+	user := models.User{}
+	err = u.collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && user.CalendarTokens == nil) {
+		return []models.CalendarToken{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user repository: get calendar tokens: find one user: %v", err)
+	}
+	return user.CalendarTokens, nil
+}
+
+func (u *UserRepository) CreateCalendarToken(user *models.User) (*models.CalendarToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": user.ID}
+	token, err := tools.GenerateToken()
+	if err != nil {
+		return nil, fmt.Errorf("user repository: create calendar token: generate token: %v", err)
+	}
+	hashedToken, err := tools.HashToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: create calendar token: hash token: %v", err)
+	}
+	calendarToken := models.CalendarToken{
+		ID:        primitive.NewObjectID(),
+		Prefix:    hashedToken.Prefix,
+		Hash:      hashedToken.Hash,
+		Salt:      hashedToken.Salt,
+		CreatedAt: time.Now(),
+	}
+	update := bson.M{"$push": bson.M{"calendarTokens": calendarToken}}
+
+	_, err = u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, fmt.Errorf("user repository: create calendar token: update one user: %v", err)
+	}
+	calendarToken.Token = token
+	return &calendarToken, nil
+}
+
+func (u *UserRepository) DeleteCalendarToken(user *models.User, tokenID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": user.ID}
+	id, err := primitive.ObjectIDFromHex(tokenID)
+	if err != nil {
+		return fmt.Errorf("user repository: delete calendar token: convert token id: %v", err)
+	}
+	update := bson.M{"$pull": bson.M{"calendarTokens": bson.M{"_id": id}}}
+
+	_, err = u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("user repository: delete calendar token: update one user: %v", err)
+	}
+
+	return nil
+}
+
 func (u *UserRepository) GetNoteGraph(userID string) (*models.NoteGraph, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -241,6 +461,24 @@ func (u *UserRepository) GetNoteGraph(userID string) (*models.NoteGraph, error)
 	return &user.NoteGraph, nil
 }
 
+func (u *UserRepository) SetNoteGraph(userID string, graph models.NoteGraph) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return fmt.Errorf("user repository: set note graph: convert user id: %v", err)
+	}
+
+	filter := bson.M{"_id": userObjID}
+	update := bson.M{"$set": bson.M{"noteGraph": graph}}
+
+	_, err = u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("user repository: set note graph: update one user: %v", err)
+	}
+	return nil
+}
+
 type GraphNoteLinks struct {
 	Node  models.GraphNoteNode
 	Links []models.GraphNoteLink
@@ -397,3 +635,41 @@ func (u *UserRepository) DeleteUser(userID string) error {
 
 	return nil
 }
+
+func (u *UserRepository) CountUsers() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := u.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("user repository: count users: failed to count: %v", err)
+	}
+	return count, nil
+}
+
+func (u *UserRepository) SetEmailVerified(userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := u.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"emailVerified": true}})
+	if err != nil {
+		return fmt.Errorf("user repository: set email verified: failed to update: %v", err)
+	}
+	return nil
+}
+
+// Change password hash and remove all sessions, so user should login again
+func (u *UserRepository) SetPassword(userID primitive.ObjectID, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set":   bson.M{"passwordHash": passwordHash},
+		"$unset": bson.M{"sessions": ""},
+	}
+	_, err := u.collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return fmt.Errorf("user repository: set password: failed to update: %v", err)
+	}
+	return nil
+}
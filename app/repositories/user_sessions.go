@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"orgnote/app/models"
+	"orgnote/app/tools"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SessionParams struct {
+	Name      string
+	UserAgent string
+	IP        string
+	ExpiresAt time.Time
+}
+
+// Session of the hashed token, it is seen for the first time on creation
+func newSession(params SessionParams, hashedToken tools.HashedToken, now time.Time) models.Session {
+	return models.Session{
+		ID:         primitive.NewObjectID(),
+		Name:       params.Name,
+		UserAgent:  params.UserAgent,
+		Prefix:     hashedToken.Prefix,
+		Hash:       hashedToken.Hash,
+		Salt:       hashedToken.Salt,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		LastSeenIP: params.IP,
+		ExpiresAt:  params.ExpiresAt,
+	}
+}
+
+// Create session of the device, plain session token is returned
+func (u *UserRepository) CreateSession(userID primitive.ObjectID, params SessionParams) (string, *models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := tools.GenerateToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("user repository: create session: generate token: %v", err)
+	}
+	hashedToken, err := tools.HashToken(token)
+	if err != nil {
+		return "", nil, fmt.Errorf("user repository: create session: hash token: %v", err)
+	}
+	now := time.Now()
+	session := newSession(params, hashedToken, now)
+
+	// Expired sessions are removed when new one is created
+	_, err = u.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$pull": bson.M{"sessions": bson.M{"expiresAt": bson.M{"$lte": now}}}})
+	if err != nil {
+		return "", nil, fmt.Errorf("user repository: create session: remove expired sessions: %v", err)
+	}
+	_, err = u.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$push": bson.M{"sessions": session}})
+	if err != nil {
+		return "", nil, fmt.Errorf("user repository: create session: update one user: %v", err)
+	}
+	return token, &session, nil
+}
+
+func (u *UserRepository) TouchSession(userID primitive.ObjectID, sessionID primitive.ObjectID, ip string, seenAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "sessions._id": sessionID}
+	update := bson.M{"$set": bson.M{
+		"sessions.$.lastSeenAt": seenAt,
+		"sessions.$.lastSeenIp": ip,
+	}}
+	_, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("user repository: touch session: update one user: %v", err)
+	}
+	return nil
+}
+
+// Replace token of not expired session and prolong it, empty token is returned when session is not found
+func (u *UserRepository) RotateSession(userID primitive.ObjectID, sessionID primitive.ObjectID, expiresAt time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := tools.GenerateToken()
+	if err != nil {
+		return "", fmt.Errorf("user repository: rotate session: generate token: %v", err)
+	}
+	hashedToken, err := tools.HashToken(token)
+	if err != nil {
+		return "", fmt.Errorf("user repository: rotate session: hash token: %v", err)
+	}
+
+	filter := bson.M{
+		"_id":      userID,
+		"sessions": bson.M{"$elemMatch": bson.M{"_id": sessionID, "expiresAt": bson.M{"$gt": time.Now()}}},
+	}
+	update := bson.M{"$set": bson.M{
+		"sessions.$.prefix":    hashedToken.Prefix,
+		"sessions.$.hash":      hashedToken.Hash,
+		"sessions.$.salt":      hashedToken.Salt,
+		"sessions.$.expiresAt": expiresAt,
+	}}
+	res, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return "", fmt.Errorf("user repository: rotate session: update one user: %v", err)
+	}
+	if res.MatchedCount == 0 {
+		return "", nil
+	}
+	return token, nil
+}
+
+// Delete session, returns false when session is not found
+func (u *UserRepository) DeleteSession(userID primitive.ObjectID, sessionID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "sessions._id": sessionID}
+	res, err := u.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"sessions": bson.M{"_id": sessionID}}})
+	if err != nil {
+		return false, fmt.Errorf("user repository: delete session: update one user: %v", err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+func (u *UserRepository) DeleteSessions(userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := u.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$unset": bson.M{"sessions": ""}})
+	if err != nil {
+		return fmt.Errorf("user repository: delete sessions: update one user: %v", err)
+	}
+	return nil
+}
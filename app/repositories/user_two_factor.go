@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"orgnote/app/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Save secret which is not confirmed yet, enabled two factor authentication is not changed
+func (u *UserRepository) SetTwoFactorSecret(userID primitive.ObjectID, secret string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "twoFactor.enabled": bson.M{"$ne": true}}
+	update := bson.M{"$set": bson.M{"twoFactor": models.TwoFactor{Secret: secret, RecoveryCodes: []models.RecoveryCode{}}}}
+	res, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: set two factor secret: failed to update: %v", err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// Enable two factor authentication with the secret confirmed by code of the time step
+func (u *UserRepository) EnableTwoFactor(userID primitive.ObjectID, secret string, step int64, recoveryCodes []models.RecoveryCode) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":               userID,
+		"twoFactor.enabled": bson.M{"$ne": true},
+		"twoFactor.secret":  secret,
+	}
+	update := bson.M{"$set": bson.M{
+		"twoFactor.enabled":       true,
+		"twoFactor.lastUsedStep":  step,
+		"twoFactor.recoveryCodes": recoveryCodes,
+		"twoFactor.enabledAt":     time.Now(),
+	}}
+	res, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: enable two factor: failed to update: %v", err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// Mark code of the time step as used, false is returned when code of this or later step is already used
+func (u *UserRepository) UseTwoFactorStep(userID primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":                    userID,
+		"twoFactor.enabled":      true,
+		"twoFactor.lastUsedStep": bson.M{"$lt": step},
+	}
+	update := bson.M{
+		"$set":   bson.M{"twoFactor.lastUsedStep": step, "twoFactor.failedAttempts": 0},
+		"$unset": bson.M{"twoFactor.lockedUntil": ""},
+	}
+	res, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: use two factor step: failed to update: %v", err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// Remove used recovery code, false is returned when it's already used
+func (u *UserRepository) UseRecoveryCode(userID primitive.ObjectID, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "twoFactor.recoveryCodes.hash": codeHash}
+	update := bson.M{
+		"$pull":  bson.M{"twoFactor.recoveryCodes": bson.M{"hash": codeHash}},
+		"$set":   bson.M{"twoFactor.failedAttempts": 0},
+		"$unset": bson.M{"twoFactor.lockedUntil": ""},
+	}
+	res, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: use recovery code: failed to update: %v", err)
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// Count attempt of code verification before the code is checked, so concurrent attempts are counted too.
+// Counter is reset by used code. False is returned when the user is locked or the counter was changed
+// by concurrent attempt since failedAttempts were read
+func (u *UserRepository) UseTwoFactorAttempt(userID primitive.ObjectID, failedAttempts int, lockedUntil *time.Time, now time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	failedAttemptsFilter := bson.M{"$eq": failedAttempts}
+	if failedAttempts == 0 {
+		failedAttemptsFilter = bson.M{"$in": bson.A{nil, 0}}
+	}
+	filter := bson.M{
+		"_id":                      userID,
+		"twoFactor.enabled":        true,
+		"twoFactor.failedAttempts": failedAttemptsFilter,
+		"$or": bson.A{
+			bson.M{"twoFactor.lockedUntil": nil},
+			bson.M{"twoFactor.lockedUntil": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"twoFactor.failedAttempts": failedAttempts + 1, "twoFactor.lockedUntil": lockedUntil}}
+	res, err := u.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("user repository: use two factor attempt: failed to update: %v", err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+func (u *UserRepository) SetRecoveryCodes(userID primitive.ObjectID, recoveryCodes []models.RecoveryCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": userID, "twoFactor.enabled": true}
+	_, err := u.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"twoFactor.recoveryCodes": recoveryCodes}})
+	if err != nil {
+		return fmt.Errorf("user repository: set recovery codes: failed to update: %v", err)
+	}
+	return nil
+}
+
+func (u *UserRepository) DisableTwoFactor(userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := u.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$unset": bson.M{"twoFactor": ""}})
+	if err != nil {
+		return fmt.Errorf("user repository: disable two factor: failed to update: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"orgnote/app/models"
+	"orgnote/app/repositories"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrInvalidSpaceLimit = errors.New("space limit should not be negative")
+	// Admin could lose access to administration by changing own account
+	ErrOwnAccount = errors.New("admin can't change role or disable own account")
+)
+
+const (
+	defaultUsersLimit = int64(20)
+	maxUsersLimit     = int64(100)
+)
+
+// Management of users and instance stats, available for users with admin role
+type AdminService struct {
+	userRepository *repositories.UserRepository
+	noteRepository *repositories.NoteRepository
+	fileRepository *repositories.FileRepository
+}
+
+func NewAdminService(
+	userRepository *repositories.UserRepository,
+	noteRepository *repositories.NoteRepository,
+	fileRepository *repositories.FileRepository,
+) *AdminService {
+	return &AdminService{userRepository, noteRepository, fileRepository}
+}
+
+func normalizeUserFilter(filter models.UserFilter) models.UserFilter {
+	limit := defaultUsersLimit
+	if filter.Limit != nil && *filter.Limit > 0 {
+		limit = *filter.Limit
+	}
+	if limit > maxUsersLimit {
+		limit = maxUsersLimit
+	}
+	offset := int64(0)
+	if filter.Offset != nil && *filter.Offset > 0 {
+		offset = *filter.Offset
+	}
+	filter.Limit = &limit
+	filter.Offset = &offset
+	return filter
+}
+
+func validateRole(role string) error {
+	for _, r := range models.UserRoles {
+		if r == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidRole, role)
+}
+
+func parseUserID(userID string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return primitive.NilObjectID, ErrUserNotFound
+	}
+	return id, nil
+}
+
+func (a *AdminService) GetUsers(filter models.UserFilter) (*models.Paginated[models.AdminUser], error) {
+	if filter.Role != nil {
+		if err := validateRole(*filter.Role); err != nil {
+			return nil, err
+		}
+	}
+	filter = normalizeUserFilter(filter)
+
+	users, err := a.userRepository.GetUsers(filter)
+	if err != nil {
+		return nil, fmt.Errorf("admin service: get users: %v", err)
+	}
+	total, err := a.userRepository.UsersCount(filter)
+	if err != nil {
+		return nil, fmt.Errorf("admin service: get users: %v", err)
+	}
+
+	adminUsers := make([]models.AdminUser, 0, len(users))
+	for i := range users {
+		adminUsers = append(adminUsers, *mapToAdminUser(&users[i]))
+	}
+	return &models.Paginated[models.AdminUser]{
+		Limit:  *filter.Limit,
+		Offset: *filter.Offset,
+		Total:  total,
+		Data:   adminUsers,
+	}, nil
+}
+
+func (a *AdminService) GetUser(userID string) (*models.AdminUser, error) {
+	id, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.userRepository.FindUserByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("admin service: get user: %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return mapToAdminUser(user), nil
+}
+
+// Run update of the user and return updated user info
+func (a *AdminService) updateUser(operation string, userID string, update func(id primitive.ObjectID) (bool, error)) (*models.AdminUser, error) {
+	id, err := parseUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	found, err := update(id)
+	if err != nil {
+		return nil, fmt.Errorf("admin service: %s: %v", operation, err)
+	}
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	return a.GetUser(userID)
+}
+
+func (a *AdminService) SetRole(admin *models.User, userID string, role string) (*models.AdminUser, error) {
+	if err := validateRole(role); err != nil {
+		return nil, err
+	}
+	if admin.ID.Hex() == userID {
+		return nil, ErrOwnAccount
+	}
+	return a.updateUser("set role", userID, func(id primitive.ObjectID) (bool, error) {
+		return a.userRepository.SetRole(id, role)
+	})
+}
+
+// Disabled user can't login, all sessions are closed and tokens are not accepted
+func (a *AdminService) SetDisabled(admin *models.User, userID string, disabled bool) (*models.AdminUser, error) {
+	if admin.ID.Hex() == userID {
+		return nil, ErrOwnAccount
+	}
+	return a.updateUser("set disabled", userID, func(id primitive.ObjectID) (bool, error) {
+		return a.userRepository.SetDisabled(id, disabled)
+	})
+}
+
+// Override space limit of subscription, nil limit returns control over the limit to subscription
+func (a *AdminService) SetSpaceLimit(userID string, spaceLimit *int64) (*models.AdminUser, error) {
+	if spaceLimit != nil && *spaceLimit < 0 {
+		return nil, ErrInvalidSpaceLimit
+	}
+	return a.updateUser("set space limit", userID, func(id primitive.ObjectID) (bool, error) {
+		return a.userRepository.SetSpaceLimitOverride(id, spaceLimit)
+	})
+}
+
+// Close all sessions and delete API and calendar tokens of the user
+func (a *AdminService) RevokeTokens(userID string) (*models.AdminUser, error) {
+	return a.updateUser("revoke tokens", userID, a.userRepository.RevokeTokens)
+}
+
+func (a *AdminService) GetStats() (*models.InstanceStats, error) {
+	stats, err := a.userRepository.GetUsersStats()
+	if err != nil {
+		return nil, fmt.Errorf("admin service: get stats: %v", err)
+	}
+	stats.Notes, err = a.noteRepository.NotesCount(models.NoteFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("admin service: get stats: %v", err)
+	}
+	stats.StoredSpace, err = a.fileRepository.GetStoredSpace()
+	if err != nil {
+		return nil, fmt.Errorf("admin service: get stats: %v", err)
+	}
+	return stats, nil
+}
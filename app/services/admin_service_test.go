@@ -0,0 +1,79 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeUserFilter(t *testing.T) {
+	filter := normalizeUserFilter(models.UserFilter{})
+	assert.Equal(t, defaultUsersLimit, *filter.Limit)
+	assert.Equal(t, int64(0), *filter.Offset)
+
+	limit, offset := int64(1000), int64(-5)
+	filter = normalizeUserFilter(models.UserFilter{Limit: &limit, Offset: &offset})
+	assert.Equal(t, maxUsersLimit, *filter.Limit)
+	assert.Equal(t, int64(0), *filter.Offset)
+}
+
+func TestValidateRole(t *testing.T) {
+	assert.NoError(t, validateRole(models.RoleAdmin))
+	assert.NoError(t, validateRole(models.RoleUser))
+	assert.ErrorIs(t, validateRole(""), ErrInvalidRole)
+	assert.ErrorIs(t, validateRole("root"), ErrInvalidRole)
+}
+
+func TestMapToAdminUser(t *testing.T) {
+	now := time.Now()
+	user := &models.User{
+		ID:        primitive.NewObjectIDFromTimestamp(now),
+		Email:     "user@example.com",
+		Disabled:  true,
+		TwoFactor: &models.TwoFactor{Enabled: true},
+		Sessions: []models.Session{
+			{ExpiresAt: now.Add(time.Hour)},
+			{ExpiresAt: now.Add(-time.Hour)},
+		},
+		APITokens: []models.APIToken{{}},
+	}
+
+	adminUser := mapToAdminUser(user)
+
+	assert.Equal(t, models.RoleUser, adminUser.Role)
+	assert.True(t, adminUser.Disabled)
+	assert.True(t, adminUser.TwoFactorEnabled)
+	assert.Equal(t, 1, adminUser.SessionsCount)
+	assert.Equal(t, 1, adminUser.APITokensCount)
+	assert.NotNil(t, adminUser.Identities)
+	assert.Equal(t, now.Unix(), adminUser.CreatedAt.Unix())
+}
+
+func TestAdminCantChangeOwnAccount(t *testing.T) {
+	service := NewAdminService(nil, nil, nil)
+	admin := &models.User{ID: primitive.NewObjectID(), Role: models.RoleAdmin}
+
+	_, err := service.SetRole(admin, admin.ID.Hex(), models.RoleUser)
+	assert.ErrorIs(t, err, ErrOwnAccount)
+
+	_, err = service.SetDisabled(admin, admin.ID.Hex(), true)
+	assert.ErrorIs(t, err, ErrOwnAccount)
+}
+
+func TestAdminServiceValidation(t *testing.T) {
+	service := NewAdminService(nil, nil, nil)
+	admin := &models.User{ID: primitive.NewObjectID(), Role: models.RoleAdmin}
+	negativeLimit := int64(-1)
+
+	_, err := service.SetRole(admin, primitive.NewObjectID().Hex(), "root")
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	_, err = service.SetSpaceLimit(primitive.NewObjectID().Hex(), &negativeLimit)
+	assert.ErrorIs(t, err, ErrInvalidSpaceLimit)
+
+	_, err = service.GetUser("invalid id")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"fmt"
+	"orgnote/app/models"
+	"orgnote/app/orgparser"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxRepeaterIterations = 10000
+
+const agendaMigrationBatchSize = 100
+
+func mapToAgendaTimestamp(timestamp *orgparser.Timestamp) *models.AgendaTimestamp {
+	if timestamp == nil || !timestamp.Active {
+		return nil
+	}
+
+	agendaTimestamp := &models.AgendaTimestamp{
+		Date:    timestamp.Time,
+		HasTime: timestamp.HasTime,
+	}
+	if timestamp.Repeater != nil {
+		agendaTimestamp.Repeater = &models.AgendaRepeater{
+			Type:  timestamp.Repeater.Type,
+			Value: timestamp.Repeater.Value,
+			Unit:  timestamp.Repeater.Unit,
+		}
+	}
+	return agendaTimestamp
+}
+
+// Build agenda items from headlines with TODO keyword or active SCHEDULED/DEADLINE timestamps
+func buildAgendaItems(note models.Note) []models.AgendaItem {
+	if note.Encrypted || note.DeletedAt != nil {
+		return []models.AgendaItem{}
+	}
+
+	items := []models.AgendaItem{}
+	for _, heading := range orgparser.Parse(note.Content).Headings {
+		scheduled := mapToAgendaTimestamp(heading.Scheduled)
+		deadline := mapToAgendaTimestamp(heading.Deadline)
+
+		if heading.Keyword == "" && scheduled == nil && deadline == nil {
+			continue
+		}
+
+		items = append(items, models.AgendaItem{
+			ID:        primitive.NewObjectID(),
+			AuthorID:  note.AuthorID,
+			NoteID:    note.ExternalID,
+			Title:     heading.Title,
+			Keyword:   heading.Keyword,
+			Done:      heading.Done,
+			Priority:  heading.Priority,
+			Tags:      heading.Tags,
+			Level:     heading.Level,
+			Scheduled: scheduled,
+			Deadline:  deadline,
+		})
+	}
+	return items
+}
+
+// Return the first occurrence of the timestamp which is not before from
+func nextOccurrence(timestamp models.AgendaTimestamp, from time.Time) time.Time {
+	date := timestamp.Date
+	repeater := timestamp.Repeater
+	if repeater == nil || repeater.Value <= 0 || !date.Before(from) {
+		return date
+	}
+
+	var step time.Duration
+	switch repeater.Unit {
+	case "h":
+		step = time.Duration(repeater.Value) * time.Hour
+	case "d":
+		step = time.Duration(repeater.Value) * 24 * time.Hour
+	case "w":
+		step = time.Duration(repeater.Value) * 7 * 24 * time.Hour
+	}
+
+	if step > 0 {
+		steps := (from.Sub(date) + step - 1) / step
+		return date.Add(steps * step)
+	}
+
+	for i := 1; i <= maxRepeaterIterations; i++ {
+		next := timestamp.Date.AddDate(0, i*repeater.Value, 0)
+		if repeater.Unit == "y" {
+			next = timestamp.Date.AddDate(i*repeater.Value, 0, 0)
+		}
+		if !next.Before(from) {
+			return next
+		}
+	}
+	return date
+}
+
+func occursBetween(timestamp *models.AgendaTimestamp, from *time.Time, to *time.Time) bool {
+	if timestamp == nil {
+		return false
+	}
+
+	date := timestamp.Date
+	if from != nil {
+		date = nextOccurrence(*timestamp, *from)
+	}
+
+	return (from == nil || !date.Before(*from)) && (to == nil || !date.After(*to))
+}
+
+func (n *NoteService) GetAgenda(userID string, filter models.AgendaFilter) ([]models.AgendaItem, error) {
+	items, err := n.noteRepository.GetAgendaItems(userID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("note service: get agenda: could not get agenda items: %v", err)
+	}
+
+	if filter.From == nil && filter.To == nil {
+		return items, nil
+	}
+
+	plannedItems := []models.AgendaItem{}
+	for _, item := range items {
+		if occursBetween(item.Scheduled, filter.From, filter.To) || occursBetween(item.Deadline, filter.From, filter.To) {
+			plannedItems = append(plannedItems, item)
+		}
+	}
+	return plannedItems, nil
+}
+
+// Project agenda items of notes saved before agenda items were stored inside notes.
+// Return number of migrated notes
+func (n *NoteService) MigrateAgenda() (int, error) {
+	migrated := 0
+	for {
+		notes, err := n.noteRepository.GetNotesWithoutAgenda(agendaMigrationBatchSize)
+		if err != nil {
+			return migrated, fmt.Errorf("note service: migrate agenda: could not get notes: %v", err)
+		}
+		if len(notes) == 0 {
+			break
+		}
+
+		batchMigrated := 0
+		for _, note := range notes {
+			updated, err := n.noteRepository.SetNoteAgenda(note, buildAgendaItems(note))
+			if err != nil {
+				return migrated, fmt.Errorf("note service: migrate agenda: could not set agenda of note %s: %v", note.ExternalID, err)
+			}
+			if updated {
+				batchMigrated++
+			}
+		}
+		migrated += batchMigrated
+		// The same notes would be returned again
+		if batchMigrated == 0 {
+			log.Warn().Msgf("note service: migrate agenda: agenda of %d notes could not be set", len(notes))
+			break
+		}
+	}
+
+	err := n.noteRepository.DropLegacyAgendaItems()
+	if err != nil {
+		return migrated, fmt.Errorf("note service: migrate agenda: %v", err)
+	}
+	return migrated, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildAgendaItems(t *testing.T) {
+	note := models.Note{
+		ExternalID: "note-id",
+		AuthorID:   "author-id",
+		Content:    "* TODO [#B] Review :work:\nDEADLINE: <2023-10-06 Fri>\n* Plain heading\n* Meeting\nSCHEDULED: <2023-10-05 Thu 10:00 +1w>",
+	}
+
+	items := buildAgendaItems(note)
+
+	assert.Len(t, items, 2)
+	assert.Equal(t, "Review", items[0].Title)
+	assert.Equal(t, "TODO", items[0].Keyword)
+	assert.Equal(t, "B", items[0].Priority)
+	assert.Equal(t, []string{"work"}, items[0].Tags)
+	assert.Equal(t, time.Date(2023, 10, 6, 0, 0, 0, 0, time.UTC), items[0].Deadline.Date)
+	assert.Equal(t, "Meeting", items[1].Title)
+	assert.Equal(t, &models.AgendaRepeater{Type: "+", Value: 1, Unit: "w"}, items[1].Scheduled.Repeater)
+}
+
+func TestBuildAgendaItemsSkipsEncryptedNotes(t *testing.T) {
+	note := models.Note{Content: "* TODO Secret", Encrypted: true}
+
+	assert.Empty(t, buildAgendaItems(note))
+}
+
+func TestOccursBetweenWithRepeater(t *testing.T) {
+	timestamp := &models.AgendaTimestamp{
+		Date:     time.Date(2023, 10, 5, 10, 0, 0, 0, time.UTC),
+		Repeater: &models.AgendaRepeater{Type: "+", Value: 1, Unit: "w"},
+	}
+	from := time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 11, 3, 0, 0, 0, 0, time.UTC)
+	anotherTo := time.Date(2023, 11, 2, 0, 0, 0, 0, time.UTC)
+
+	assert.True(t, occursBetween(timestamp, &from, &to))
+	assert.False(t, occursBetween(timestamp, &from, &anotherTo))
+}
+
+func TestNextOccurrenceMonthlyRepeater(t *testing.T) {
+	timestamp := models.AgendaTimestamp{
+		Date:     time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC),
+		Repeater: &models.AgendaRepeater{Type: ".+", Value: 2, Unit: "m"},
+	}
+
+	next := nextOccurrence(timestamp, time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, time.Date(2023, 5, 15, 0, 0, 0, 0, time.UTC), next)
+}
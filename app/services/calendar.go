@@ -0,0 +1,75 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"orgnote/app/configs"
+	"orgnote/app/models"
+	"orgnote/app/repositories"
+	"time"
+)
+
+var ErrCalendarNotFound = errors.New("calendar not found")
+
+type CalendarService struct {
+	noteRepository *repositories.NoteRepository
+	userRepository *repositories.UserRepository
+	config         configs.Config
+}
+
+func NewCalendarService(
+	noteRepository *repositories.NoteRepository,
+	userRepository *repositories.UserRepository,
+	config configs.Config,
+) *CalendarService {
+	return &CalendarService{noteRepository, userRepository, config}
+}
+
+func (c *CalendarService) withFeedURL(token *models.CalendarToken) {
+	token.URL = c.config.BackendHost() + "/calendar/" + token.Token + ".ics"
+}
+
+// Create calendar token, its feed url is returned only once because only hash of the token is stored
+func (c *CalendarService) CreateToken(user *models.User) (*models.CalendarToken, error) {
+	token, err := c.userRepository.CreateCalendarToken(user)
+	if err != nil {
+		return nil, fmt.Errorf("calendar service: create token: %v", err)
+	}
+	c.withFeedURL(token)
+	return token, nil
+}
+
+func (c *CalendarService) GetTokens(userID string) ([]models.CalendarToken, error) {
+	tokens, err := c.userRepository.GetCalendarTokens(userID)
+	if err != nil {
+		return nil, fmt.Errorf("calendar service: get tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+func (c *CalendarService) DeleteToken(user *models.User, tokenID string) error {
+	err := c.userRepository.DeleteCalendarToken(user, tokenID)
+	if err != nil {
+		return fmt.Errorf("calendar service: delete token: %v", err)
+	}
+	return nil
+}
+
+// Render iCalendar feed with planned headlines of the token owner.
+// Encrypted notes are never projected into agenda, so they are not exposed by the feed
+func (c *CalendarService) GetCalendar(token string) (string, error) {
+	user, err := c.userRepository.FindUserByCalendarToken(token)
+	if err != nil {
+		return "", fmt.Errorf("calendar service: get calendar: could not find user: %v", err)
+	}
+	if user == nil {
+		return "", ErrCalendarNotFound
+	}
+
+	items, err := c.noteRepository.GetAgendaItems(user.ID.Hex(), models.AgendaFilter{})
+	if err != nil {
+		return "", fmt.Errorf("calendar service: get calendar: could not get agenda items: %v", err)
+	}
+
+	return renderICalendar(items, time.Now()), nil
+}
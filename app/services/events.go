@@ -0,0 +1,30 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"time"
+)
+
+type EventPublisher interface {
+	Publish(event models.Event)
+}
+
+func publishEvent(publisher EventPublisher, eventType models.EventType, userID string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	publisher.Publish(models.Event{
+		Type:      eventType,
+		UserID:    userID,
+		IDs:       ids,
+		CreatedAt: time.Now(),
+	})
+}
+
+func getNoteIDs(notes []models.Note) []string {
+	ids := make([]string, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ExternalID
+	}
+	return ids
+}
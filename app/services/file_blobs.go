@@ -0,0 +1,237 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"orgnote/app/models"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Folder of content addressed blobs, file content is stored once by its SHA-256 hash
+const blobFolder = "blobs"
+
+func hashContent(content io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// Time after which unfinished blob deletion is considered abandoned
+const blobDeletionTimeout = time.Minute
+
+const blobDeletionPollInterval = 50 * time.Millisecond
+
+// Take a reference to the blob and make sure its content is in the storage.
+// The reference is taken first, so the blob can't be deleted after it was found in the storage.
+// The reference should be passed to attachBlob or released on failure
+func (a *FileService) storeBlob(hash string, size int64, content io.Reader) error {
+	_, err := a.fileRepository.AddBlobRefs(hash, size, 1)
+	if err != nil {
+		return fmt.Errorf("file service: store blob: %v", err)
+	}
+
+	err = a.waitBlobDeletion(hash)
+	if err == nil {
+		err = a.putMissingBlob(hash, size, content)
+	}
+	if err != nil {
+		a.releaseBlob(hash)
+		return fmt.Errorf("file service: store blob: %v", err)
+	}
+	return nil
+}
+
+// Wait until concurrent release of the blob finishes removing its content from storage
+func (a *FileService) waitBlobDeletion(hash string) error {
+	for {
+		deletingAt, err := a.fileRepository.GetBlobDeletingAt(hash)
+		if err != nil {
+			return err
+		}
+		if deletingAt == nil || time.Since(*deletingAt) > blobDeletionTimeout {
+			return nil
+		}
+		time.Sleep(blobDeletionPollInterval)
+	}
+}
+
+func (a *FileService) putMissingBlob(hash string, size int64, content io.Reader) error {
+	_, err := a.fileStorage.Stat(blobFolder, hash)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("could not get blob info: %v", err)
+	}
+
+	err = a.fileStorage.Put(blobFolder, hash, content, size)
+	if err != nil {
+		return fmt.Errorf("could not put blob: %v", err)
+	}
+	return nil
+}
+
+// Point user file name to the blob referenced by storeBlob, blob of the replaced file is released
+func (a *FileService) attachBlob(userID string, fileName string, mimeType string, hash string, size int64) error {
+	previousFile, err := a.fileRepository.UpsertFile(models.UserFile{
+		UserID:   userID,
+		Name:     fileName,
+		Hash:     hash,
+		Size:     size,
+		MimeType: mimeType,
+	})
+	if err != nil {
+		a.releaseBlob(hash)
+		return fmt.Errorf("file service: attach blob: %v", err)
+	}
+
+	if previousFile != nil {
+		a.releaseBlob(previousFile.Hash)
+	}
+
+	a.enqueueThumbnails(models.StoredFile{Name: fileName, Hash: hash, Size: size, MimeType: mimeType})
+	return nil
+}
+
+// Decrease number of blob references, blob without references is removed from storage.
+// Content is removed before the record, so storeBlob referencing the blob meanwhile either
+// waits for the removal or finds the content missing and puts it again
+func (a *FileService) releaseBlob(hash string) {
+	refs, err := a.fileRepository.AddBlobRefs(hash, 0, -1)
+	if err != nil {
+		log.Error().Err(err).Msgf("file service: release blob: could not release blob %s", hash)
+		return
+	}
+	if refs > 0 {
+		return
+	}
+
+	marked, err := a.fileRepository.MarkBlobDeleting(hash)
+	if err != nil {
+		log.Error().Err(err).Msgf("file service: release blob: could not mark blob %s as deleting", hash)
+		return
+	}
+	if !marked {
+		return
+	}
+
+	err = a.fileStorage.Delete(blobFolder, hash)
+	if err != nil {
+		log.Error().Err(err).Msgf("file service: release blob: could not delete blob %s from storage", hash)
+		a.unmarkBlobDeleting(hash)
+		return
+	}
+
+	deleted, err := a.fileRepository.DeleteUnreferencedBlob(hash)
+	if err != nil {
+		log.Error().Err(err).Msgf("file service: release blob: could not delete blob %s", hash)
+	}
+	if !deleted {
+		a.unmarkBlobDeleting(hash)
+		return
+	}
+	a.deleteThumbnails(hash)
+}
+
+func (a *FileService) unmarkBlobDeleting(hash string) {
+	err := a.fileRepository.UnmarkBlobDeleting(hash)
+	if err != nil {
+		log.Error().Err(err).Msgf("file service: release blob: could not unmark blob %s as deleting", hash)
+	}
+}
+
+// Store uploaded content as blob and point user file name to it
+func (a *FileService) storeFile(userID string, fileName string, mimeType string, content io.ReadSeeker) error {
+	hash, size, err := hashContent(content)
+	if err != nil {
+		return fmt.Errorf("file service: store file: could not hash content: %v", err)
+	}
+
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("file service: store file: could not rewind content: %v", err)
+	}
+
+	err = a.storeBlob(hash, size, content)
+	if err != nil {
+		return fmt.Errorf("file service: store file: %v", err)
+	}
+
+	return a.attachBlob(userID, fileName, mimeType, hash, size)
+}
+
+func (a *FileService) importLegacyFile(userID string, fileName string) error {
+	content, err := a.fileStorage.Get(userID, fileName)
+	if err != nil {
+		return fmt.Errorf("could not open file: %v", err)
+	}
+	hash, size, err := hashContent(content)
+	content.Close()
+	if err != nil {
+		return fmt.Errorf("could not hash file: %v", err)
+	}
+
+	content, err = a.fileStorage.Get(userID, fileName)
+	if err != nil {
+		return fmt.Errorf("could not open file: %v", err)
+	}
+	err = a.storeBlob(hash, size, content)
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	err = a.attachBlob(userID, fileName, getMimeType(fileName), hash, size)
+	if err != nil {
+		return err
+	}
+
+	return a.fileStorage.Delete(userID, fileName)
+}
+
+// Move files stored inside user folders before deduplication into blob storage.
+// Return number of imported files, files which could not be imported are logged and left in place
+func (a *FileService) ImportLegacyFiles() (int, error) {
+	folders, err := a.fileStorage.ListFolders()
+	if err != nil {
+		return 0, fmt.Errorf("file service: import legacy files: could not list folders: %v", err)
+	}
+
+	imported := 0
+	for _, folder := range folders {
+		if !primitive.IsValidObjectID(folder) {
+			continue
+		}
+
+		files, err := a.fileStorage.List(folder)
+		if err != nil {
+			log.Error().Err(err).Msgf("file service: import legacy files: could not list files of user %s", folder)
+			continue
+		}
+
+		importedUserFiles := 0
+		for _, file := range files {
+			if err := a.importLegacyFile(folder, file.Name); err != nil {
+				log.Error().Err(err).Msgf("file service: import legacy files: could not import file %s of user %s", file.Name, folder)
+				continue
+			}
+			importedUserFiles++
+		}
+
+		if importedUserFiles > 0 {
+			imported += importedUserFiles
+			go a.noteService.CalculateUserSpace(folder)
+		}
+	}
+	return imported, nil
+}
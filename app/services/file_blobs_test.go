@@ -0,0 +1,37 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashContent(t *testing.T) {
+	hash, size, err := hashContent(strings.NewReader("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", hash)
+	assert.Equal(t, int64(5), size)
+}
+
+func TestHashContentSameForEqualContent(t *testing.T) {
+	first, _, err := hashContent(strings.NewReader("image"))
+	assert.NoError(t, err)
+	second, _, err := hashContent(strings.NewReader("image"))
+	assert.NoError(t, err)
+	another, _, err := hashContent(strings.NewReader("another image"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, another)
+}
+
+func TestMapUserFileToStoredFile(t *testing.T) {
+	file := mapUserFileToStoredFile(models.UserFile{Name: "image.png", Hash: "abc", Size: 3})
+
+	assert.Equal(t, "image/png", file.MimeType)
+	assert.Equal(t, "abc", file.Hash)
+	assert.Equal(t, int64(3), file.Size)
+}
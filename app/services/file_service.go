@@ -1,54 +1,435 @@
 package services
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"mime/multipart"
+	"orgnote/app/configs"
 	"orgnote/app/models"
 	"orgnote/app/repositories"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
 )
 
-type FileStorage interface {
-	Upload(folder string, fileName string, file io.Reader) error
-}
+var (
+	ErrInvalidFileName   = errors.New("invalid file name")
+	ErrMediaNotFound     = errors.New("media not found")
+	ErrMediaAccessDenied = errors.New("media access denied")
+)
 
 type FileService struct {
-	fileStorage    FileStorage
-	userRepository *repositories.UserRepository
+	fileStorage       FileStorage
+	fileRepository    *repositories.FileRepository
+	userRepository    *repositories.UserRepository
+	noteRepository    *repositories.NoteRepository
+	noteService       *NoteService
+	config            configs.Config
+	events            EventPublisher
+	queue             *cron.Cron
+	thumbnails        chan models.StoredFile
+	pendingThumbnails sync.Map // Hashes of files queued for thumbnails generation
 }
 
-func NewFileService(fileStorage FileStorage, userRepository *repositories.UserRepository) *FileService {
-	return &FileService{
+func NewFileService(
+	fileStorage FileStorage,
+	fileRepository *repositories.FileRepository,
+	userRepository *repositories.UserRepository,
+	noteRepository *repositories.NoteRepository,
+	noteService *NoteService,
+	config configs.Config,
+	events EventPublisher,
+) *FileService {
+	fileService := &FileService{
 		fileStorage:    fileStorage,
+		fileRepository: fileRepository,
 		userRepository: userRepository,
+		noteRepository: noteRepository,
+		noteService:    noteService,
+		config:         config,
+		events:         events,
 	}
+	fileService.startThumbnailWorkers()
+	return fileService
 }
 
-// TODO: master add async task for deleting files without connected notes
-func (a *FileService) UploadFiles(user *models.User, fileHeaders []*multipart.FileHeader) error {
-	wg := sync.WaitGroup{}
+// Upload files one by one, every file gets its own result. Files which don't pass
+// upload policy are rejected without affecting other files
+func (a *FileService) UploadFiles(user *models.User, fileHeaders []*multipart.FileHeader) ([]models.UploadResult, error) {
+	userID := user.ID.Hex()
+	reservedSpace, err := a.fileRepository.GetReservedUploadSpace(userID)
+	if err != nil {
+		return nil, fmt.Errorf("file service: upload files: %v", err)
+	}
+
+	results := []models.UploadResult{}
+	uploadedFiles := []string{}
 	for _, fh := range fileHeaders {
-		go func(fh *multipart.FileHeader) {
-			wg.Add(1)
-			defer wg.Done()
-
-			file, err := fh.Open()
-			defer file.Close()
-			if err != nil {
-				log.Err(err).Msgf("file service: upload images: could not open uploaded file: %v", fh.Filename)
-			}
-			userFileDir := user.ID.Hex()
-			err = a.fileStorage.Upload(userFileDir, fh.Filename, file)
-			if err != nil {
-				log.Err(err).Msgf("file service: upload images: could not upload image: %v", err)
-				// TODO: add aggregation of errors
-				return
-			}
-		}(fh)
-		wg.Wait()
+		fileName := sanitizeFileName(fh.Filename)
+		err := a.uploadFile(user, fileName, fh, reservedSpace)
+		if err != nil && uploadErrorCode(err) == UploadErrorInternal {
+			log.Err(err).Msgf("file service: upload files: could not upload file: %v", fh.Filename)
+		}
+		if err == nil {
+			reservedSpace += fh.Size
+			uploadedFiles = append(uploadedFiles, fileName)
+		}
+		results = append(results, newUploadResult(fh.Filename, fileName, fh.Size, err))
+	}
+
+	if len(uploadedFiles) > 0 {
+		go a.noteService.CalculateUserSpace(userID)
+		publishEvent(a.events, models.EventFileUploaded, userID, uploadedFiles)
+	}
+	return results, nil
+}
+
+func (a *FileService) uploadFile(user *models.User, fileName string, fh *multipart.FileHeader, reservedSpace int64) error {
+	if fileName == "" {
+		return ErrInvalidFileName
+	}
+	if !hasSpaceForUpload(user, reservedSpace, fh.Size) {
+		return ErrNoSpaceLeft
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return fmt.Errorf("could not open uploaded file: %v", err)
+	}
+	defer file.Close()
+
+	sniffedType, _, err := sniffReader(file)
+	if err != nil {
+		return fmt.Errorf("could not read uploaded file: %v", err)
+	}
+	mimeType, err := resolveUploadMimeType(a.config.UploadAllowedTypes, fileName, sniffedType)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("could not rewind uploaded file: %v", err)
+	}
+	return a.storeFile(user.ID.Hex(), fileName, mimeType, file)
+}
+
+type MediaAccess struct {
+	User      *models.User
+	Expires   string // Expiration unix time of signed url
+	Signature string
+}
+
+type Media struct {
+	File        *models.StoredFile
+	Content     io.ReadCloser
+	RedirectURL string // Presigned storage url, content should be loaded from it when provided
+	ETag        string // Content is addressed by hash, so it's changed only with the hash
+}
+
+// Media is available for its owner, by valid signed url or when it's used by published note
+func (a *FileService) canAccessMedia(folder string, fileName string, access MediaAccess) (bool, error) {
+	if access.Signature != "" &&
+		verifyMediaSignature(a.config.MediaSigningKey, folder, fileName, access.Expires, access.Signature, time.Now()) {
+		return true, nil
+	}
+
+	if access.User != nil && access.User.ID.Hex() == folder {
+		return true, nil
+	}
+
+	published, err := a.noteRepository.IsFilePublished(folder, fileName)
+	if err != nil {
+		return false, fmt.Errorf("file service: can access media: %v", err)
+	}
+	return published, nil
+}
+
+// Return media file, image thumbnail of the closest configured width is returned when width is provided
+func (a *FileService) GetMedia(folder string, fileName string, width int, access MediaAccess) (*Media, error) {
+	if !isValidFileName(folder) || !isValidFileName(fileName) {
+		return nil, ErrInvalidFileName
+	}
+
+	allowed, err := a.canAccessMedia(folder, fileName, access)
+	if err != nil {
+		return nil, fmt.Errorf("file service: get media: %v", err)
+	}
+	if !allowed {
+		return nil, ErrMediaAccessDenied
+	}
+
+	userFile, err := a.fileRepository.GetFile(folder, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("file service: get media: %v", err)
+	}
+	if userFile == nil {
+		return nil, ErrMediaNotFound
+	}
+	file := mapUserFileToStoredFile(*userFile)
+
+	// Thumbnails are never rendered within the request, missing one is queued and the original is returned
+	thumbnailWidth := selectThumbnailWidth(a.config.ThumbnailWidths, width)
+	if thumbnailWidth > 0 && isThumbnailSupported(file) {
+		thumbnail, err := a.findThumbnail(file, thumbnailWidth)
+		if err != nil {
+			log.Error().Err(err).Msgf("file service: get media: could not find thumbnail, original file is returned")
+		}
+		if thumbnail != nil {
+			etag := fmt.Sprintf(`"%s-%d"`, file.Hash, thumbnailWidth)
+			return a.openMedia(thumbnailFolder, thumbnailName(file.Hash, thumbnailWidth), thumbnail, etag)
+		}
+		if err == nil {
+			a.enqueueThumbnails(file)
+		}
+	}
+
+	return a.openMedia(blobFolder, file.Hash, &file, `"`+file.Hash+`"`)
+}
+
+func (a *FileService) openMedia(folder string, name string, file *models.StoredFile, etag string) (*Media, error) {
+	presignedURL, err := a.fileStorage.Presign(folder, name, a.config.MediaURLExpiration, file.MimeType)
+	if err == nil {
+		return &Media{File: file, RedirectURL: presignedURL, ETag: etag}, nil
+	}
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return nil, fmt.Errorf("file service: open media: could not presign file: %v", err)
+	}
+
+	content, err := a.fileStorage.Get(folder, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file service: open media: could not get file: %v", err)
+	}
+
+	return &Media{File: file, Content: content, ETag: etag}, nil
+}
+
+// Create time limited url of user file, it could be embedded into public notes
+func (a *FileService) SignMediaURL(user *models.User, fileName string, expiresIn time.Duration) (*models.SignedURL, error) {
+	if !isValidFileName(fileName) {
+		return nil, ErrInvalidFileName
+	}
+
+	userID := user.ID.Hex()
+	file, err := a.fileRepository.GetFile(userID, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("file service: sign media url: %v", err)
+	}
+	if file == nil {
+		return nil, ErrMediaNotFound
+	}
+
+	expiresAt := time.Now().Add(expiresIn).Truncate(time.Second)
+	return &models.SignedURL{
+		URL:       signMediaURL(a.config.MediaSigningKey, a.config.MediaURL(), userID, fileName, expiresAt),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func getMimeType(fileName string) string {
+	mimeType := mime.TypeByExtension(filepath.Ext(fileName))
+	if mimeType == "" {
+		return "application/octet-stream"
 	}
+	return mimeType
+}
+
+func mapUserFileToStoredFile(file models.UserFile) models.StoredFile {
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = getMimeType(file.Name)
+	}
+	return models.StoredFile{
+		Name:      file.Name,
+		Hash:      file.Hash,
+		Size:      file.Size,
+		MimeType:  mimeType,
+		UpdatedAt: file.UpdatedAt,
+	}
+}
+
+func isValidFileName(fileName string) bool {
+	return fileName != "" &&
+		fileName != "." &&
+		fileName != ".." &&
+		!strings.ContainsAny(fileName, "/\\")
+}
 
+func (a *FileService) GetFiles(userID string) ([]models.StoredFile, error) {
+	userFiles, err := a.fileRepository.GetFiles(userID)
+	if err != nil {
+		return nil, fmt.Errorf("file service: get files: %v", err)
+	}
+
+	fileNotes, err := a.noteRepository.GetFileReferences(userID)
+	if err != nil {
+		return nil, fmt.Errorf("file service: get files: could not get file references: %v", err)
+	}
+
+	files := make([]models.StoredFile, len(userFiles))
+	for i, userFile := range userFiles {
+		files[i] = mapUserFileToStoredFile(userFile)
+		files[i].Notes = fileNotes[files[i].Name]
+		if files[i].Notes == nil {
+			files[i].Notes = []string{}
+		}
+	}
+	return files, nil
+}
+
+func (a *FileService) DeleteFile(user *models.User, fileName string) error {
+	if !isValidFileName(fileName) {
+		return ErrInvalidFileName
+	}
+
+	userID := user.ID.Hex()
+	deletedFile, err := a.fileRepository.DeleteFile(userID, fileName)
+	if err != nil {
+		return fmt.Errorf("file service: delete file: %v", err)
+	}
+	if deletedFile == nil {
+		return nil
+	}
+	a.releaseBlob(deletedFile.Hash)
+
+	go a.noteService.CalculateUserSpace(userID)
+	publishEvent(a.events, models.EventFileDeleted, userID, []string{fileName})
+	return nil
+}
+
+// Remove all files and unfinished uploads of the user, blobs are released
+// so the content and thumbnails are deleted when no other user references them
+func (a *FileService) DeleteUserFiles(userID string) error {
+	files, err := a.fileRepository.GetFiles(userID)
+	if err != nil {
+		return fmt.Errorf("file service: delete user files: %v", err)
+	}
+	for _, file := range files {
+		deletedFile, err := a.fileRepository.DeleteFile(userID, file.Name)
+		if err != nil {
+			return fmt.Errorf("file service: delete user files: %v", err)
+		}
+		if deletedFile != nil {
+			a.releaseBlob(deletedFile.Hash)
+		}
+	}
+
+	uploads, err := a.fileRepository.GetUserUploads(userID)
+	if err != nil {
+		return fmt.Errorf("file service: delete user files: %v", err)
+	}
+	for _, upload := range uploads {
+		a.removeUpload(upload.ID)
+	}
 	return nil
 }
+
+// Return names of files which are not used and were uploaded before the deadline
+func findOrphanFiles(files []models.StoredFile, usedFiles map[string]bool, uploadedBefore time.Time) []string {
+	orphanFiles := []string{}
+	for _, file := range files {
+		if usedFiles[file.Name] || !file.UpdatedAt.Before(uploadedBefore) {
+			continue
+		}
+		orphanFiles = append(orphanFiles, file.Name)
+	}
+	return orphanFiles
+}
+
+// Remove user files which are not used by notes nor by note revisions
+func (a *FileService) RemoveOrphanFiles(userID string) ([]string, error) {
+	files, err := a.GetFiles(userID)
+	if err != nil {
+		return nil, fmt.Errorf("file service: remove orphan files: %v", err)
+	}
+
+	fileNotes, err := a.noteRepository.GetFileReferences(userID)
+	if err != nil {
+		return nil, fmt.Errorf("file service: remove orphan files: could not get file references: %v", err)
+	}
+
+	revisionFiles, err := a.noteRepository.GetRevisionFiles(userID)
+	if err != nil {
+		return nil, fmt.Errorf("file service: remove orphan files: could not get revision files: %v", err)
+	}
+
+	usedFiles := map[string]bool{}
+	for fileName := range fileNotes {
+		usedFiles[fileName] = true
+	}
+	for _, fileName := range revisionFiles {
+		usedFiles[fileName] = true
+	}
+
+	orphanFiles := findOrphanFiles(files, usedFiles, time.Now().Add(-a.config.FilesGCGracePeriod))
+	removedFiles := []string{}
+	for _, fileName := range orphanFiles {
+		deletedFile, err := a.fileRepository.DeleteFile(userID, fileName)
+		if err != nil {
+			log.Error().Err(err).Msgf("file service: remove orphan files: could not delete file %s", fileName)
+			continue
+		}
+		if deletedFile == nil {
+			continue
+		}
+		a.releaseBlob(deletedFile.Hash)
+		removedFiles = append(removedFiles, fileName)
+	}
+
+	if len(removedFiles) == 0 {
+		return removedFiles, nil
+	}
+
+	publishEvent(a.events, models.EventFileDeleted, userID, removedFiles)
+	err = a.noteService.CalculateUserSpace(userID)
+	if err != nil {
+		return removedFiles, fmt.Errorf("file service: remove orphan files: %v", err)
+	}
+	return removedFiles, nil
+}
+
+func (a *FileService) removeAllOrphanFiles() {
+	userIDs, err := a.fileRepository.GetFileOwners()
+	if err != nil {
+		log.Error().Err(err).Msg("file service: remove all orphan files: could not get file owners")
+		return
+	}
+
+	for _, userID := range userIDs {
+		removedFiles, err := a.RemoveOrphanFiles(userID)
+		if err != nil {
+			log.Error().Err(err).Msgf("file service: remove all orphan files: user %s", userID)
+		}
+		if len(removedFiles) > 0 {
+			log.Info().Msgf("file service: remove all orphan files: removed %d files of user %s", len(removedFiles), userID)
+		}
+	}
+}
+
+func (a *FileService) RunScheduler() {
+	if a.queue != nil {
+		return
+	}
+
+	a.queue = cron.New()
+	_, err := a.queue.AddFunc(a.config.FilesGCSchedule, a.removeAllOrphanFiles)
+	if err != nil {
+		log.Error().Msgf("file service: run scheduler: invalid files gc schedule %q: %s", a.config.FilesGCSchedule, err)
+	}
+	_, err = a.queue.AddFunc("@hourly", a.removeExpiredUploads)
+	if err != nil {
+		log.Error().Msgf("file service: run scheduler: %s", err)
+	}
+
+	a.queue.Start()
+}
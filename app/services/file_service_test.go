@@ -0,0 +1,77 @@
+package services
+
+import (
+	"orgnote/app/configs"
+	"orgnote/app/models"
+	"orgnote/app/repositories"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFindOrphanFiles(t *testing.T) {
+	now := time.Date(2023, 10, 5, 12, 0, 0, 0, time.UTC)
+	files := []models.StoredFile{
+		{Name: "used.png", UpdatedAt: now.Add(-48 * time.Hour)},
+		{Name: "orphan.png", UpdatedAt: now.Add(-48 * time.Hour)},
+		{Name: "fresh.png", UpdatedAt: now.Add(-time.Hour)},
+	}
+
+	orphanFiles := findOrphanFiles(files, map[string]bool{"used.png": true}, now.Add(-24*time.Hour))
+
+	assert.Equal(t, []string{"orphan.png"}, orphanFiles)
+}
+
+func TestIsValidFileName(t *testing.T) {
+	assert.True(t, isValidFileName("image.png"))
+	assert.False(t, isValidFileName(""))
+	assert.False(t, isValidFileName(".."))
+	assert.False(t, isValidFileName("../image.png"))
+	assert.False(t, isValidFileName("dir\\image.png"))
+}
+
+func TestDeleteUserFiles(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("releases blobs and removes uploads", func(mt *mtest.T) {
+		storage := &memoryFileStorage{files: map[string]string{
+			"blobs/h1":                               "content",
+			"thumbnails/" + thumbnailName("h1", 320): "thumbnail",
+			"uploads/c1":                             "chunk",
+		}}
+		fileService := &FileService{
+			fileStorage:    storage,
+			fileRepository: repositories.NewFileRepository(mt.DB),
+			config:         configs.Config{ThumbnailWidths: []int{320}},
+		}
+		file := bson.D{{Key: "userId", Value: "u1"}, {Key: "name", Value: "a.png"}, {Key: "hash", Value: "h1"}}
+		uploadID := primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.files", mtest.FirstBatch, file),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: file}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: "h1"}, {Key: "refs", Value: 0}}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateCursorResponse(0, "test.uploads", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: uploadID},
+				{Key: "userId", Value: "u1"},
+				{Key: "chunks", Value: bson.A{"c1"}},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{Key: "_id", Value: uploadID},
+				{Key: "chunks", Value: bson.A{"c1"}},
+			}}),
+		)
+
+		err := fileService.DeleteUserFiles("u1")
+
+		require.NoError(t, err)
+		assert.Empty(t, storage.files)
+	})
+}
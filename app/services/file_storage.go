@@ -0,0 +1,19 @@
+package services
+
+import (
+	"io"
+	"orgnote/app/models"
+	"time"
+)
+
+// Storage driver for uploaded media. Missing files are reported by errors wrapping fs.ErrNotExist
+type FileStorage interface {
+	Put(folder string, fileName string, file io.Reader, size int64) error
+	Get(folder string, fileName string) (io.ReadCloser, error)
+	Stat(folder string, fileName string) (*models.StoredFile, error)
+	Delete(folder string, fileName string) error
+	List(folder string) ([]models.StoredFile, error)
+	ListFolders() ([]string, error)
+	// Return temporary direct url, content type overrides the stored one when provided
+	Presign(folder string, fileName string, expires time.Duration, contentType string) (string, error)
+}
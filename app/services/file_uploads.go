@@ -0,0 +1,350 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"orgnote/app/models"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Folder of received chunks of unfinished resumable uploads
+const uploadFolder = "uploads"
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadTooLarge       = errors.New("upload is too large")
+	ErrUploadLengthExceeded = errors.New("chunk exceeds upload length")
+	ErrNoSpaceLeft          = errors.New("no space left")
+)
+
+// Users without space limit are not restricted
+func hasSpaceForUpload(user *models.User, reservedSpace int64, length int64) bool {
+	if user.SpaceLimit <= 0 {
+		return true
+	}
+	return user.UsedSpace+reservedSpace+length <= user.SpaceLimit
+}
+
+// Restore SHA-256 state of previously received chunks, so content is hashed while it's streamed
+func restoreUploadHash(state []byte) (hash.Hash, error) {
+	hasher := sha256.New()
+	if len(state) == 0 {
+		return hasher, nil
+	}
+	err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	if err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+func saveUploadHash(hasher hash.Hash) ([]byte, error) {
+	return hasher.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// Sequential reader of upload chunks, next chunk is opened when previous one is read
+type uploadChunksReader struct {
+	fileStorage FileStorage
+	chunks      []string
+	current     io.ReadCloser
+}
+
+func (r *uploadChunksReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			chunk, err := r.fileStorage.Get(uploadFolder, r.chunks[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current = chunk
+			r.chunks = r.chunks[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *uploadChunksReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
+
+// Read exactly remaining bytes, request body which ends earlier is reported as unexpected EOF
+// so a truncated chunk is never stored
+type exactSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *exactSizeReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		return n, nil
+	}
+	return n, err
+}
+
+func (a *FileService) checkUploadSpace(user *models.User, length int64) error {
+	reservedSpace, err := a.fileRepository.GetReservedUploadSpace(user.ID.Hex())
+	if err != nil {
+		return fmt.Errorf("file service: check upload space: %v", err)
+	}
+	if !hasSpaceForUpload(user, reservedSpace, length) {
+		return ErrNoSpaceLeft
+	}
+	return nil
+}
+
+func (a *FileService) CreateUpload(user *models.User, fileName string, length int64) (*models.FileUpload, error) {
+	fileName = sanitizeFileName(fileName)
+	if fileName == "" {
+		return nil, ErrInvalidFileName
+	}
+	if length < 0 || length > a.config.UploadMaxSize {
+		return nil, ErrUploadTooLarge
+	}
+
+	err := a.checkUploadSpace(user, length)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload, err := a.fileRepository.CreateUpload(models.FileUpload{
+		UserID:    user.ID.Hex(),
+		FileName:  fileName,
+		Length:    length,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.config.UploadExpiration),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("file service: create upload: %v", err)
+	}
+
+	if upload.Length == 0 {
+		err = a.finishUpload(user, upload)
+		if err != nil {
+			return nil, fmt.Errorf("file service: create upload: %v", err)
+		}
+	}
+	return upload, nil
+}
+
+func (a *FileService) GetUpload(user *models.User, uploadID string) (*models.FileUpload, error) {
+	upload, err := a.fileRepository.GetUpload(user.ID.Hex(), uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("file service: get upload: %v", err)
+	}
+	if upload == nil {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// Stream chunk into storage, upload is finished and turned into user file when all content is received
+func (a *FileService) WriteUploadChunk(
+	user *models.User,
+	uploadID string,
+	offset int64,
+	content io.Reader,
+	size int64,
+) (*models.FileUpload, error) {
+	upload, err := a.GetUpload(user, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return nil, ErrUploadOffsetMismatch
+	}
+	if upload.Offset+size > upload.Length {
+		return nil, ErrUploadLengthExceeded
+	}
+
+	if size > 0 {
+		upload, err = a.appendUploadChunk(user, upload, content, size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if upload.Offset == upload.Length {
+		err = a.finishUpload(user, upload)
+		if err != nil {
+			return nil, fmt.Errorf("file service: write upload chunk: %v", err)
+		}
+	}
+	return upload, nil
+}
+
+func (a *FileService) appendUploadChunk(
+	user *models.User,
+	upload *models.FileUpload,
+	content io.Reader,
+	size int64,
+) (*models.FileUpload, error) {
+	err := a.checkUploadSpace(user, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	content = &exactSizeReader{r: content, remaining: size}
+	mimeType := upload.MimeType
+	if upload.Offset == 0 {
+		var sniffedType string
+		sniffedType, content, err = sniffReader(content)
+		if err != nil {
+			return nil, fmt.Errorf("file service: append upload chunk: could not read chunk: %v", err)
+		}
+		mimeType, err = resolveUploadMimeType(a.config.UploadAllowedTypes, upload.FileName, sniffedType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hasher, err := restoreUploadHash(upload.HashState)
+	if err != nil {
+		return nil, fmt.Errorf("file service: append upload chunk: could not restore hash: %v", err)
+	}
+
+	chunk := upload.ID.Hex() + "-" + primitive.NewObjectID().Hex()
+	err = a.fileStorage.Put(uploadFolder, chunk, io.TeeReader(content, hasher), size)
+	if err != nil {
+		return nil, fmt.Errorf("file service: append upload chunk: could not store chunk: %v", err)
+	}
+
+	hashState, err := saveUploadHash(hasher)
+	if err != nil {
+		a.deleteUploadChunks([]string{chunk})
+		return nil, fmt.Errorf("file service: append upload chunk: could not save hash: %v", err)
+	}
+
+	updatedUpload, err := a.fileRepository.AppendUploadChunk(
+		upload.ID,
+		upload.Offset,
+		upload.Offset+size,
+		chunk,
+		mimeType,
+		hashState,
+		time.Now().Add(a.config.UploadExpiration),
+	)
+	if err != nil || updatedUpload == nil {
+		a.deleteUploadChunks([]string{chunk})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file service: append upload chunk: %v", err)
+	}
+	if updatedUpload == nil {
+		return nil, ErrUploadOffsetMismatch
+	}
+	return updatedUpload, nil
+}
+
+func (a *FileService) finishUpload(user *models.User, upload *models.FileUpload) error {
+	hasher, err := restoreUploadHash(upload.HashState)
+	if err != nil {
+		return fmt.Errorf("file service: finish upload: could not restore hash: %v", err)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	content := &uploadChunksReader{fileStorage: a.fileStorage, chunks: upload.Chunks}
+	err = a.storeBlob(hash, upload.Length, content)
+	content.Close()
+	if err != nil {
+		return fmt.Errorf("file service: finish upload: %v", err)
+	}
+
+	mimeType := upload.MimeType
+	if mimeType == "" {
+		mimeType = getMimeType(upload.FileName)
+	}
+
+	userID := user.ID.Hex()
+	err = a.attachBlob(userID, upload.FileName, mimeType, hash, upload.Length)
+	if err != nil {
+		return fmt.Errorf("file service: finish upload: %v", err)
+	}
+
+	a.removeUpload(upload.ID)
+	go a.noteService.CalculateUserSpace(userID)
+	publishEvent(a.events, models.EventFileUploaded, userID, []string{upload.FileName})
+	return nil
+}
+
+func (a *FileService) DeleteUpload(user *models.User, uploadID string) error {
+	upload, err := a.GetUpload(user, uploadID)
+	if err != nil {
+		return err
+	}
+	a.removeUpload(upload.ID)
+	return nil
+}
+
+func (a *FileService) removeUpload(uploadID primitive.ObjectID) {
+	upload, err := a.fileRepository.DeleteUpload(uploadID)
+	if err != nil {
+		log.Error().Err(err).Msgf("file service: remove upload: could not delete upload %s", uploadID.Hex())
+		return
+	}
+	if upload != nil {
+		a.deleteUploadChunks(upload.Chunks)
+	}
+}
+
+func (a *FileService) deleteUploadChunks(chunks []string) {
+	for _, chunk := range chunks {
+		err := a.fileStorage.Delete(uploadFolder, chunk)
+		if err != nil {
+			log.Error().Err(err).Msgf("file service: delete upload chunks: could not delete chunk %s", chunk)
+		}
+	}
+}
+
+// Remove abandoned uploads which didn't receive new chunks during expiration period
+func (a *FileService) removeExpiredUploads() {
+	uploads, err := a.fileRepository.GetExpiredUploads(time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("file service: remove expired uploads: could not get expired uploads")
+		return
+	}
+
+	for _, upload := range uploads {
+		a.removeUpload(upload.ID)
+	}
+	if len(uploads) > 0 {
+		log.Info().Msgf("file service: remove expired uploads: removed %d uploads", len(uploads))
+	}
+}
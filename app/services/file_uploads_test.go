@@ -0,0 +1,122 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"orgnote/app/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryFileStorage struct {
+	files map[string]string
+}
+
+func (m *memoryFileStorage) Put(folder string, fileName string, file io.Reader, size int64) error {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	m.files[folder+"/"+fileName] = string(content)
+	return nil
+}
+
+func (m *memoryFileStorage) Get(folder string, fileName string) (io.ReadCloser, error) {
+	content, ok := m.files[folder+"/"+fileName]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+func (m *memoryFileStorage) Stat(folder string, fileName string) (*models.StoredFile, error) {
+	content, ok := m.files[folder+"/"+fileName]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return &models.StoredFile{Name: fileName, Size: int64(len(content))}, nil
+}
+
+func (m *memoryFileStorage) Delete(folder string, fileName string) error {
+	delete(m.files, folder+"/"+fileName)
+	return nil
+}
+
+func (m *memoryFileStorage) List(folder string) ([]models.StoredFile, error) {
+	return nil, nil
+}
+
+func (m *memoryFileStorage) ListFolders() ([]string, error) {
+	return nil, nil
+}
+
+func (m *memoryFileStorage) Presign(folder string, fileName string, expires time.Duration, contentType string) (string, error) {
+	return "", nil
+}
+
+func TestHasSpaceForUpload(t *testing.T) {
+	user := &models.User{SpaceLimit: 100, UsedSpace: 40}
+
+	assert.True(t, hasSpaceForUpload(user, 20, 40))
+	assert.False(t, hasSpaceForUpload(user, 20, 41))
+	assert.True(t, hasSpaceForUpload(&models.User{UsedSpace: 1000}, 1000, 1000))
+}
+
+func TestUploadHashStateIsResumable(t *testing.T) {
+	hasher, err := restoreUploadHash(nil)
+	require.NoError(t, err)
+	hasher.Write([]byte("first chunk,"))
+
+	state, err := saveUploadHash(hasher)
+	require.NoError(t, err)
+
+	restoredHasher, err := restoreUploadHash(state)
+	require.NoError(t, err)
+	restoredHasher.Write([]byte("second chunk"))
+
+	expectedHash := sha256.Sum256([]byte("first chunk,second chunk"))
+	assert.Equal(t, hex.EncodeToString(expectedHash[:]), hex.EncodeToString(restoredHasher.Sum(nil)))
+}
+
+func TestUploadChunksReader(t *testing.T) {
+	storage := &memoryFileStorage{files: map[string]string{
+		"uploads/1": "first ",
+		"uploads/2": "",
+		"uploads/3": "second",
+	}}
+
+	reader := &uploadChunksReader{fileStorage: storage, chunks: []string{"1", "2", "3"}}
+	content, err := io.ReadAll(reader)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "first second", string(content))
+	assert.NoError(t, reader.Close())
+}
+
+func TestUploadChunksReaderMissingChunk(t *testing.T) {
+	storage := &memoryFileStorage{files: map[string]string{}}
+
+	reader := &uploadChunksReader{fileStorage: storage, chunks: []string{"1"}}
+	_, err := io.ReadAll(reader)
+
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestExactSizeReader(t *testing.T) {
+	content, err := io.ReadAll(&exactSizeReader{r: strings.NewReader("chunk and more"), remaining: 5})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "chunk", string(content))
+}
+
+func TestExactSizeReaderTruncatedContent(t *testing.T) {
+	_, err := io.ReadAll(&exactSizeReader{r: strings.NewReader("chu"), remaining: 5})
+
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
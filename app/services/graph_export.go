@@ -0,0 +1,289 @@
+package services
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"orgnote/app/models"
+	"strings"
+)
+
+type GraphExportFormat string
+
+const (
+	GraphExportDOT     GraphExportFormat = "dot"
+	GraphExportGraphML GraphExportFormat = "graphml"
+	GraphExportJSON    GraphExportFormat = "json"
+)
+
+var ErrUnknownGraphExportFormat = errors.New("unknown graph export format")
+
+var dotEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\"", "\\\"",
+	"\n", "\\n",
+	"\r", "",
+)
+
+// Keep the first write error, so serializers don't have to check every write
+type graphWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (g *graphWriter) printf(format string, args ...any) {
+	if g.err != nil {
+		return
+	}
+	_, g.err = fmt.Fprintf(g.w, format, args...)
+}
+
+func (g *graphWriter) escapeXML(text string) string {
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(text))
+	return builder.String()
+}
+
+func (g *graphWriter) marshalJSON(value any) string {
+	if g.err != nil {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		g.err = err
+	}
+	return string(data)
+}
+
+type graphEncoder interface {
+	begin()
+	node(node models.GraphNoteNode)
+	link(link models.GraphNoteLink) // Called after all nodes were written
+	end()
+}
+
+func newGraphEncoder(g *graphWriter, format GraphExportFormat) (graphEncoder, error) {
+	switch format {
+	case GraphExportDOT:
+		return &dotGraphEncoder{g}, nil
+	case GraphExportGraphML:
+		return &graphMLEncoder{graphWriter: g}, nil
+	case GraphExportJSON:
+		return &jsonGraphEncoder{graphWriter: g}, nil
+	}
+	return nil, ErrUnknownGraphExportFormat
+}
+
+// Graphviz has no node weight, so weight and tags are written as custom attributes
+// which are kept by Graphviz tools but don't affect the layout
+type dotGraphEncoder struct {
+	*graphWriter
+}
+
+func (e *dotGraphEncoder) begin() {
+	e.printf("digraph notes {\n")
+	e.printf("  // Custom node attributes: tags are file tags of the note, note_weight is the number of its links\n")
+}
+
+func (e *dotGraphEncoder) node(node models.GraphNoteNode) {
+	e.printf(
+		"  \"%s\" [label=\"%s\", note_weight=%d, tags=\"%s\"];\n",
+		dotEscaper.Replace(node.ExternalID),
+		dotEscaper.Replace(node.Title),
+		node.Weight,
+		dotEscaper.Replace(strings.Join(node.Tags, ",")),
+	)
+}
+
+func (e *dotGraphEncoder) link(link models.GraphNoteLink) {
+	e.printf("  \"%s\" -> \"%s\";\n", dotEscaper.Replace(link.Source), dotEscaper.Replace(link.Target))
+}
+
+func (e *dotGraphEncoder) end() {
+	e.printf("}\n")
+}
+
+type graphMLEncoder struct {
+	*graphWriter
+	links int
+}
+
+func (e *graphMLEncoder) begin() {
+	e.printf("%s", xml.Header)
+	e.printf("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	e.printf("  <key id=\"label\" for=\"node\" attr.name=\"label\" attr.type=\"string\"/>\n")
+	e.printf("  <key id=\"tags\" for=\"node\" attr.name=\"tags\" attr.type=\"string\"/>\n")
+	e.printf("  <key id=\"weight\" for=\"node\" attr.name=\"weight\" attr.type=\"int\"/>\n")
+	e.printf("  <graph id=\"notes\" edgedefault=\"directed\">\n")
+}
+
+func (e *graphMLEncoder) node(node models.GraphNoteNode) {
+	e.printf("    <node id=\"%s\">\n", e.escapeXML(node.ExternalID))
+	e.printf("      <data key=\"label\">%s</data>\n", e.escapeXML(node.Title))
+	e.printf("      <data key=\"tags\">%s</data>\n", e.escapeXML(strings.Join(node.Tags, ",")))
+	e.printf("      <data key=\"weight\">%d</data>\n", node.Weight)
+	e.printf("    </node>\n")
+}
+
+func (e *graphMLEncoder) link(link models.GraphNoteLink) {
+	e.printf(
+		"    <edge id=\"e%d\" source=\"%s\" target=\"%s\"/>\n",
+		e.links,
+		e.escapeXML(link.Source),
+		e.escapeXML(link.Target),
+	)
+	e.links++
+}
+
+func (e *graphMLEncoder) end() {
+	e.printf("  </graph>\n")
+	e.printf("</graphml>\n")
+}
+
+type jsonGraphNodeMetadata struct {
+	Tags   []string `json:"tags"`
+	Weight int      `json:"weight"`
+}
+
+type jsonGraphNode struct {
+	Label    string                `json:"label"`
+	Metadata jsonGraphNodeMetadata `json:"metadata"`
+}
+
+// JSON Graph Format (https://jsongraphformat.info), nodes and edges are encoded one by one
+type jsonGraphEncoder struct {
+	*graphWriter
+	nodes int
+	links int
+}
+
+func (e *jsonGraphEncoder) begin() {
+	e.printf("{\"graph\":{\"directed\":true,\"nodes\":{")
+}
+
+func (e *jsonGraphEncoder) node(node models.GraphNoteNode) {
+	if e.nodes > 0 {
+		e.printf(",")
+	}
+	e.nodes++
+
+	tags := node.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	e.printf("%s:%s", e.marshalJSON(node.ExternalID), e.marshalJSON(jsonGraphNode{
+		Label:    node.Title,
+		Metadata: jsonGraphNodeMetadata{Tags: tags, Weight: node.Weight},
+	}))
+}
+
+func (e *jsonGraphEncoder) link(link models.GraphNoteLink) {
+	if e.links == 0 {
+		e.printf("},\"edges\":[")
+	} else {
+		e.printf(",")
+	}
+	e.links++
+	e.printf("%s", e.marshalJSON(link))
+}
+
+func (e *jsonGraphEncoder) end() {
+	if e.links == 0 {
+		e.printf("},\"edges\":[")
+	}
+	e.printf("]}}\n")
+}
+
+// Stream note graph of the user into the writer in the requested format.
+// Notes are read with a cursor several times, only ids and weights of nodes are kept in memory
+func (n *NoteService) ExportNoteGraph(w io.Writer, userID string, format GraphExportFormat) error {
+	g := &graphWriter{w: w}
+	encoder, err := newGraphEncoder(g, format)
+	if err != nil {
+		return err
+	}
+
+	weights := map[string]int{}
+	err = n.noteRepository.ForEachGraphNote(userID, func(note models.Note) error {
+		weights[note.ExternalID] = 0
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("note service: export note graph: could not read nodes: %v", err)
+	}
+
+	err = n.forEachGraphLink(userID, weights, func(link models.GraphNoteLink) error {
+		weights[link.Source]++
+		weights[link.Target]++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("note service: export note graph: could not count links: %v", err)
+	}
+
+	// Notes may change between reads, links are written only between written nodes
+	writtenNodes := map[string]int{}
+	encoder.begin()
+	err = n.noteRepository.ForEachGraphNote(userID, func(note models.Note) error {
+		weight, ok := weights[note.ExternalID]
+		if _, written := writtenNodes[note.ExternalID]; written || !ok {
+			return nil
+		}
+		writtenNodes[note.ExternalID] = weight
+		encoder.node(models.GraphNoteNode{
+			ExternalID: note.ExternalID,
+			Title:      getGraphNodeTitle(note),
+			Tags:       note.Meta.FileTags,
+			Weight:     weight,
+		})
+		return g.err
+	})
+	if err != nil {
+		return fmt.Errorf("note service: export note graph: could not write nodes: %v", err)
+	}
+
+	err = n.forEachGraphLink(userID, writtenNodes, func(link models.GraphNoteLink) error {
+		encoder.link(link)
+		return g.err
+	})
+	if err != nil {
+		return fmt.Errorf("note service: export note graph: could not write links: %v", err)
+	}
+	encoder.end()
+
+	if g.err != nil {
+		return fmt.Errorf("note service: export note graph: %v", g.err)
+	}
+	return nil
+}
+
+// Call fn for each link between nodes in the same order as the note graph is built
+func (n *NoteService) forEachGraphLink(
+	userID string,
+	nodes map[string]int,
+	fn func(link models.GraphNoteLink) error,
+) error {
+	sources := map[string]bool{}
+	return n.noteRepository.ForEachGraphNote(userID, func(note models.Note) error {
+		_, isNode := nodes[note.ExternalID]
+		if sources[note.ExternalID] || !isNode {
+			return nil
+		}
+		sources[note.ExternalID] = true
+
+		previousTarget := ""
+		for _, target := range getGraphNoteTargets(note) {
+			_, exists := nodes[target]
+			if !exists || target == note.ExternalID || target == previousTarget {
+				continue
+			}
+			previousTarget = target
+			if err := fn(models.GraphNoteLink{Source: note.ExternalID, Target: target}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
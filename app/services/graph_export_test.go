@@ -0,0 +1,95 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var exportedGraph = models.NoteGraph{
+	Nodes: []models.GraphNoteNode{
+		{ExternalID: "a", Title: "Say \"hi\" <now>", Tags: []string{"one", "two"}, Weight: 1},
+		{ExternalID: "b", Title: "B", Weight: 1},
+	},
+	Links: []models.GraphNoteLink{{Source: "a", Target: "b"}},
+}
+
+func encodeGraph(graph models.NoteGraph, format GraphExportFormat) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	g := &graphWriter{w: buf}
+	encoder, err := newGraphEncoder(g, format)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder.begin()
+	for _, node := range graph.Nodes {
+		encoder.node(node)
+	}
+	for _, link := range graph.Links {
+		encoder.link(link)
+	}
+	encoder.end()
+	return buf, g.err
+}
+
+func TestEncodeNoteGraphDOT(t *testing.T) {
+	buf, err := encodeGraph(exportedGraph, GraphExportDOT)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `digraph notes {
+  // Custom node attributes: tags are file tags of the note, note_weight is the number of its links
+  "a" [label="Say \"hi\" <now>", note_weight=1, tags="one,two"];
+  "b" [label="B", note_weight=1, tags=""];
+  "a" -> "b";
+}
+`, buf.String())
+}
+
+func TestEncodeNoteGraphML(t *testing.T) {
+	buf, err := encodeGraph(exportedGraph, GraphExportGraphML)
+
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `<data key="label">Say &#34;hi&#34; &lt;now&gt;</data>`)
+	assert.Contains(t, buf.String(), `<edge id="e0" source="a" target="b"/>`)
+
+	var parsed struct{}
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &parsed))
+}
+
+func TestEncodeNoteGraphJSON(t *testing.T) {
+	buf, err := encodeGraph(exportedGraph, GraphExportJSON)
+
+	assert.NoError(t, err)
+
+	var parsed struct {
+		Graph struct {
+			Directed bool                     `json:"directed"`
+			Nodes    map[string]jsonGraphNode `json:"nodes"`
+			Edges    []models.GraphNoteLink   `json:"edges"`
+		} `json:"graph"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &parsed))
+	assert.True(t, parsed.Graph.Directed)
+	assert.Equal(t, "Say \"hi\" <now>", parsed.Graph.Nodes["a"].Label)
+	assert.Equal(t, []string{"one", "two"}, parsed.Graph.Nodes["a"].Metadata.Tags)
+	assert.Equal(t, []string{}, parsed.Graph.Nodes["b"].Metadata.Tags)
+	assert.Equal(t, exportedGraph.Links, parsed.Graph.Edges)
+}
+
+func TestEncodeEmptyNoteGraphJSON(t *testing.T) {
+	buf, err := encodeGraph(models.NoteGraph{}, GraphExportJSON)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"graph\":{\"directed\":true,\"nodes\":{},\"edges\":[]}}\n", buf.String())
+}
+
+func TestEncodeNoteGraphUnknownFormat(t *testing.T) {
+	_, err := encodeGraph(exportedGraph, "svg")
+
+	assert.ErrorIs(t, err, ErrUnknownGraphExportFormat)
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"orgnote/app/models"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icalLineLimit      = 75
+	icalDateFormat     = "20060102"
+	icalDateTimeFormat = "20060102T150405"
+)
+
+var icalRepeaterFrequencies = map[string]string{
+	"h": "HOURLY",
+	"d": "DAILY",
+	"w": "WEEKLY",
+	"m": "MONTHLY",
+	"y": "YEARLY",
+}
+
+// Org priorities mapped to iCalendar high, medium and low priority
+var icalPriorities = map[string]string{
+	"A": "1",
+	"B": "5",
+	"C": "9",
+}
+
+var icalTextEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\;",
+	",", "\\,",
+	"\r\n", "\\n",
+	"\n", "\\n",
+)
+
+type icalWriter struct {
+	builder strings.Builder
+}
+
+// Write content line folded by 75 octets without breaking multibyte characters
+func (w *icalWriter) writeLine(line string) {
+	limit := icalLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.builder.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// Folded lines start with a space, that takes one octet
+		limit = icalLineLimit - 1
+	}
+	w.builder.WriteString(line + "\r\n")
+}
+
+func (w *icalWriter) writeTimestamp(name string, timestamp *models.AgendaTimestamp) {
+	// Org timestamps have no time zone, so they are rendered as floating time
+	if timestamp.HasTime {
+		w.writeLine(name + ":" + timestamp.Date.Format(icalDateTimeFormat))
+		return
+	}
+	w.writeLine(name + ";VALUE=DATE:" + timestamp.Date.Format(icalDateFormat))
+}
+
+func (w *icalWriter) writeRepeater(timestamp *models.AgendaTimestamp) {
+	if timestamp == nil || timestamp.Repeater == nil || timestamp.Repeater.Value <= 0 {
+		return
+	}
+	frequency, ok := icalRepeaterFrequencies[timestamp.Repeater.Unit]
+	if !ok {
+		return
+	}
+	w.writeLine(fmt.Sprintf("RRULE:FREQ=%s;INTERVAL=%d", frequency, timestamp.Repeater.Value))
+}
+
+func escapeICalText(text string) string {
+	return icalTextEscaper.Replace(text)
+}
+
+// Build stable uid, item ids are regenerated each time the note is saved
+func buildICalUID(item models.AgendaItem, kind string, seen map[string]int) string {
+	hash := sha1.Sum([]byte(item.NoteID + "\x00" + item.Title + "\x00" + kind))
+	uid := hex.EncodeToString(hash[:])
+	seen[uid]++
+	if seen[uid] > 1 {
+		uid = fmt.Sprintf("%s-%d", uid, seen[uid])
+	}
+	return uid + "@orgnote"
+}
+
+func (w *icalWriter) writeEvent(item models.AgendaItem, kind string, timestamp *models.AgendaTimestamp, stamp string, seen map[string]int) {
+	summary := item.Title
+	if kind == "deadline" {
+		summary = "Deadline: " + summary
+	}
+
+	w.writeLine("BEGIN:VEVENT")
+	w.writeLine("UID:" + buildICalUID(item, kind, seen))
+	w.writeLine("DTSTAMP:" + stamp)
+	w.writeTimestamp("DTSTART", timestamp)
+	w.writeRepeater(timestamp)
+	w.writeLine("SUMMARY:" + escapeICalText(summary))
+	if len(item.Tags) > 0 {
+		w.writeLine("CATEGORIES:" + escapeICalTags(item.Tags))
+	}
+	w.writeLine("END:VEVENT")
+}
+
+func (w *icalWriter) writeTodo(item models.AgendaItem, stamp string, seen map[string]int) {
+	w.writeLine("BEGIN:VTODO")
+	w.writeLine("UID:" + buildICalUID(item, "todo", seen))
+	w.writeLine("DTSTAMP:" + stamp)
+	if item.Scheduled != nil {
+		w.writeTimestamp("DTSTART", item.Scheduled)
+	}
+	if item.Deadline != nil {
+		w.writeTimestamp("DUE", item.Deadline)
+	}
+	if item.Scheduled != nil {
+		w.writeRepeater(item.Scheduled)
+	} else {
+		w.writeRepeater(item.Deadline)
+	}
+	w.writeLine("SUMMARY:" + escapeICalText(item.Title))
+	if len(item.Tags) > 0 {
+		w.writeLine("CATEGORIES:" + escapeICalTags(item.Tags))
+	}
+	if priority, ok := icalPriorities[item.Priority]; ok {
+		w.writeLine("PRIORITY:" + priority)
+	}
+	status := "NEEDS-ACTION"
+	if item.Done {
+		status = "COMPLETED"
+	}
+	w.writeLine("STATUS:" + status)
+	w.writeLine("END:VTODO")
+}
+
+func escapeICalTags(tags []string) string {
+	escapedTags := make([]string, len(tags))
+	for i, tag := range tags {
+		escapedTags[i] = escapeICalText(tag)
+	}
+	return strings.Join(escapedTags, ",")
+}
+
+// Render agenda items as iCalendar (RFC 5545).
+// Headlines with TODO keyword become VTODO, other planned headlines become VEVENT per timestamp
+func renderICalendar(items []models.AgendaItem, now time.Time) string {
+	w := &icalWriter{}
+	stamp := now.UTC().Format(icalDateTimeFormat) + "Z"
+	seen := map[string]int{}
+
+	w.writeLine("BEGIN:VCALENDAR")
+	w.writeLine("VERSION:2.0")
+	w.writeLine("PRODID:-//OrgNote//Agenda//EN")
+	w.writeLine("CALSCALE:GREGORIAN")
+	w.writeLine("X-WR-CALNAME:OrgNote")
+
+	for _, item := range items {
+		if item.Scheduled == nil && item.Deadline == nil {
+			continue
+		}
+		if item.Keyword != "" {
+			w.writeTodo(item, stamp, seen)
+			continue
+		}
+		if item.Scheduled != nil {
+			w.writeEvent(item, "scheduled", item.Scheduled, stamp, seen)
+		}
+		if item.Deadline != nil {
+			w.writeEvent(item, "deadline", item.Deadline, stamp, seen)
+		}
+	}
+
+	w.writeLine("END:VCALENDAR")
+	return w.builder.String()
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderICalendar(t *testing.T) {
+	items := []models.AgendaItem{
+		{
+			NoteID:   "note-id",
+			Title:    "Pay rent, bills",
+			Keyword:  "TODO",
+			Priority: "A",
+			Deadline: &models.AgendaTimestamp{
+				Date:     time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+				Repeater: &models.AgendaRepeater{Type: "+", Value: 1, Unit: "m"},
+			},
+		},
+		{
+			NoteID: "note-id",
+			Title:  "Meeting",
+			Tags:   []string{"work"},
+			Scheduled: &models.AgendaTimestamp{
+				Date:    time.Date(2023, 10, 5, 10, 30, 0, 0, time.UTC),
+				HasTime: true,
+			},
+		},
+	}
+
+	calendar := renderICalendar(items, time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.Contains(t, calendar, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n")
+	assert.Contains(t, calendar, "BEGIN:VTODO\r\n")
+	assert.Contains(t, calendar, "DTSTAMP:20231001T120000Z\r\n")
+	assert.Contains(t, calendar, "DUE;VALUE=DATE:20231001\r\n")
+	assert.Contains(t, calendar, "RRULE:FREQ=MONTHLY;INTERVAL=1\r\n")
+	assert.Contains(t, calendar, "SUMMARY:Pay rent\\, bills\r\n")
+	assert.Contains(t, calendar, "PRIORITY:1\r\nSTATUS:NEEDS-ACTION\r\nEND:VTODO\r\n")
+	assert.Contains(t, calendar, "BEGIN:VEVENT\r\n")
+	assert.Contains(t, calendar, "DTSTART:20231005T103000\r\n")
+	assert.Contains(t, calendar, "CATEGORIES:work\r\n")
+	assert.True(t, strings.HasSuffix(calendar, "END:VCALENDAR\r\n"))
+}
+
+func TestRenderICalendarStableUIDs(t *testing.T) {
+	item := models.AgendaItem{
+		NoteID:    "note-id",
+		Title:     "Meeting",
+		Scheduled: &models.AgendaTimestamp{Date: time.Date(2023, 10, 5, 0, 0, 0, 0, time.UTC)},
+	}
+
+	first := renderICalendar([]models.AgendaItem{item}, time.Now())
+	second := renderICalendar([]models.AgendaItem{item, item}, time.Now())
+
+	uid := first[strings.Index(first, "UID:"):strings.Index(first, "@orgnote")]
+	assert.Contains(t, second, uid+"@orgnote\r\n")
+	assert.Contains(t, second, uid+"-2@orgnote\r\n")
+}
+
+func TestICalWriterFoldsLongLines(t *testing.T) {
+	w := &icalWriter{}
+
+	w.writeLine("SUMMARY:" + strings.Repeat("ы", 50))
+
+	lines := strings.Split(strings.TrimSuffix(w.builder.String(), "\r\n"), "\r\n")
+	assert.Len(t, lines, 2)
+	assert.LessOrEqual(t, len(lines[0]), 75)
+	assert.True(t, strings.HasPrefix(lines[1], " "))
+	assert.Equal(t, "SUMMARY:"+strings.Repeat("ы", 50), lines[0]+lines[1][1:])
+}
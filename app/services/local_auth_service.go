@@ -0,0 +1,385 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"orgnote/app/configs"
+	"orgnote/app/models"
+	"orgnote/app/repositories"
+	"orgnote/app/tools"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrRegistrationClosed  = errors.New("registration is closed")
+	ErrInvalidInviteCode   = errors.New("invalid invite code")
+	ErrEmailAlreadyUsed    = errors.New("email is already used")
+	ErrInvalidEmail        = errors.New("invalid email")
+	ErrInvalidPassword     = errors.New("invalid password")
+	ErrInvalidCredentials  = errors.New("invalid email or password")
+	ErrEmailNotVerified    = errors.New("email is not verified")
+	ErrInvalidActionToken  = errors.New("invalid or expired token")
+	ErrMailerNotConfigured = errors.New("mailer is not configured")
+)
+
+const (
+	minPasswordLength = 8
+	// Long passwords make hashing slow
+	maxPasswordLength = 128
+
+	verifyEmailTokenLifetime   = 48 * time.Hour
+	resetPasswordTokenLifetime = time.Hour
+	inviteLifetime             = 7 * 24 * time.Hour
+)
+
+// Accounts registered by email and password, alternative to OAuth providers for self hosted instances
+type LocalAuthService struct {
+	userRepository *repositories.UserRepository
+	mailer         Mailer // Emails are not verified when mailer is not provided
+	config         configs.Config
+}
+
+func NewLocalAuthService(userRepository *repositories.UserRepository, mailer Mailer, config configs.Config) *LocalAuthService {
+	return &LocalAuthService{userRepository, mailer, config}
+}
+
+type RegisterParams struct {
+	Email      string
+	Username   string // Local part of the email is used when it's empty
+	Password   string
+	InviteCode string
+}
+
+func normalizeEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(address.Address), nil
+}
+
+func validatePassword(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < minPasswordLength || length > maxPasswordLength {
+		return fmt.Errorf("%w: length should be between %d and %d", ErrInvalidPassword, minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// Check password against dummy hash, so response time doesn't show whether user exists
+func verifyDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = tools.HashPassword("dummy password")
+	})
+	tools.VerifyPassword(password, dummyPasswordHash)
+}
+
+func (l *LocalAuthService) getLocalUser(email string) (*models.User, error) {
+	return l.userRepository.GetUser(&models.User{
+		Provider:   models.LocalProvider,
+		ExternalID: email,
+	})
+}
+
+// Invite code is required in invite mode, except registration of the first user of the instance.
+// Nil is returned when invite is not required, invite is consumed only after the user is created
+func (l *LocalAuthService) findInvite(inviteCode string) (*models.UserActionToken, error) {
+	if l.config.RegistrationMode != "invite" {
+		return nil, nil
+	}
+	usersCount, err := l.userRepository.CountUsers()
+	if err != nil {
+		return nil, err
+	}
+	if usersCount == 0 {
+		return nil, nil
+	}
+	if inviteCode == "" {
+		return nil, ErrInvalidInviteCode
+	}
+	invite, err := l.userRepository.FindActionToken(models.UserActionInvite, inviteCode)
+	if err != nil {
+		return nil, err
+	}
+	if invite == nil {
+		return nil, ErrInvalidInviteCode
+	}
+	return invite, nil
+}
+
+// Created user is removed when the invite was used by concurrent registration
+func (l *LocalAuthService) consumeInvite(invite *models.UserActionToken, user *models.User) error {
+	consumed, err := l.userRepository.DeleteActionToken(invite.ID)
+	if err == nil && consumed {
+		return nil
+	}
+
+	if deleteErr := l.userRepository.DeleteUser(user.ID.Hex()); deleteErr != nil {
+		log.Error().Err(deleteErr).Msgf("local auth service: consume invite: could not delete user %s", user.ID.Hex())
+	}
+	if err != nil {
+		return err
+	}
+	return ErrInvalidInviteCode
+}
+
+func (l *LocalAuthService) Register(params RegisterParams) (*models.LocalRegistration, error) {
+	if l.config.RegistrationMode == "closed" {
+		return nil, ErrRegistrationClosed
+	}
+	email, err := normalizeEmail(params.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePassword(params.Password); err != nil {
+		return nil, err
+	}
+	username := strings.TrimSpace(params.Username)
+	if username == "" {
+		username = strings.Split(email, "@")[0]
+	}
+
+	existingUser, err := l.getLocalUser(email)
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: register: get user: %v", err)
+	}
+	if existingUser != nil {
+		return nil, ErrEmailAlreadyUsed
+	}
+
+	invite, err := l.findInvite(params.InviteCode)
+	if errors.Is(err, ErrInvalidInviteCode) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: register: find invite: %v", err)
+	}
+
+	passwordHash, err := tools.HashPassword(params.Password)
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: register: hash password: %v", err)
+	}
+
+	createdUser, err := l.userRepository.Create(models.User{
+		Provider:      models.LocalProvider,
+		ExternalID:    email,
+		Email:         email,
+		Name:          username,
+		NickName:      username,
+		PasswordHash:  passwordHash,
+		EmailVerified: l.mailer == nil,
+		Notes:         []models.Note{},
+		APITokens:     []models.APIToken{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: register: create user: %v", err)
+	}
+
+	if invite != nil {
+		err = l.consumeInvite(invite, createdUser)
+		if errors.Is(err, ErrInvalidInviteCode) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("local auth service: register: consume invite: %v", err)
+		}
+	}
+
+	if !createdUser.EmailVerified {
+		// User could request verification email again
+		if err := l.sendVerificationEmail(createdUser); err != nil {
+			log.Error().Err(err).Msg("local auth service: register: send verification email")
+		}
+	}
+
+	return &models.LocalRegistration{
+		User:                      mapToUserPersonalInfo(createdUser),
+		EmailVerificationRequired: !createdUser.EmailVerified,
+	}, nil
+}
+
+// Start new session of the device, two factor challenge is returned when the second factor is required
+func (l *LocalAuthService) Login(email string, password string, params LoginParams) (*models.AuthSession, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	user, err := l.getLocalUser(email)
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: login: get user: %v", err)
+	}
+	if user == nil || user.PasswordHash == "" {
+		verifyDummyPassword(password)
+		return nil, ErrInvalidCredentials
+	}
+
+	valid, err := tools.VerifyPassword(password, user.PasswordHash)
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: login: verify password: %v", err)
+	}
+	if !valid {
+		return nil, ErrInvalidCredentials
+	}
+	if !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	session, err := startSession(l.userRepository, l.config, user, params)
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: login: %v", err)
+	}
+	return session, nil
+}
+
+func (l *LocalAuthService) VerifyEmail(token string) error {
+	actionToken, err := l.userRepository.ConsumeActionToken(models.UserActionVerifyEmail, token)
+	if err != nil {
+		return fmt.Errorf("local auth service: verify email: consume token: %v", err)
+	}
+	if actionToken == nil {
+		return ErrInvalidActionToken
+	}
+	err = l.userRepository.SetEmailVerified(actionToken.UserID)
+	if err != nil {
+		return fmt.Errorf("local auth service: verify email: %v", err)
+	}
+	return nil
+}
+
+// Nothing is sent when there is no unverified account with the email,
+// the response is the same so it doesn't show registered emails
+func (l *LocalAuthService) ResendVerificationEmail(email string) error {
+	if l.mailer == nil {
+		return ErrMailerNotConfigured
+	}
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	user, err := l.getLocalUser(email)
+	if err != nil {
+		return fmt.Errorf("local auth service: resend verification email: get user: %v", err)
+	}
+	if user == nil || user.EmailVerified {
+		return nil
+	}
+	if err := l.sendVerificationEmail(user); err != nil {
+		return fmt.Errorf("local auth service: resend verification email: %v", err)
+	}
+	return nil
+}
+
+func (l *LocalAuthService) RequestPasswordReset(email string) error {
+	if l.mailer == nil {
+		return ErrMailerNotConfigured
+	}
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	user, err := l.getLocalUser(email)
+	if err != nil {
+		return fmt.Errorf("local auth service: request password reset: get user: %v", err)
+	}
+	if user == nil {
+		return nil
+	}
+
+	// Only the last requested link is valid
+	err = l.userRepository.DeleteActionTokens(user.ID, models.UserActionResetPassword)
+	if err != nil {
+		return fmt.Errorf("local auth service: request password reset: %v", err)
+	}
+	token, err := l.userRepository.CreateActionToken(user.ID, models.UserActionResetPassword, time.Now().Add(resetPasswordTokenLifetime))
+	if err != nil {
+		return fmt.Errorf("local auth service: request password reset: %v", err)
+	}
+	body := "Somebody requested password reset of your Org Note account.\n\n" +
+		"Open the link to set new password, it's valid for 1 hour:\n" +
+		l.clientURL("/auth/reset-password", token) + "\n\n" +
+		"Ignore this email if you didn't request it."
+	err = l.mailer.Send(user.Email, "Org Note password reset", body)
+	if err != nil {
+		return fmt.Errorf("local auth service: request password reset: send email: %v", err)
+	}
+	return nil
+}
+
+// Set new password by token from email, existing session is closed
+func (l *LocalAuthService) ResetPassword(token string, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	actionToken, err := l.userRepository.ConsumeActionToken(models.UserActionResetPassword, token)
+	if err != nil {
+		return fmt.Errorf("local auth service: reset password: consume token: %v", err)
+	}
+	if actionToken == nil {
+		return ErrInvalidActionToken
+	}
+
+	passwordHash, err := tools.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("local auth service: reset password: hash password: %v", err)
+	}
+	err = l.userRepository.SetPassword(actionToken.UserID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("local auth service: reset password: %v", err)
+	}
+	// Link from email proves that user owns the address
+	err = l.userRepository.SetEmailVerified(actionToken.UserID)
+	if err != nil {
+		return fmt.Errorf("local auth service: reset password: %v", err)
+	}
+	return nil
+}
+
+func (l *LocalAuthService) CreateInvite(user *models.User) (*models.Invite, error) {
+	if l.config.RegistrationMode == "closed" {
+		return nil, ErrRegistrationClosed
+	}
+	expiresAt := time.Now().Add(inviteLifetime)
+	code, err := l.userRepository.CreateActionToken(user.ID, models.UserActionInvite, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("local auth service: create invite: %v", err)
+	}
+	return &models.Invite{Code: code, ExpiresAt: expiresAt}, nil
+}
+
+func (l *LocalAuthService) sendVerificationEmail(user *models.User) error {
+	if l.mailer == nil {
+		return ErrMailerNotConfigured
+	}
+	token, err := l.userRepository.CreateActionToken(user.ID, models.UserActionVerifyEmail, time.Now().Add(verifyEmailTokenLifetime))
+	if err != nil {
+		return fmt.Errorf("create token: %v", err)
+	}
+	body := "Welcome to Org Note!\n\n" +
+		"Open the link to verify your email address:\n" +
+		l.clientURL("/auth/verify-email", token) + "\n\n" +
+		"Ignore this email if you didn't register."
+	err = l.mailer.Send(user.Email, "Verify your Org Note email", body)
+	if err != nil {
+		return fmt.Errorf("send email: %v", err)
+	}
+	return nil
+}
+
+func (l *LocalAuthService) clientURL(path string, token string) string {
+	return l.config.ClientAddress + path + "?token=" + url.QueryEscape(token)
+}
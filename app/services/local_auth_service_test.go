@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := normalizeEmail("  User.Name@Example.COM ")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user.name@example.com", email)
+}
+
+func TestNormalizeEmailInvalid(t *testing.T) {
+	for _, email := range []string{"", "user", "user@", "User <user@example.com>", "a@b.com, c@d.com"} {
+		_, err := normalizeEmail(email)
+		assert.ErrorIs(t, err, ErrInvalidEmail, email)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, validatePassword("12345678"))
+	assert.NoError(t, validatePassword("пароль12"))
+	assert.ErrorIs(t, validatePassword("1234567"), ErrInvalidPassword)
+	assert.ErrorIs(t, validatePassword(string(make([]byte, maxPasswordLength+1))), ErrInvalidPassword)
+}
@@ -0,0 +1,6 @@
+package services
+
+// Driver for sending plain text emails
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
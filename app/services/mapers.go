@@ -1,6 +1,9 @@
 package services
 
-import "orgnote/app/models"
+import (
+	"orgnote/app/models"
+	"time"
+)
 
 func mapToPublicUserInfo(user *models.User) *models.PublicUser {
 	return &models.PublicUser{
@@ -26,6 +29,7 @@ func mapToPublicNote(note *models.Note, user *models.User, isMy bool) *models.Pu
 		Encrypted:      note.Encrypted,
 		TouchedAt:      note.TouchedAt,
 		IsMy:           isMy,
+		Revision:       note.Revision,
 	}
 }
 
@@ -40,6 +44,9 @@ func mapToUserPersonalInfo(user *models.User) *models.UserPersonalInfo {
 		SpaceLimit: user.SpaceLimit,
 		UsedSpace:  user.UsedSpace,
 		Active:     user.Active,
+
+		TwoFactorEnabled: user.TwoFactor.IsEnabled(),
+		Role:             user.GetRole(),
 	}
 
 }
@@ -51,3 +58,29 @@ func mapNotesToPublicNotes(notes []models.Note, user *models.User, isMy bool) (m
 	}
 	return
 }
+
+func mapToAdminUser(user *models.User) *models.AdminUser {
+	identities := user.Identities
+	if identities == nil {
+		identities = []models.Identity{}
+	}
+	return &models.AdminUser{
+		ID:                 user.ID.Hex(),
+		Provider:           user.Provider,
+		Name:               user.Name,
+		NickName:           user.NickName,
+		Email:              user.Email,
+		AvatarURL:          user.AvatarURL,
+		Role:               user.GetRole(),
+		Disabled:           user.Disabled,
+		SpaceLimit:         user.SpaceLimit,
+		SpaceLimitOverride: user.SpaceLimitOverride,
+		UsedSpace:          user.UsedSpace,
+		Active:             user.Active,
+		TwoFactorEnabled:   user.TwoFactor.IsEnabled(),
+		Identities:         identities,
+		SessionsCount:      len(listSessions(user.Sessions, nil, time.Now())),
+		APITokensCount:     len(user.APITokens),
+		CreatedAt:          user.ID.Timestamp(),
+	}
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func computeMediaSignature(key []byte, folder string, fileName string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(folder + "/" + fileName + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Build media url which could be opened without authorization until expiration time
+func signMediaURL(key []byte, mediaURL string, folder string, fileName string, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", computeMediaSignature(key, folder, fileName, expires))
+	return mediaURL + "/" + url.PathEscape(folder) + "/" + url.PathEscape(fileName) + "?" + query.Encode()
+}
+
+func verifyMediaSignature(key []byte, folder string, fileName string, rawExpires string, signature string, now time.Time) bool {
+	expires, err := strconv.ParseInt(rawExpires, 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+
+	expectedSignature := computeMediaSignature(key, folder, fileName, expires)
+	return hmac.Equal([]byte(expectedSignature), []byte(signature))
+}
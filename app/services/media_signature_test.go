@@ -0,0 +1,29 @@
+package services
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignMediaURL(t *testing.T) {
+	key := []byte("secret")
+	now := time.Date(2023, 10, 5, 12, 0, 0, 0, time.UTC)
+
+	signedURL := signMediaURL(key, "https://orgnote.dev/media", "user", "my image.png", now.Add(time.Hour))
+
+	parsedURL, err := url.Parse(signedURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "/media/user/my image.png", parsedURL.Path)
+
+	expires := parsedURL.Query().Get("expires")
+	signature := parsedURL.Query().Get("signature")
+	assert.True(t, verifyMediaSignature(key, "user", "my image.png", expires, signature, now))
+	assert.False(t, verifyMediaSignature(key, "user", "another.png", expires, signature, now))
+	assert.False(t, verifyMediaSignature(key, "another-user", "my image.png", expires, signature, now))
+	assert.False(t, verifyMediaSignature([]byte("another key"), "user", "my image.png", expires, signature, now))
+	assert.False(t, verifyMediaSignature(key, "user", "my image.png", expires, signature, now.Add(2*time.Hour)))
+	assert.False(t, verifyMediaSignature(key, "user", "my image.png", "invalid", signature, now))
+}
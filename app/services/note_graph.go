@@ -0,0 +1,260 @@
+package services
+
+import (
+	"fmt"
+	"orgnote/app/models"
+	"orgnote/app/tools"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const MaxNoteGraphDepth = 5
+
+func getGraphNodeTitle(note models.Note) string {
+	if note.Meta.Title != nil && *note.Meta.Title != "" {
+		return *note.Meta.Title
+	}
+	if len(note.FilePath) > 0 {
+		return note.FilePath[len(note.FilePath)-1]
+	}
+	return note.ExternalID
+}
+
+// Return sorted ids of notes linked from the note
+func getGraphNoteTargets(note models.Note) []string {
+	if note.Meta.ConnectedNotes == nil {
+		return nil
+	}
+
+	targets := []string{}
+	for target := range *note.Meta.ConnectedNotes {
+		if id, ok := tools.ExportLinkID(target); ok {
+			target = id
+		}
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Build graph of notes connected by id links. Links to unknown or deleted notes are skipped,
+// node weight is the number of links of the node
+func buildNoteGraph(notes []models.Note) models.NoteGraph {
+	graph := models.NoteGraph{
+		Nodes: []models.GraphNoteNode{},
+		Links: []models.GraphNoteLink{},
+	}
+
+	nodeIndexes := map[string]int{}
+	for _, note := range notes {
+		if _, ok := nodeIndexes[note.ExternalID]; ok {
+			continue
+		}
+		nodeIndexes[note.ExternalID] = len(graph.Nodes)
+		graph.Nodes = append(graph.Nodes, models.GraphNoteNode{
+			ExternalID: note.ExternalID,
+			Title:      getGraphNodeTitle(note),
+			Tags:       note.Meta.FileTags,
+		})
+	}
+
+	addedLinks := map[models.GraphNoteLink]bool{}
+	for _, note := range notes {
+		for _, target := range getGraphNoteTargets(note) {
+			link := models.GraphNoteLink{Source: note.ExternalID, Target: target}
+			_, exists := nodeIndexes[target]
+			if !exists || target == note.ExternalID || addedLinks[link] {
+				continue
+			}
+			addedLinks[link] = true
+			graph.Links = append(graph.Links, link)
+			graph.Nodes[nodeIndexes[link.Source]].Weight++
+			graph.Nodes[nodeIndexes[link.Target]].Weight++
+		}
+	}
+
+	return graph
+}
+
+// Return part of the graph with nodes reachable from the note within depth links in any direction
+func getNoteNeighbourhood(graph models.NoteGraph, noteID string, depth int) models.NoteGraph {
+	neighbours := map[string][]string{}
+	for _, link := range graph.Links {
+		neighbours[link.Source] = append(neighbours[link.Source], link.Target)
+		neighbours[link.Target] = append(neighbours[link.Target], link.Source)
+	}
+
+	neighbourhood := models.NoteGraph{
+		Nodes: []models.GraphNoteNode{},
+		Links: []models.GraphNoteLink{},
+	}
+
+	visited := map[string]bool{}
+	for _, node := range graph.Nodes {
+		if node.ExternalID == noteID {
+			visited[noteID] = true
+		}
+	}
+	if !visited[noteID] {
+		return neighbourhood
+	}
+
+	layer := []string{noteID}
+	for i := 0; i < depth && len(layer) > 0; i++ {
+		nextLayer := []string{}
+		for _, id := range layer {
+			for _, neighbour := range neighbours[id] {
+				if visited[neighbour] {
+					continue
+				}
+				visited[neighbour] = true
+				nextLayer = append(nextLayer, neighbour)
+			}
+		}
+		layer = nextLayer
+	}
+
+	for _, node := range graph.Nodes {
+		if visited[node.ExternalID] {
+			neighbourhood.Nodes = append(neighbourhood.Nodes, node)
+		}
+	}
+	for _, link := range graph.Links {
+		if visited[link.Source] && visited[link.Target] {
+			neighbourhood.Links = append(neighbourhood.Links, link)
+		}
+	}
+	return neighbourhood
+}
+
+func getBacklinks(graph models.NoteGraph, noteID string) []models.GraphNoteNode {
+	sources := map[string]bool{}
+	for _, link := range graph.Links {
+		if link.Target == noteID {
+			sources[link.Source] = true
+		}
+	}
+
+	backlinks := []models.GraphNoteNode{}
+	for _, node := range graph.Nodes {
+		if sources[node.ExternalID] {
+			backlinks = append(backlinks, node)
+		}
+	}
+	return backlinks
+}
+
+func (n *NoteService) refreshNoteGraph(userID string) error {
+	notes, err := n.noteRepository.GetGraphNotes(userID)
+	if err != nil {
+		return fmt.Errorf("note service: refresh note graph: could not get notes: %v", err)
+	}
+
+	graph := buildNoteGraph(notes)
+	err = n.userRepository.SetNoteGraph(userID, graph)
+	if err != nil {
+		return fmt.Errorf("note service: refresh note graph: could not save graph: %v", err)
+	}
+	return nil
+}
+
+type noteGraphRebuild struct {
+	mu      sync.Mutex
+	running bool
+	pending bool
+}
+
+// Rebuilds of the note graph are serialized per user, so a graph built from older notes can't overwrite a newer one.
+// Rebuilds requested while the graph is being built are merged into one rebuild after it
+type noteGraphRebuilds struct {
+	users sync.Map
+}
+
+func (r *noteGraphRebuilds) run(userID string, rebuild func()) {
+	value, _ := r.users.LoadOrStore(userID, &noteGraphRebuild{})
+	state := value.(*noteGraphRebuild)
+
+	state.mu.Lock()
+	if state.running {
+		state.pending = true
+		state.mu.Unlock()
+		return
+	}
+	state.running = true
+	state.mu.Unlock()
+
+	for {
+		rebuild()
+
+		state.mu.Lock()
+		if !state.pending {
+			state.running = false
+			state.mu.Unlock()
+			return
+		}
+		state.pending = false
+		state.mu.Unlock()
+	}
+}
+
+// Rebuild the note graph after notes of the user were changed
+func (n *NoteService) rebuildNoteGraph(userID string) {
+	n.graphRebuilds.run(userID, func() {
+		err := n.refreshNoteGraph(userID)
+		if err != nil {
+			log.Error().Err(err).Msgf("note service: rebuild note graph: could not refresh note graph")
+		}
+	})
+}
+
+func (n *NoteService) getNoteGraph(userID string) (*models.NoteGraph, error) {
+	graph, err := n.userRepository.GetNoteGraph(userID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: get note graph: could not get graph: %v", err)
+	}
+	if graph != nil && graph.Nodes != nil {
+		return graph, nil
+	}
+
+	// Graph was never built for this user, it's built and saved like after notes change
+	n.rebuildNoteGraph(userID)
+	graph, err = n.userRepository.GetNoteGraph(userID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: get note graph: could not get graph: %v", err)
+	}
+	if graph != nil && graph.Nodes != nil {
+		return graph, nil
+	}
+
+	// Graph is still being built by concurrent rebuild or it could not be saved
+	notes, err := n.noteRepository.GetGraphNotes(userID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: get note graph: could not get notes: %v", err)
+	}
+	builtGraph := buildNoteGraph(notes)
+	return &builtGraph, nil
+}
+
+// Return the whole note graph or neighbourhood of the note when noteID is provided
+func (n *NoteService) GetNoteGraph(userID string, noteID string, depth int) (*models.NoteGraph, error) {
+	graph, err := n.getNoteGraph(userID)
+	if err != nil {
+		return nil, err
+	}
+	if noteID == "" {
+		return graph, nil
+	}
+
+	neighbourhood := getNoteNeighbourhood(*graph, noteID, depth)
+	return &neighbourhood, nil
+}
+
+func (n *NoteService) GetBacklinks(userID string, noteID string) ([]models.GraphNoteNode, error) {
+	graph, err := n.getNoteGraph(userID)
+	if err != nil {
+		return nil, err
+	}
+	return getBacklinks(*graph, noteID), nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newGraphNote(id string, title string, connectedIDs ...string) models.Note {
+	connectedNotes := models.ConnectedNotes{}
+	for _, connectedID := range connectedIDs {
+		connectedNotes[connectedID] = ""
+	}
+	return models.Note{
+		ExternalID: id,
+		Meta:       models.NoteMeta{Title: &title, ConnectedNotes: &connectedNotes},
+	}
+}
+
+func TestBuildNoteGraph(t *testing.T) {
+	notes := []models.Note{
+		newGraphNote("a", "Note A", "b", "id:c", "missing", "a"),
+		newGraphNote("b", "Note B", "c"),
+		newGraphNote("c", ""),
+	}
+
+	graph := buildNoteGraph(notes)
+
+	assert.Equal(t, []models.GraphNoteNode{
+		{ExternalID: "a", Title: "Note A", Weight: 2},
+		{ExternalID: "b", Title: "Note B", Weight: 2},
+		{ExternalID: "c", Title: "c", Weight: 2},
+	}, graph.Nodes)
+	assert.Equal(t, []models.GraphNoteLink{
+		{Source: "a", Target: "b"},
+		{Source: "a", Target: "c"},
+		{Source: "b", Target: "c"},
+	}, graph.Links)
+}
+
+func TestGetNoteNeighbourhood(t *testing.T) {
+	graph := buildNoteGraph([]models.Note{
+		newGraphNote("a", "A", "b"),
+		newGraphNote("b", "B", "c"),
+		newGraphNote("c", "C", "d"),
+		newGraphNote("d", "D"),
+		newGraphNote("e", "E"),
+	})
+
+	neighbourhood := getNoteNeighbourhood(graph, "c", 1)
+
+	assert.Len(t, neighbourhood.Nodes, 3)
+	assert.Equal(t, []models.GraphNoteLink{
+		{Source: "b", Target: "c"},
+		{Source: "c", Target: "d"},
+	}, neighbourhood.Links)
+
+	assert.Len(t, getNoteNeighbourhood(graph, "c", 2).Nodes, 4)
+	assert.Empty(t, getNoteNeighbourhood(graph, "unknown", 2).Nodes)
+}
+
+func TestGetBacklinks(t *testing.T) {
+	graph := buildNoteGraph([]models.Note{
+		newGraphNote("a", "A", "c"),
+		newGraphNote("b", "B", "c"),
+		newGraphNote("c", "C", "a"),
+	})
+
+	backlinks := getBacklinks(graph, "c")
+
+	assert.Equal(t, []string{"a", "b"}, []string{backlinks[0].ExternalID, backlinks[1].ExternalID})
+}
+
+func TestNoteGraphRebuildsAreSerialized(t *testing.T) {
+	rebuilds := noteGraphRebuilds{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls, running, maxRunning int32
+
+	rebuild := func() {
+		current := atomic.AddInt32(&running, 1)
+		if current > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, current)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+		}
+		atomic.AddInt32(&running, -1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		rebuilds.run("user", rebuild)
+		close(done)
+	}()
+	<-started
+
+	// Requested during the first rebuild, they are merged into one rebuild after it
+	rebuilds.run("user", rebuild)
+	rebuilds.run("user", rebuild)
+	close(release)
+	<-done
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxRunning))
+
+	rebuilds.run("user", rebuild)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
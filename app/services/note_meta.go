@@ -0,0 +1,64 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"orgnote/app/orgparser"
+)
+
+func fillNotesMeta(notes []models.Note) {
+	for i := range notes {
+		fillNoteMeta(&notes[i])
+	}
+}
+
+// Derive note meta and agenda items from org content. Encrypted notes keep meta provided by the client.
+// Title and description from the client are kept when the content doesn't define them
+func fillNoteMeta(note *models.Note) {
+	note.Agenda = buildAgendaItems(*note)
+	if note.Encrypted {
+		return
+	}
+
+	doc := orgparser.Parse(note.Content)
+	meta := &note.Meta
+
+	if doc.Title != "" {
+		meta.Title = &doc.Title
+	}
+	if doc.Description != "" {
+		meta.Description = &doc.Description
+	}
+	if doc.Startup != "" {
+		meta.Startup = &doc.Startup
+	}
+
+	meta.FileTags = doc.FileTags
+
+	headings := make([]models.NoteHeading, len(doc.Headings))
+	for i, h := range doc.Headings {
+		headings[i] = models.NoteHeading{Level: h.Level, Text: h.Title}
+	}
+	meta.Headings = &headings
+
+	connectedNotes := models.ConnectedNotes{}
+	for _, link := range doc.IDLinks {
+		connectedNotes[link.URL] = link.Name
+	}
+	meta.ConnectedNotes = &connectedNotes
+
+	externalLinks := make([]models.NoteLink, len(doc.Links))
+	for i, link := range doc.Links {
+		externalLinks[i] = models.NoteLink{Url: link.URL, Name: link.Name}
+	}
+	meta.ExternalLinks = &externalLinks
+
+	images := make([]string, len(doc.Images))
+	for i, image := range doc.Images {
+		images[i] = orgparser.ImageFileName(image)
+	}
+	meta.Images = images
+
+	if meta.PreviewImg == nil && len(images) > 0 {
+		meta.PreviewImg = &images[0]
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFillNoteMetaFromContent(t *testing.T) {
+	note := models.Note{
+		Content: "#+TITLE: Title\n#+FILETAGS: :tag:\n* Heading\n[[id:other][Other]] [[file:img.png]]",
+	}
+
+	fillNoteMeta(&note)
+
+	assert.Equal(t, "Title", *note.Meta.Title)
+	assert.Equal(t, []string{"tag"}, note.Meta.FileTags)
+	assert.Equal(t, []models.NoteHeading{{Level: 1, Text: "Heading"}}, *note.Meta.Headings)
+	assert.Equal(t, models.ConnectedNotes{"other": "Other"}, *note.Meta.ConnectedNotes)
+	assert.Equal(t, []string{"img.png"}, note.Meta.Images)
+	assert.Equal(t, "img.png", *note.Meta.PreviewImg)
+}
+
+func TestFillNoteMetaKeepsEncryptedNoteMeta(t *testing.T) {
+	title := "Client title"
+	note := models.Note{
+		Content:   "-----BEGIN PGP MESSAGE-----",
+		Encrypted: true,
+		Meta:      models.NoteMeta{Title: &title},
+	}
+
+	fillNoteMeta(&note)
+
+	assert.Equal(t, "Client title", *note.Meta.Title)
+	assert.Nil(t, note.Meta.Headings)
+	assert.Empty(t, note.Agenda)
+}
+
+func TestFillNoteMetaProjectsAgenda(t *testing.T) {
+	note := models.Note{
+		ExternalID: "note-id",
+		Content:    "* TODO Task\n* Plain heading",
+	}
+
+	fillNoteMeta(&note)
+
+	assert.Len(t, note.Agenda, 1)
+	assert.Equal(t, "Task", note.Agenda[0].Title)
+	assert.Equal(t, "note-id", note.Agenda[0].NoteID)
+}
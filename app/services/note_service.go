@@ -2,43 +2,50 @@ package services
 
 import (
 	"fmt"
+	"orgnote/app/configs"
 	"orgnote/app/models"
 	"orgnote/app/repositories"
+	"orgnote/app/tools"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
-type NoteFileStorage interface {
-	CalculateFileSize(folder string, fileName ...string) (int64, error)
-}
-
 type NoteService struct {
 	noteRepository *repositories.NoteRepository
 	userRepository *repositories.UserRepository
 	tagRepository  *repositories.TagRepository
-	fileStorage    NoteFileStorage
+	fileRepository *repositories.FileRepository
+	config         configs.Config
+	events         EventPublisher
+	graphRebuilds  noteGraphRebuilds
 }
 
 func NewNoteService(
 	noteRepository *repositories.NoteRepository,
 	userRepository *repositories.UserRepository,
 	tagRepository *repositories.TagRepository,
-	fileStorage NoteFileStorage,
+	fileRepository *repositories.FileRepository,
+	config configs.Config,
+	events EventPublisher,
 ) *NoteService {
 	return &NoteService{
-		noteRepository,
-		userRepository,
-		tagRepository,
-		fileStorage,
+		noteRepository: noteRepository,
+		userRepository: userRepository,
+		tagRepository:  tagRepository,
+		fileRepository: fileRepository,
+		config:         config,
+		events:         events,
 	}
 }
 
 func (a *NoteService) CreateNote(note models.Note) error {
+	fillNoteMeta(&note)
 	err := a.noteRepository.AddNote(note)
 	if err != nil {
 		return err
 	}
+	go a.rebuildNoteGraph(note.AuthorID)
 	return nil
 }
 
@@ -57,18 +64,22 @@ func (n *NoteService) BulkCreateOrUpdate(userID string, notes []models.Note) err
 			continue
 		}
 		note.AuthorID = userID
+		fillNoteMeta(&note)
 		filteredNotesWithID = append(filteredNotesWithID, models.Note{
-			ID:         note.ID,
-			ExternalID: note.ExternalID,
-			AuthorID:   userID,
-			Content:    note.Content,
-			Meta:       note.Meta,
-			CreatedAt:  note.CreatedAt,
-			UpdatedAt:  time.Now(),
-			TouchedAt:  note.TouchedAt,
-			FilePath:   note.FilePath,
-			Views:      0,
-			Likes:      0,
+			ID:             note.ID,
+			ExternalID:     note.ExternalID,
+			AuthorID:       userID,
+			Content:        note.Content,
+			Meta:           note.Meta,
+			CreatedAt:      note.CreatedAt,
+			UpdatedAt:      time.Now(),
+			TouchedAt:      note.TouchedAt,
+			FilePath:       note.FilePath,
+			EncryptionType: note.EncryptionType,
+			Encrypted:      note.Encrypted,
+			Views:          0,
+			Likes:          0,
+			Agenda:         note.Agenda,
 		})
 		tags = append(tags, note.Meta.FileTags...)
 	}
@@ -77,6 +88,9 @@ func (n *NoteService) BulkCreateOrUpdate(userID string, notes []models.Note) err
 	if err != nil {
 		return fmt.Errorf("note service: bulk create or update: could not bulk upsert notes: %v", err)
 	}
+	go n.pruneRevisions(userID, filteredNotesWithID)
+	go n.rebuildNoteGraph(userID)
+	publishEvent(n.events, models.EventNoteUpdated, userID, getNoteIDs(filteredNotesWithID))
 	if len(tags) == 0 {
 		return nil
 	}
@@ -123,6 +137,46 @@ func (a *NoteService) GetNotes(filter models.NoteFilter, requestedUserId string)
 	}, nil
 }
 
+func (a *NoteService) SearchNotes(filter models.NoteFilter, requestedUserId string) (*models.Paginated[models.NoteSearchResult], error) {
+	hits, err := a.noteRepository.SearchNotes(filter)
+	if err != nil {
+		return nil, fmt.Errorf("note service: search notes: could not search notes: %v", err)
+	}
+
+	count, err := a.noteRepository.NotesCount(filter)
+	if err != nil {
+		return nil, fmt.Errorf("note service: search notes: get notes count: %v", err)
+	}
+
+	notes := make([]models.Note, len(hits))
+	for i, hit := range hits {
+		notes[i] = hit.Note
+	}
+
+	usersMap, err := a.getNotesUsers(notes)
+	if err != nil {
+		return nil, fmt.Errorf("note service: search notes: could not get users: %v", err)
+	}
+
+	results := []models.NoteSearchResult{}
+	for _, hit := range hits {
+		u := usersMap[hit.AuthorID]
+		my := hit.AuthorID == requestedUserId
+		results = append(results, models.NoteSearchResult{
+			PublicNote: *mapToPublicNote(&hit.Note, &u, my),
+			Score:      hit.Score,
+			Highlights: highlightNote(&hit.Note, *filter.SearchText),
+		})
+	}
+
+	return &models.Paginated[models.NoteSearchResult]{
+		Limit:  *filter.Limit,
+		Offset: *filter.Offset,
+		Total:  count,
+		Data:   results,
+	}, nil
+}
+
 func (n *NoteService) GetDeletedNotes(userID string, deletedAt time.Time) ([]models.Note, error) {
 	filter := models.NoteFilter{
 		UserID:    &userID,
@@ -188,7 +242,13 @@ func (a *NoteService) GetNote(id string, userID string) (*models.PublicNote, err
 
 // TODO: master delete everything about graph. Redundant
 func (n *NoteService) DeleteNotes(ids []string, authorID string) error {
-	return n.noteRepository.MarkNotesAsDeleted(ids, authorID)
+	err := n.noteRepository.MarkNotesAsDeleted(ids, authorID)
+	if err != nil {
+		return fmt.Errorf("note service: delete notes: could not mark notes as deleted: %v", err)
+	}
+	go n.rebuildNoteGraph(authorID)
+	publishEvent(n.events, models.EventNoteDeleted, authorID, ids)
+	return nil
 }
 
 func (n *NoteService) DeleteAllNotes(userID string) error {
@@ -197,17 +257,23 @@ func (n *NoteService) DeleteAllNotes(userID string) error {
 	if err != nil {
 		return fmt.Errorf("note service: delete all notes: could not delete user notes: %v", err)
 	}
+	go n.rebuildNoteGraph(userID)
 
 	return nil
 }
 
+type SyncNotesResult struct {
+	Notes     []models.PublicNote
+	Conflicts []models.NoteConflict
+}
+
 // TODO: master signature is too complex. Create a struct for params.
 func (n *NoteService) SyncNotes(
 	notes []models.Note,
 	deletedNotesIDs []string,
 	timestamp time.Time,
 	user *models.User,
-) ([]models.PublicNote, error) {
+) (*SyncNotesResult, error) {
 	authorID := user.ID.Hex()
 	filter := models.NoteFilter{
 		From:           &timestamp,
@@ -221,11 +287,19 @@ func (n *NoteService) SyncNotes(
 		return nil, fmt.Errorf("note service: sync notes: could not delete outdated notes: %v", err)
 	}
 
-	err = n.bulkUpdateOutdatedNotes(notes, authorID)
+	notes, conflicts, err := n.resolveConflicts(notes, user)
+
+	if err != nil {
+		return nil, err
+	}
+	fillNotesMeta(notes)
+
+	writeConflicts, err := n.bulkUpdateOutdatedNotes(notes, user)
 
 	if err != nil {
 		return nil, err
 	}
+	conflicts = append(conflicts, writeConflicts...)
 
 	notesFromLastSync, err := n.noteRepository.GetNotes(filter)
 
@@ -233,25 +307,299 @@ func (n *NoteService) SyncNotes(
 		return nil, fmt.Errorf("note service: sync notes: could not get notes: %v", err)
 	}
 
-	updatedNotes := n.excludeSameNotes(notesFromLastSync, notes)
+	updatedNotes := n.excludeConflictedNotes(n.excludeSameNotes(notesFromLastSync, notes), conflicts)
 
+	go n.rebuildNoteGraph(authorID)
+	publishEvent(n.events, models.EventNoteDeleted, authorID, deletedNotesIDs)
+	publishEvent(n.events, models.EventNoteUpdated, authorID, getNoteIDs(notes))
 	go n.CalculateUserSpace(authorID)
-	return mapNotesToPublicNotes(updatedNotes, user, true), nil
+	return &SyncNotesResult{
+		Notes:     mapNotesToPublicNotes(updatedNotes, user, true),
+		Conflicts: conflicts,
+	}, nil
+}
+
+type SyncNotesPage struct {
+	Notes        []models.PublicNote
+	DeletedNotes []models.Note
+	Conflicts    []models.NoteConflict
+	Cursor       string
+	HasMore      bool
+}
+
+// Synchronize notes using server change sequence instead of client timestamps.
+// Changes are returned in pages, next page should be requested with returned cursor while HasMore is true
+func (n *NoteService) SyncNotesByCursor(
+	notes []models.Note,
+	deletedNotesIDs []string,
+	cursor string,
+	user *models.User,
+) (*SyncNotesPage, error) {
+	authorID := user.ID.Hex()
+
+	changeSeq, err := decodeSyncCursor(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("note service: sync notes by cursor: %w", err)
+	}
+
+	err = n.noteRepository.DeleteNotesUpToSequence(deletedNotesIDs, authorID, changeSeq)
+	if err != nil {
+		return nil, fmt.Errorf("note service: sync notes by cursor: could not delete notes: %v", err)
+	}
+
+	notes, conflicts, err := n.resolveConflicts(notes, user)
+	if err != nil {
+		return nil, err
+	}
+	fillNotesMeta(notes)
+
+	writeConflicts, err := n.bulkUpdateOutdatedNotes(notes, user)
+	if err != nil {
+		return nil, err
+	}
+	conflicts = append(conflicts, writeConflicts...)
+
+	pageSize := n.config.SyncPageSize
+	changedNotes, err := n.noteRepository.GetChangesAfter(authorID, changeSeq, pageSize+1)
+	if err != nil {
+		return nil, fmt.Errorf("note service: sync notes by cursor: could not get changed notes: %v", err)
+	}
+
+	hasMore := int64(len(changedNotes)) > pageSize
+	if hasMore {
+		changedNotes = changedNotes[:pageSize]
+	}
+
+	lastChangeSeq := changeSeq
+	if len(changedNotes) > 0 {
+		lastChangeSeq = changedNotes[len(changedNotes)-1].ChangeSeq
+	}
+
+	updatedNotes := []models.Note{}
+	deletedNotes := []models.Note{}
+	for _, note := range n.excludeConflictedNotes(changedNotes, conflicts) {
+		if note.DeletedAt != nil {
+			deletedNotes = append(deletedNotes, note)
+			continue
+		}
+		updatedNotes = append(updatedNotes, note)
+	}
+
+	if len(notes) > 0 || len(deletedNotesIDs) > 0 {
+		go n.rebuildNoteGraph(authorID)
+		publishEvent(n.events, models.EventNoteDeleted, authorID, deletedNotesIDs)
+		publishEvent(n.events, models.EventNoteUpdated, authorID, getNoteIDs(notes))
+		go n.CalculateUserSpace(authorID)
+	}
+
+	return &SyncNotesPage{
+		Notes:        mapNotesToPublicNotes(updatedNotes, user, true),
+		DeletedNotes: deletedNotes,
+		Conflicts:    conflicts,
+		Cursor:       encodeSyncCursor(lastChangeSeq),
+		HasMore:      hasMore,
+	}, nil
+}
+
+// Compare notes edited from the base revision with saved ones.
+// Concurrent changes are merged when possible, otherwise the note is excluded from update and returned as conflict
+func (n *NoteService) resolveConflicts(notes []models.Note, user *models.User) ([]models.Note, []models.NoteConflict, error) {
+	authorID := user.ID.Hex()
+	resolvedNotes := []models.Note{}
+	conflicts := []models.NoteConflict{}
+
+	for _, note := range notes {
+		if note.BaseRevision == nil {
+			resolvedNotes = append(resolvedNotes, note)
+			continue
+		}
+
+		savedNote, err := n.noteRepository.GetNote(note.ExternalID, authorID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("note service: resolve conflicts: could not get note: %v", err)
+		}
+
+		if savedNote == nil || savedNote.AuthorID != authorID {
+			note.BaseRevision = nil
+			resolvedNotes = append(resolvedNotes, note)
+			continue
+		}
+
+		baseRevision := *note.BaseRevision
+		note.BaseRevision = &savedNote.Revision
+
+		if savedNote.Revision == baseRevision || savedNote.DeletedAt != nil || savedNote.Content == note.Content {
+			resolvedNotes = append(resolvedNotes, note)
+			continue
+		}
+
+		mergedContent, ok, err := n.mergeNoteContent(note, savedNote, baseRevision)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !ok {
+			conflicts = append(conflicts, models.NoteConflict{
+				ID:           note.ExternalID,
+				BaseRevision: baseRevision,
+				ServerNote:   *mapToPublicNote(savedNote, user, true),
+				ClientNote:   *mapToPublicNote(&note, user, true),
+			})
+			continue
+		}
+
+		note.Content = mergedContent
+		note.UpdatedAt = time.Now()
+		resolvedNotes = append(resolvedNotes, note)
+	}
+
+	return resolvedNotes, conflicts, nil
+}
+
+func (n *NoteService) mergeNoteContent(note models.Note, savedNote *models.Note, baseRevision int64) (string, bool, error) {
+	if note.Encrypted || savedNote.Encrypted {
+		return "", false, nil
+	}
+
+	base, err := n.noteRepository.GetNoteRevisionByNumber(note.ExternalID, savedNote.AuthorID, baseRevision)
+	if err != nil {
+		return "", false, fmt.Errorf("note service: merge note content: could not get base revision: %v", err)
+	}
+
+	if base == nil {
+		return "", false, nil
+	}
+
+	mergedContent, ok := tools.MergeLines(base.Content, savedNote.Content, note.Content)
+	return mergedContent, ok, nil
+}
+
+func (n *NoteService) excludeConflictedNotes(notes []models.Note, conflicts []models.NoteConflict) []models.Note {
+	if len(conflicts) == 0 {
+		return notes
+	}
+
+	conflictedIDs := make(map[string]struct{}, len(conflicts))
+	for _, c := range conflicts {
+		conflictedIDs[c.ID] = struct{}{}
+	}
+
+	filteredNotes := []models.Note{}
+	for _, note := range notes {
+		if _, conflicted := conflictedIDs[note.ExternalID]; conflicted {
+			continue
+		}
+		filteredNotes = append(filteredNotes, note)
+	}
+	return filteredNotes
 }
 
-func (n *NoteService) bulkUpdateOutdatedNotes(notes []models.Note, authorID string) error {
+// Update outdated notes and return conflicts of notes edited from the base revision
+// which were changed concurrently after their conflicts were resolved
+func (n *NoteService) bulkUpdateOutdatedNotes(notes []models.Note, user *models.User) ([]models.NoteConflict, error) {
 	someNotesPresent := len(notes) > 0
 
 	log.Info().Msgf("note service: notes length: %v", len(notes))
 
 	if !someNotesPresent {
-		return nil
+		return nil, nil
+	}
+	authorID := user.ID.Hex()
+	skippedIDs, err := n.noteRepository.BulkUpdateOutdated(notes, authorID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: sync notes: could not update outdated notes: %v", err)
+	}
+	go n.pruneRevisions(authorID, notes)
+	return n.getSkippedNotesConflicts(notes, skippedIDs, user)
+}
+
+func (n *NoteService) getSkippedNotesConflicts(notes []models.Note, skippedIDs []string, user *models.User) ([]models.NoteConflict, error) {
+	conflicts := []models.NoteConflict{}
+	if len(skippedIDs) == 0 {
+		return conflicts, nil
+	}
+
+	skipped := make(map[string]struct{}, len(skippedIDs))
+	for _, id := range skippedIDs {
+		skipped[id] = struct{}{}
+	}
+
+	for _, note := range notes {
+		// Notes without base revision are skipped when the saved note is newer, it's not a conflict
+		if _, ok := skipped[note.ExternalID]; !ok || note.BaseRevision == nil {
+			continue
+		}
+		savedNote, err := n.noteRepository.GetNote(note.ExternalID, user.ID.Hex())
+		if err != nil {
+			return nil, fmt.Errorf("note service: get skipped notes conflicts: could not get note: %v", err)
+		}
+		if savedNote == nil {
+			continue
+		}
+		conflicts = append(conflicts, models.NoteConflict{
+			ID:           note.ExternalID,
+			BaseRevision: *note.BaseRevision,
+			ServerNote:   *mapToPublicNote(savedNote, user, true),
+			ClientNote:   *mapToPublicNote(&note, user, true),
+		})
 	}
-	err := n.noteRepository.BulkUpdateOutdated(notes, authorID)
+	return conflicts, nil
+}
+
+func (n *NoteService) pruneRevisions(userID string, notes []models.Note) {
+	err := n.noteRepository.PruneRevisions(userID, getNoteIDs(notes), n.config.NoteRevisionsLimit, n.config.NoteRevisionsMaxAge)
 	if err != nil {
-		return fmt.Errorf("note service: sync notes: could not update outdated notes: %v", err)
+		log.Error().Err(err).Msgf("note service: prune revisions: could not prune revisions")
 	}
-	return nil
+}
+
+func (n *NoteService) GetNoteRevisions(noteID string, userID string) ([]models.NoteRevision, error) {
+	revisions, err := n.noteRepository.GetNoteRevisions(noteID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: get note revisions: could not get revisions: %v", err)
+	}
+	return revisions, nil
+}
+
+func (n *NoteService) GetNoteRevision(noteID string, userID string, revisionID string) (*models.NoteRevision, error) {
+	revision, err := n.noteRepository.GetNoteRevision(noteID, userID, revisionID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: get note revision: could not get revision: %v", err)
+	}
+	return revision, nil
+}
+
+func (n *NoteService) RestoreNoteRevision(noteID string, revisionID string, user *models.User) (*models.PublicNote, error) {
+	userID := user.ID.Hex()
+	revision, err := n.noteRepository.GetNoteRevision(noteID, userID, revisionID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: restore note revision: could not get revision: %v", err)
+	}
+	if revision == nil {
+		return nil, nil
+	}
+
+	agenda := buildAgendaItems(models.Note{
+		ExternalID: revision.NoteID,
+		AuthorID:   userID,
+		Content:    revision.Content,
+		Encrypted:  revision.Encrypted,
+	})
+	err = n.noteRepository.RestoreRevision(*revision, agenda)
+	if err != nil {
+		return nil, fmt.Errorf("note service: restore note revision: could not restore revision: %v", err)
+	}
+
+	go n.pruneRevisions(userID, []models.Note{{ExternalID: noteID}})
+	go n.CalculateUserSpace(userID)
+	go n.rebuildNoteGraph(userID)
+	publishEvent(n.events, models.EventNoteUpdated, userID, []string{noteID})
+
+	note, err := n.noteRepository.GetNote(noteID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("note service: restore note revision: could not get restored note: %v", err)
+	}
+	return mapToPublicNote(note, user, true), nil
 }
 
 func (n *NoteService) excludeSameNotes(srcNotes []models.Note, filterNotes []models.Note) []models.Note {
@@ -260,7 +608,8 @@ func (n *NoteService) excludeSameNotes(srcNotes []models.Note, filterNotes []mod
 	for _, srcNote := range srcNotes {
 		exists := false
 		for _, filterNote := range filterNotes {
-			if srcNote.ExternalID == filterNote.ExternalID && srcNote.UpdatedAt.Equal(filterNote.UpdatedAt) {
+			// NOTE: notes synced by revision are returned back to provide the client with the new revision number
+			if filterNote.BaseRevision == nil && srcNote.ExternalID == filterNote.ExternalID && srcNote.UpdatedAt.Equal(filterNote.UpdatedAt) {
 				exists = true
 				break
 			}
@@ -280,9 +629,10 @@ func (n *NoteService) CalculateUserSpace(userID string) error {
 	}
 	log.Info().Msgf("note service: calculate user space: space info: %v", spaceInfo)
 
-	usedFileSpace, err := n.fileStorage.CalculateFileSize(userID, spaceInfo.Files...)
+	// Blobs shared by several user files are counted once
+	usedFileSpace, err := n.fileRepository.GetUsedSpace(userID)
 	if err != nil {
-		return fmt.Errorf("note service: calculate user space: could not calculate file size: %v", err)
+		return fmt.Errorf("note service: calculate user space: could not calculate files space: %v", err)
 	}
 
 	totalUsedSpace := spaceInfo.UsedSpace + usedFileSpace
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	snippetRadius      = 60
+	maxContentSnippets = 3
+)
+
+// Extract search terms from mongo text search query. Negated terms are ignored
+func getSearchTerms(query string) []string {
+	terms := []string{}
+	for _, term := range strings.Fields(query) {
+		if strings.HasPrefix(term, "-") {
+			continue
+		}
+		term = strings.Trim(term, `"`)
+		if term == "" {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+func toLowerRunes(text string) []rune {
+	runes := []rune(text)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+func findMatches(text []rune, terms []string) []models.TextRange {
+	matches := []models.TextRange{}
+
+	for _, term := range terms {
+		lowerTerm := toLowerRunes(term)
+		if len(lowerTerm) == 0 {
+			continue
+		}
+		for i := 0; i+len(lowerTerm) <= len(text); i++ {
+			if string(text[i:i+len(lowerTerm)]) == string(lowerTerm) {
+				matches = append(matches, models.TextRange{Start: i, End: i + len(lowerTerm)})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Start < matches[j].Start
+	})
+
+	merged := []models.TextRange{}
+	for _, m := range matches {
+		last := len(merged) - 1
+		if last >= 0 && m.Start <= merged[last].End {
+			merged[last].End = max(merged[last].End, m.End)
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+func buildHighlights(field string, text string, terms []string, maxSnippets int) []models.SearchHighlight {
+	runes := []rune(text)
+	matches := findMatches(toLowerRunes(text), terms)
+	highlights := []models.SearchHighlight{}
+
+	for i := 0; i < len(matches) && len(highlights) < maxSnippets; {
+		start := max(matches[i].Start-snippetRadius, 0)
+		end := min(matches[i].End+snippetRadius, len(runes))
+
+		snippetMatches := []models.TextRange{}
+		for ; i < len(matches) && matches[i].End <= end; i++ {
+			snippetMatches = append(snippetMatches, models.TextRange{
+				Start: matches[i].Start - start,
+				End:   matches[i].End - start,
+			})
+		}
+
+		highlights = append(highlights, models.SearchHighlight{
+			Field:   field,
+			Snippet: string(runes[start:end]),
+			Matches: snippetMatches,
+		})
+	}
+
+	return highlights
+}
+
+func highlightNote(note *models.Note, query string) []models.SearchHighlight {
+	terms := getSearchTerms(query)
+	highlights := []models.SearchHighlight{}
+	if note.Encrypted {
+		return highlights
+	}
+
+	if note.Meta.Title != nil {
+		highlights = append(highlights, buildHighlights("title", *note.Meta.Title, terms, 1)...)
+	}
+	if note.Meta.Description != nil {
+		highlights = append(highlights, buildHighlights("description", *note.Meta.Description, terms, 1)...)
+	}
+	for _, tag := range note.Meta.FileTags {
+		highlights = append(highlights, buildHighlights("fileTags", tag, terms, 1)...)
+	}
+	highlights = append(highlights, buildHighlights("content", note.Content, terms, maxContentSnippets)...)
+
+	return highlights
+}
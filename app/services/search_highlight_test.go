@@ -0,0 +1,41 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchTermsExcludeNegatedTerms(t *testing.T) {
+	terms := getSearchTerms(`emacs "org" -vim`)
+
+	assert.Equal(t, []string{"emacs", "org"}, terms)
+}
+
+func TestHighlightNoteCaseInsensitive(t *testing.T) {
+	title := "Привет Emacs"
+	note := &models.Note{
+		Content: "* Heading\nSome text about emacs and EMACS lisp",
+		Meta:    models.NoteMeta{Title: &title, FileTags: []string{"emacs", "org"}},
+	}
+
+	highlights := highlightNote(note, "emacs")
+
+	assert.Equal(t, []models.SearchHighlight{
+		{Field: "title", Snippet: "Привет Emacs", Matches: []models.TextRange{{Start: 7, End: 12}}},
+		{Field: "fileTags", Snippet: "emacs", Matches: []models.TextRange{{Start: 0, End: 5}}},
+		{
+			Field:   "content",
+			Snippet: "* Heading\nSome text about emacs and EMACS lisp",
+			Matches: []models.TextRange{{Start: 26, End: 31}, {Start: 36, End: 41}},
+		},
+	}, highlights)
+}
+
+func TestHighlightNoteSkipsEncryptedNote(t *testing.T) {
+	title := "emacs"
+	note := &models.Note{Content: "emacs", Encrypted: true, Meta: models.NoteMeta{Title: &title}}
+
+	assert.Empty(t, highlightNote(note, "emacs"))
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSyncCursor = errors.New("invalid sync cursor")
+
+const syncCursorPrefix = "v1:"
+
+// Sync cursor is opaque for clients and contains last received change sequence
+func encodeSyncCursor(changeSeq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncCursorPrefix + strconv.FormatInt(changeSeq, 10)))
+}
+
+// Empty cursor means synchronization from the beginning
+func decodeSyncCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return -1, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidSyncCursor
+	}
+
+	rawSeq, found := strings.CutPrefix(string(decoded), syncCursorPrefix)
+	if !found {
+		return 0, ErrInvalidSyncCursor
+	}
+
+	changeSeq, err := strconv.ParseInt(rawSeq, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSyncCursor
+	}
+
+	return changeSeq, nil
+}
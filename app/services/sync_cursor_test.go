@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncCursor_RoundTrip(t *testing.T) {
+	changeSeq, err := decodeSyncCursor(encodeSyncCursor(42))
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), changeSeq)
+}
+
+func TestSyncCursor_EmptyCursorStartsFromBeginning(t *testing.T) {
+	changeSeq, err := decodeSyncCursor("")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), changeSeq)
+}
+
+func TestSyncCursor_InvalidCursor(t *testing.T) {
+	_, err := decodeSyncCursor("not a cursor")
+
+	assert.ErrorIs(t, err, ErrInvalidSyncCursor)
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"orgnote/app/models"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/rs/zerolog/log"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Folder of generated thumbnails, they are named by hash of the source blob and width
+const thumbnailFolder = "thumbnails"
+
+const (
+	thumbnailQuality         = 80
+	thumbnailQueueSize       = 100
+	thumbnailWorkers         = 2
+	maxThumbnailSourceSize   = 50 * 1024 * 1024
+	maxThumbnailSourcePixels = 50 * 1000 * 1000
+	thumbnailMimeType        = "image/jpeg"
+)
+
+var ErrImageTooLarge = errors.New("image is too large")
+
+var thumbnailSourceTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+func thumbnailName(hash string, width int) string {
+	return fmt.Sprintf("%s-%d.jpg", hash, width)
+}
+
+func isThumbnailSupported(file models.StoredFile) bool {
+	return thumbnailSourceTypes[file.MimeType] && file.Size <= maxThumbnailSourceSize
+}
+
+// Return the smallest configured width which is not less than requested one
+func selectThumbnailWidth(widths []int, requested int) int {
+	if requested <= 0 || len(widths) == 0 {
+		return 0
+	}
+	for _, width := range widths {
+		if width >= requested {
+			return width
+		}
+	}
+	return widths[len(widths)-1]
+}
+
+// Resize image to the width and encode it as JPEG. Image is never upscaled,
+// EXIF orientation is applied and metadata (including location) is not copied
+func renderThumbnail(data []byte, width int) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image config: %v", err)
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image: %v", err)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = readJPEGOrientation(data)
+	}
+
+	bounds := img.Bounds()
+	displayedWidth := bounds.Dx()
+	if isTransposedOrientation(orientation) {
+		displayedWidth = bounds.Dy()
+	}
+
+	dstWidth, dstHeight := bounds.Dx(), bounds.Dy()
+	if width < displayedWidth {
+		dstWidth = max(1, bounds.Dx()*width/displayedWidth)
+		dstHeight = max(1, bounds.Dy()*width/displayedWidth)
+	}
+
+	// JPEG has no transparency, transparent pixels become white
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Over, nil)
+
+	buf := bytes.Buffer{}
+	err = jpeg.Encode(&buf, applyOrientation(dst, orientation), &jpeg.Options{Quality: thumbnailQuality})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Generate missing thumbnails of the file, source image is loaded only when something should be generated
+func (a *FileService) createThumbnails(file models.StoredFile, widths []int) error {
+	var source []byte
+	for _, width := range widths {
+		name := thumbnailName(file.Hash, width)
+		_, err := a.fileStorage.Stat(thumbnailFolder, name)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("file service: create thumbnails: could not get thumbnail info: %v", err)
+		}
+
+		if source == nil {
+			source, err = a.readBlob(file.Hash)
+			if err != nil {
+				return fmt.Errorf("file service: create thumbnails: %v", err)
+			}
+		}
+
+		thumbnail, err := renderThumbnail(source, width)
+		if err != nil {
+			return fmt.Errorf("file service: create thumbnails: could not render thumbnail of %s: %v", file.Hash, err)
+		}
+
+		err = a.fileStorage.Put(thumbnailFolder, name, bytes.NewReader(thumbnail), int64(len(thumbnail)))
+		if err != nil {
+			return fmt.Errorf("file service: create thumbnails: could not put thumbnail: %v", err)
+		}
+	}
+	return nil
+}
+
+func (a *FileService) readBlob(hash string) ([]byte, error) {
+	content, err := a.fileStorage.Get(blobFolder, hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not open blob: %v", err)
+	}
+	defer content.Close()
+
+	data, err := io.ReadAll(io.LimitReader(content, maxThumbnailSourceSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read blob: %v", err)
+	}
+	if len(data) > maxThumbnailSourceSize {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
+
+// Return generated thumbnail of the file, nil when it doesn't exist yet
+func (a *FileService) findThumbnail(file models.StoredFile, width int) (*models.StoredFile, error) {
+	thumbnail, err := a.fileStorage.Stat(thumbnailFolder, thumbnailName(file.Hash, width))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file service: find thumbnail: could not get thumbnail info: %v", err)
+	}
+	return &models.StoredFile{
+		Name:      file.Name,
+		Hash:      file.Hash,
+		Size:      thumbnail.Size,
+		MimeType:  thumbnailMimeType,
+		UpdatedAt: thumbnail.UpdatedAt,
+	}, nil
+}
+
+func (a *FileService) deleteThumbnails(hash string) {
+	for _, width := range a.config.ThumbnailWidths {
+		err := a.fileStorage.Delete(thumbnailFolder, thumbnailName(hash, width))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Error().Err(err).Msgf("file service: delete thumbnails: could not delete thumbnail of %s", hash)
+		}
+	}
+}
+
+// Queue thumbnails generation, file which is already queued is skipped.
+// When the queue is full thumbnails are queued again on the next request of a missing thumbnail
+func (a *FileService) enqueueThumbnails(file models.StoredFile) {
+	if a.thumbnails == nil || !isThumbnailSupported(file) {
+		return
+	}
+	if _, queued := a.pendingThumbnails.LoadOrStore(file.Hash, true); queued {
+		return
+	}
+	select {
+	case a.thumbnails <- file:
+	default:
+		a.pendingThumbnails.Delete(file.Hash)
+		log.Warn().Msgf("file service: enqueue thumbnails: queue is full, thumbnails of %s are skipped", file.Hash)
+	}
+}
+
+func (a *FileService) startThumbnailWorkers() {
+	a.thumbnails = make(chan models.StoredFile, thumbnailQueueSize)
+	for i := 0; i < thumbnailWorkers; i++ {
+		go func() {
+			for file := range a.thumbnails {
+				err := a.createThumbnails(file, a.config.ThumbnailWidths)
+				if err != nil {
+					log.Error().Err(err).Msgf("file service: thumbnail worker: could not create thumbnails of %s", file.Hash)
+				}
+				a.pendingThumbnails.Delete(file.Hash)
+			}
+		}()
+	}
+}
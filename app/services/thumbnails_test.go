@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestPNG(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	buf := bytes.Buffer{}
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// JPEG image with EXIF segment containing orientation and GPS tags
+func encodeTestJPEGWithExif(t *testing.T, width int, height int, orientation uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	buf := bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	data := buf.Bytes()
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0x00, 0x00)
+	tiff = append(tiff, 0x25, 0x88, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	result := append([]byte{}, data[:2]...)
+	result = append(result, app1...)
+	return append(result, data[2:]...)
+}
+
+func decodeTestImageSize(t *testing.T, data []byte) (int, int) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	return config.Width, config.Height
+}
+
+func TestSelectThumbnailWidth(t *testing.T) {
+	widths := []int{320, 640, 1280}
+
+	assert.Equal(t, 0, selectThumbnailWidth(widths, 0))
+	assert.Equal(t, 320, selectThumbnailWidth(widths, 100))
+	assert.Equal(t, 640, selectThumbnailWidth(widths, 321))
+	assert.Equal(t, 1280, selectThumbnailWidth(widths, 5000))
+	assert.Equal(t, 0, selectThumbnailWidth(nil, 100))
+}
+
+func TestRenderThumbnail(t *testing.T) {
+	thumbnail, err := renderThumbnail(encodeTestPNG(t, 200, 100), 50)
+	require.NoError(t, err)
+
+	width, height := decodeTestImageSize(t, thumbnail)
+	assert.Equal(t, 50, width)
+	assert.Equal(t, 25, height)
+}
+
+func TestRenderThumbnailDoesNotUpscale(t *testing.T) {
+	thumbnail, err := renderThumbnail(encodeTestPNG(t, 40, 30), 320)
+	require.NoError(t, err)
+
+	width, height := decodeTestImageSize(t, thumbnail)
+	assert.Equal(t, 40, width)
+	assert.Equal(t, 30, height)
+}
+
+func TestRenderThumbnailAppliesOrientationAndDropsExif(t *testing.T) {
+	source := encodeTestJPEGWithExif(t, 200, 100, 6)
+	require.Equal(t, 6, readJPEGOrientation(source))
+
+	thumbnail, err := renderThumbnail(source, 50)
+	require.NoError(t, err)
+
+	width, height := decodeTestImageSize(t, thumbnail)
+	assert.Equal(t, 50, width)
+	assert.Equal(t, 100, height)
+	assert.False(t, bytes.Contains(thumbnail, []byte("Exif")))
+}
+
+func TestRenderThumbnailInvalidImage(t *testing.T) {
+	_, err := renderThumbnail([]byte("not an image"), 320)
+
+	assert.Error(t, err)
+}
+
+func TestReadJPEGOrientationWithoutExif(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	buf := bytes.Buffer{}
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+
+	assert.Equal(t, 1, readJPEGOrientation(buf.Bytes()))
+	assert.Equal(t, 1, readJPEGOrientation([]byte("not a jpeg")))
+}
+
+func TestApplyOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	marker := color.RGBA{R: 255, A: 255}
+	img.Set(0, 0, marker)
+
+	cases := []struct {
+		orientation int
+		width       int
+		height      int
+		x           int
+		y           int
+	}{
+		{orientation: 1, width: 3, height: 2, x: 0, y: 0},
+		{orientation: 2, width: 3, height: 2, x: 2, y: 0},
+		{orientation: 3, width: 3, height: 2, x: 2, y: 1},
+		{orientation: 4, width: 3, height: 2, x: 0, y: 1},
+		{orientation: 5, width: 2, height: 3, x: 0, y: 0},
+		{orientation: 6, width: 2, height: 3, x: 1, y: 0},
+		{orientation: 7, width: 2, height: 3, x: 1, y: 2},
+		{orientation: 8, width: 2, height: 3, x: 0, y: 2},
+	}
+
+	for _, c := range cases {
+		oriented := applyOrientation(img, c.orientation)
+		assert.Equal(t, c.width, oriented.Bounds().Dx(), "orientation %d", c.orientation)
+		assert.Equal(t, c.height, oriented.Bounds().Dy(), "orientation %d", c.orientation)
+
+		r, g, b, a := oriented.At(c.x, c.y).RGBA()
+		assert.Equal(t, []uint32{0xffff, 0, 0, 0xffff}, []uint32{r, g, b, a}, "orientation %d", c.orientation)
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"orgnote/app/configs"
+	"orgnote/app/models"
+	"orgnote/app/repositories"
+	"orgnote/app/tools"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two factor authentication is not enabled")
+	ErrTwoFactorNotSetUp    = errors.New("two factor authentication setup is not started")
+	ErrTwoFactorRequired    = errors.New("two factor authentication is required")
+	ErrInvalidTwoFactorCode = errors.New("invalid two factor code")
+	ErrTwoFactorLocked      = errors.New("too many failed two factor attempts")
+)
+
+const (
+	twoFactorChallengeLifetime = 5 * time.Minute
+	// Challenge becomes invalid after several wrong codes. Wrong codes are also counted for the user,
+	// so codes can't be guessed by new challenges, verification is locked with growing delay after them
+	maxTwoFactorAttempts = 5
+	twoFactorLockout     = 30 * time.Second
+	maxTwoFactorLockout  = time.Hour
+	recoveryCodesCount   = 10
+	recoveryCodeLength   = 10
+)
+
+// TOTP second factor of login, it's checked after OAuth callback or password verification
+type TwoFactorService struct {
+	userRepository *repositories.UserRepository
+	config         configs.Config
+}
+
+func NewTwoFactorService(userRepository *repositories.UserRepository, config configs.Config) *TwoFactorService {
+	return &TwoFactorService{userRepository, config}
+}
+
+// Dashes and case are ignored, so codes could be typed the way they are shown
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Return plain codes formatted for the user and their hashes for storage
+func generateRecoveryCodes() ([]string, []models.RecoveryCode, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashedCodes := make([]models.RecoveryCode, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		secret, err := tools.GenerateTOTPSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(secret[:recoveryCodeLength])
+		hashedCode, err := tools.HashToken(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashedCodes = append(hashedCodes, models.RecoveryCode{Hash: hashedCode.Hash, Salt: hashedCode.Salt})
+	}
+	return codes, hashedCodes, nil
+}
+
+func findRecoveryCode(recoveryCodes []models.RecoveryCode, code string) *models.RecoveryCode {
+	code = normalizeRecoveryCode(code)
+	if len(code) != recoveryCodeLength {
+		return nil
+	}
+	for i := range recoveryCodes {
+		if tools.VerifyToken(code, recoveryCodes[i].Hash, recoveryCodes[i].Salt) {
+			return &recoveryCodes[i]
+		}
+	}
+	return nil
+}
+
+// Return time until verification is locked after the failed attempt, nil while attempts are left.
+// Lockout is doubled by every next failed attempt
+func getTwoFactorLockedUntil(failedAttempts int, now time.Time) *time.Time {
+	if failedAttempts < maxTwoFactorAttempts {
+		return nil
+	}
+	lockout := maxTwoFactorLockout
+	if shift := failedAttempts - maxTwoFactorAttempts; shift < 16 {
+		lockout = min(twoFactorLockout<<shift, maxTwoFactorLockout)
+	}
+	lockedUntil := now.Add(lockout)
+	return &lockedUntil
+}
+
+// Check code from authenticator app or recovery code, every code could be used once
+func (t *TwoFactorService) verifyCode(user *models.User, code string) error {
+	if !user.TwoFactor.IsEnabled() {
+		return ErrTwoFactorNotEnabled
+	}
+	now := time.Now()
+	if user.TwoFactor.IsLocked(now) {
+		return ErrTwoFactorLocked
+	}
+	// Attempt is counted as failed until the code is used
+	failedAttempts := user.TwoFactor.FailedAttempts
+	counted, err := t.userRepository.UseTwoFactorAttempt(user.ID, failedAttempts, getTwoFactorLockedUntil(failedAttempts+1, now), now)
+	if err != nil {
+		return err
+	}
+	if !counted {
+		return ErrTwoFactorLocked
+	}
+
+	if step, ok := tools.VerifyTOTP(user.TwoFactor.Secret, code, now); ok {
+		used, err := t.userRepository.UseTwoFactorStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	recoveryCode := findRecoveryCode(user.TwoFactor.RecoveryCodes, code)
+	if recoveryCode == nil {
+		return ErrInvalidTwoFactorCode
+	}
+	used, err := t.userRepository.UseRecoveryCode(user.ID, recoveryCode.Hash)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+func (t *TwoFactorService) GetStatus(user *models.User) *models.TwoFactorStatus {
+	status := &models.TwoFactorStatus{
+		Enabled:  user.TwoFactor.IsEnabled(),
+		Required: t.config.TwoFactorRequired,
+	}
+	if status.Enabled {
+		status.RecoveryCodesLeft = len(user.TwoFactor.RecoveryCodes)
+	}
+	return status
+}
+
+// Generate new secret for authenticator app, it's enabled after confirmation by code
+func (t *TwoFactorService) Setup(user *models.User) (*models.TwoFactorSetup, error) {
+	if user.TwoFactor.IsEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	secret, err := tools.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: setup: generate secret: %v", err)
+	}
+	saved, err := t.userRepository.SetTwoFactorSecret(user.ID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: setup: %v", err)
+	}
+	if !saved {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.NickName
+	}
+	return &models.TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: tools.TOTPProvisioningURI(t.config.TwoFactorIssuer, account, secret),
+	}, nil
+}
+
+// Confirm secret by code from authenticator app, recovery codes are returned once
+func (t *TwoFactorService) Enable(user *models.User, code string) (*models.RecoveryCodes, error) {
+	if user.TwoFactor.IsEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TwoFactor == nil || user.TwoFactor.Secret == "" {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	step, ok := tools.VerifyTOTP(user.TwoFactor.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: enable: generate recovery codes: %v", err)
+	}
+	// Secret could be replaced by concurrent setup
+	enabled, err := t.userRepository.EnableTwoFactor(user.ID, user.TwoFactor.Secret, step, hashedCodes)
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: enable: %v", err)
+	}
+	if !enabled {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	return &models.RecoveryCodes{Codes: codes}, nil
+}
+
+func (t *TwoFactorService) Disable(user *models.User, code string) error {
+	if t.config.TwoFactorRequired {
+		return ErrTwoFactorRequired
+	}
+	if err := t.verifyCode(user, code); err != nil {
+		return wrapTwoFactorCodeError("disable", err)
+	}
+	if err := t.userRepository.DisableTwoFactor(user.ID); err != nil {
+		return fmt.Errorf("two factor service: disable: %v", err)
+	}
+	return nil
+}
+
+// Replace all recovery codes, previous codes become invalid
+func (t *TwoFactorService) RegenerateRecoveryCodes(user *models.User, code string) (*models.RecoveryCodes, error) {
+	if err := t.verifyCode(user, code); err != nil {
+		return nil, wrapTwoFactorCodeError("regenerate recovery codes", err)
+	}
+	codes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: regenerate recovery codes: generate: %v", err)
+	}
+	if err := t.userRepository.SetRecoveryCodes(user.ID, hashedCodes); err != nil {
+		return nil, fmt.Errorf("two factor service: regenerate recovery codes: %v", err)
+	}
+	return &models.RecoveryCodes{Codes: codes}, nil
+}
+
+// Start setup of authenticator app during login, when two factor authentication is required by the instance
+func (t *TwoFactorService) SetupChallenge(challengeToken string) (*models.TwoFactorSetup, error) {
+	actionToken, err := t.userRepository.FindActionToken(models.UserActionTwoFactor, challengeToken)
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: setup challenge: %v", err)
+	}
+	if actionToken == nil {
+		return nil, ErrInvalidActionToken
+	}
+	user, err := t.userRepository.GetByID(actionToken.UserID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: setup challenge: %v", err)
+	}
+	return t.Setup(user)
+}
+
+// Verify code of the challenge and create session. When authenticator app was set up during login,
+// two factor authentication is enabled and recovery codes are returned with the session
+func (t *TwoFactorService) CompleteChallenge(challengeToken string, code string, params LoginParams) (*models.AuthSession, error) {
+	actionToken, err := t.userRepository.UseActionTokenAttempt(models.UserActionTwoFactor, challengeToken, maxTwoFactorAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: complete challenge: %v", err)
+	}
+	if actionToken == nil {
+		return nil, ErrInvalidActionToken
+	}
+	user, err := t.userRepository.GetByID(actionToken.UserID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: complete challenge: %v", err)
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+
+	var recoveryCodes *models.RecoveryCodes
+	if user.TwoFactor.IsEnabled() {
+		err = wrapTwoFactorCodeError("complete challenge", t.verifyCode(user, code))
+	} else {
+		recoveryCodes, err = t.Enable(user, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Challenge could be completed by concurrent request
+	deleted, err := t.userRepository.DeleteActionToken(actionToken.ID)
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: complete challenge: %v", err)
+	}
+	if !deleted {
+		return nil, ErrInvalidActionToken
+	}
+
+	session, err := createSession(t.userRepository, t.config, user, params)
+	if err != nil {
+		return nil, fmt.Errorf("two factor service: complete challenge: %v", err)
+	}
+	session.User.TwoFactorEnabled = true
+	if recoveryCodes != nil {
+		session.RecoveryCodes = recoveryCodes.Codes
+	}
+	return session, nil
+}
+
+// Keep known errors of code verification unwrapped, so they could be checked by handlers
+func wrapTwoFactorCodeError(operation string, err error) error {
+	if err == nil || errors.Is(err, ErrInvalidTwoFactorCode) || errors.Is(err, ErrTwoFactorNotEnabled) || errors.Is(err, ErrTwoFactorLocked) {
+		return err
+	}
+	return fmt.Errorf("two factor service: %s: verify code: %v", operation, err)
+}
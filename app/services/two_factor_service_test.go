@@ -0,0 +1,102 @@
+package services
+
+import (
+	"orgnote/app/configs"
+	"orgnote/app/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashedCodes, err := generateRecoveryCodes()
+	require.NoError(t, err)
+
+	assert.Len(t, codes, recoveryCodesCount)
+	assert.Len(t, hashedCodes, recoveryCodesCount)
+	for i, code := range codes {
+		assert.Len(t, code, recoveryCodeLength+1)
+		assert.Equal(t, "-", code[recoveryCodeLength/2:recoveryCodeLength/2+1])
+		assert.NotContains(t, hashedCodes[i].Hash, normalizeRecoveryCode(code))
+	}
+}
+
+func TestFindRecoveryCode(t *testing.T) {
+	codes, hashedCodes, err := generateRecoveryCodes()
+	require.NoError(t, err)
+
+	found := findRecoveryCode(hashedCodes, codes[3])
+	require.NotNil(t, found)
+	assert.Equal(t, hashedCodes[3].Hash, found.Hash)
+
+	found = findRecoveryCode(hashedCodes, " "+strings.ToUpper(strings.ReplaceAll(codes[5], "-", ""))+" ")
+	require.NotNil(t, found)
+	assert.Equal(t, hashedCodes[5].Hash, found.Hash)
+
+	assert.Nil(t, findRecoveryCode(hashedCodes, "aaaaa-aaaaa"))
+	assert.Nil(t, findRecoveryCode(hashedCodes, ""))
+}
+
+func TestGetTwoFactorStatus(t *testing.T) {
+	service := NewTwoFactorService(nil, configs.Config{TwoFactorRequired: true})
+	user := &models.User{TwoFactor: &models.TwoFactor{
+		Enabled:       true,
+		RecoveryCodes: []models.RecoveryCode{{}, {}},
+	}}
+
+	assert.Equal(t, &models.TwoFactorStatus{Enabled: true, Required: true, RecoveryCodesLeft: 2}, service.GetStatus(user))
+	assert.Equal(t, &models.TwoFactorStatus{Required: true}, service.GetStatus(&models.User{}))
+}
+
+func TestEnableTwoFactorErrors(t *testing.T) {
+	service := NewTwoFactorService(nil, configs.Config{})
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	_, err := service.Enable(&models.User{TwoFactor: &models.TwoFactor{Enabled: true, Secret: secret}}, "000000")
+	assert.ErrorIs(t, err, ErrTwoFactorEnabled)
+
+	_, err = service.Enable(&models.User{}, "000000")
+	assert.ErrorIs(t, err, ErrTwoFactorNotSetUp)
+
+	_, err = service.Enable(&models.User{TwoFactor: &models.TwoFactor{Secret: secret}}, "12345")
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+}
+
+func TestDisableTwoFactorRequired(t *testing.T) {
+	service := NewTwoFactorService(nil, configs.Config{TwoFactorRequired: true})
+
+	err := service.Disable(&models.User{TwoFactor: &models.TwoFactor{Enabled: true}}, "000000")
+
+	assert.ErrorIs(t, err, ErrTwoFactorRequired)
+}
+
+func TestVerifyTwoFactorCodeNotEnabled(t *testing.T) {
+	service := NewTwoFactorService(nil, configs.Config{})
+
+	err := service.verifyCode(&models.User{TwoFactor: &models.TwoFactor{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}}, "000000")
+
+	assert.ErrorIs(t, err, ErrTwoFactorNotEnabled)
+}
+
+func TestGetTwoFactorLockedUntil(t *testing.T) {
+	now := time.Now()
+
+	assert.Nil(t, getTwoFactorLockedUntil(0, now))
+	assert.Nil(t, getTwoFactorLockedUntil(maxTwoFactorAttempts-1, now))
+	assert.Equal(t, now.Add(twoFactorLockout), *getTwoFactorLockedUntil(maxTwoFactorAttempts, now))
+	assert.Equal(t, now.Add(4*twoFactorLockout), *getTwoFactorLockedUntil(maxTwoFactorAttempts+2, now))
+	assert.Equal(t, now.Add(maxTwoFactorLockout), *getTwoFactorLockedUntil(maxTwoFactorAttempts+10, now))
+	assert.Equal(t, now.Add(maxTwoFactorLockout), *getTwoFactorLockedUntil(maxTwoFactorAttempts+100, now))
+}
+
+func TestVerifyTwoFactorCodeLocked(t *testing.T) {
+	service := NewTwoFactorService(nil, configs.Config{})
+	lockedUntil := time.Now().Add(time.Minute)
+
+	err := service.verifyCode(&models.User{TwoFactor: &models.TwoFactor{Enabled: true, LockedUntil: &lockedUntil}}, "000000")
+
+	assert.ErrorIs(t, err, ErrTwoFactorLocked)
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"orgnote/app/models"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxFileNameLength = 255
+	sniffLength       = 512
+)
+
+var ErrFileTypeNotAllowed = errors.New("file type is not allowed")
+
+const (
+	UploadErrorInvalidName    = "invalid_name"
+	UploadErrorTypeNotAllowed = "type_not_allowed"
+	UploadErrorQuotaExceeded  = "quota_exceeded"
+	UploadErrorInternal       = "internal_error"
+)
+
+// Keep only base name of client provided file name, so it can't point outside of user files.
+// Empty string is returned when nothing valid is left
+func sanitizeFileName(fileName string) string {
+	fileName = strings.ReplaceAll(fileName, "\\", "/")
+	fileName = filepath.Base(fileName)
+	fileName = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, fileName)
+	fileName = strings.TrimSpace(fileName)
+
+	if len(fileName) > maxFileNameLength || !isValidFileName(fileName) || strings.Trim(fileName, ".") == "" {
+		return ""
+	}
+	return fileName
+}
+
+// Return MIME type detected by content, parameters like charset are dropped
+func sniffContentType(head []byte) string {
+	mimeType := http.DetectContentType(head)
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+	return mediaType
+}
+
+// Read beginning of the content for type detection, returned reader still contains the whole content
+func sniffReader(content io.Reader) (string, io.Reader, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", nil, err
+	}
+	head = head[:n]
+	return sniffContentType(head), io.MultiReader(bytes.NewReader(head), content), nil
+}
+
+func isMimeTypeAllowed(allowedTypes []string, mimeType string) bool {
+	for _, allowedType := range allowedTypes {
+		if allowedType == "*" || allowedType == mimeType {
+			return true
+		}
+		if strings.HasSuffix(allowedType, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(allowedType, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func majorMimeType(mimeType string) string {
+	major, _, _ := strings.Cut(mimeType, "/")
+	return major
+}
+
+// Return MIME type of stored file. Type detected by content should be allowed,
+// type by extension is used only when it's allowed and agrees with the content
+func resolveUploadMimeType(allowedTypes []string, fileName string, sniffedType string) (string, error) {
+	if !isMimeTypeAllowed(allowedTypes, sniffedType) {
+		return "", ErrFileTypeNotAllowed
+	}
+
+	extensionType := getMimeType(fileName)
+	if mediaType, _, err := mime.ParseMediaType(extensionType); err == nil {
+		extensionType = mediaType
+	}
+	if isMimeTypeAllowed(allowedTypes, extensionType) && majorMimeType(extensionType) == majorMimeType(sniffedType) {
+		return extensionType, nil
+	}
+	return sniffedType, nil
+}
+
+func uploadErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidFileName):
+		return UploadErrorInvalidName
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return UploadErrorTypeNotAllowed
+	case errors.Is(err, ErrNoSpaceLeft):
+		return UploadErrorQuotaExceeded
+	}
+	return UploadErrorInternal
+}
+
+func newUploadResult(originalName string, fileName string, size int64, err error) models.UploadResult {
+	result := models.UploadResult{
+		OriginalName: originalName,
+		Name:         fileName,
+		Size:         size,
+		Uploaded:     err == nil,
+	}
+	if err != nil {
+		result.Error = uploadErrorCode(err)
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	cases := map[string]string{
+		"image.png":              "image.png",
+		"../../etc/passwd":       "passwd",
+		"..\\..\\windows\\a.jpg": "a.jpg",
+		"dir/sub/file.pdf":       "file.pdf",
+		"  spaced name.png ":     "spaced name.png",
+		"bad\x00name\n.png":      "badname.png",
+		"..":                     "",
+		"...":                    "",
+		"/":                      "",
+		"":                       "",
+		strings.Repeat("a", 256): "",
+	}
+
+	for fileName, expected := range cases {
+		assert.Equal(t, expected, sanitizeFileName(fileName), fileName)
+	}
+}
+
+func TestIsMimeTypeAllowed(t *testing.T) {
+	allowedTypes := []string{"image/*", "application/pdf"}
+
+	assert.True(t, isMimeTypeAllowed(allowedTypes, "image/png"))
+	assert.True(t, isMimeTypeAllowed(allowedTypes, "application/pdf"))
+	assert.False(t, isMimeTypeAllowed(allowedTypes, "application/zip"))
+	assert.False(t, isMimeTypeAllowed(allowedTypes, "imagex/png"))
+	assert.True(t, isMimeTypeAllowed([]string{"*"}, "application/x-msdownload"))
+	assert.False(t, isMimeTypeAllowed(nil, "image/png"))
+}
+
+func TestResolveUploadMimeType(t *testing.T) {
+	allowedTypes := []string{"image/*", "text/plain", "application/pdf"}
+
+	mimeType, err := resolveUploadMimeType(allowedTypes, "photo.png", "image/png")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+
+	mimeType, err = resolveUploadMimeType(allowedTypes, "page.html", "text/plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", mimeType)
+
+	mimeType, err = resolveUploadMimeType(allowedTypes, "image.svg", "text/plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", mimeType)
+
+	_, err = resolveUploadMimeType(allowedTypes, "photo.png", "application/x-msdownload")
+	assert.ErrorIs(t, err, ErrFileTypeNotAllowed)
+}
+
+func TestSniffReader(t *testing.T) {
+	content := "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 1000)
+
+	mimeType, reader, err := sniffReader(strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(data))
+}
+
+func TestSniffReaderShortContent(t *testing.T) {
+	mimeType, reader, err := sniffReader(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", mimeType)
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestUploadErrorCode(t *testing.T) {
+	assert.Equal(t, UploadErrorInvalidName, uploadErrorCode(ErrInvalidFileName))
+	assert.Equal(t, UploadErrorTypeNotAllowed, uploadErrorCode(fmt.Errorf("wrapped: %w", ErrFileTypeNotAllowed)))
+	assert.Equal(t, UploadErrorQuotaExceeded, uploadErrorCode(ErrNoSpaceLeft))
+	assert.Equal(t, UploadErrorInternal, uploadErrorCode(errors.New("storage is not available")))
+}
+
+func TestNewUploadResult(t *testing.T) {
+	result := newUploadResult("../image.png", "image.png", 10, nil)
+	assert.True(t, result.Uploaded)
+	assert.Empty(t, result.Error)
+
+	result = newUploadResult("..", "", 10, ErrInvalidFileName)
+	assert.False(t, result.Uploaded)
+	assert.Equal(t, UploadErrorInvalidName, result.Error)
+}
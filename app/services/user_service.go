@@ -1,34 +1,61 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"orgnote/app/configs"
 	"orgnote/app/infrastructure"
 	subscription "orgnote/app/infrastructure/generated"
 	"orgnote/app/models"
 	"orgnote/app/repositories"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/oapi-codegen/runtime/types"
 	"github.com/rs/zerolog/log"
+	"github.com/thoas/go-funk"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var (
+	ErrInvalidTokenScope      = errors.New("invalid token scope")
+	ErrInvalidTokenExpiration = errors.New("token expiration time should be in the future")
+	ErrIdentityLinked         = errors.New("identity is linked to another user")
+	ErrIdentityNotFound       = errors.New("identity not found")
+	ErrLastIdentity           = errors.New("the last identity could not be removed")
+	ErrUserDisabled           = errors.New("user is disabled")
+)
+
+// Time to complete OAuth flow of linking identity
+const linkIdentityTokenLifetime = 10 * time.Minute
+
 type UserService struct {
 	userRepository  *repositories.UserRepository
 	noteRepository  *repositories.NoteRepository
 	subscriptionAPI *infrastructure.SubscriptionAPI
+	config          configs.Config
 }
 
-func NewUserService(userRepository *repositories.UserRepository, noteRepository *repositories.NoteRepository, subscriptionAPI *infrastructure.SubscriptionAPI) *UserService {
-	return &UserService{userRepository, noteRepository, subscriptionAPI}
+func NewUserService(userRepository *repositories.UserRepository, noteRepository *repositories.NoteRepository, subscriptionAPI *infrastructure.SubscriptionAPI, config configs.Config) *UserService {
+	return &UserService{userRepository, noteRepository, subscriptionAPI, config}
 }
 
-func (u *UserService) Login(user models.User) (*models.User, error) {
+// Create or update user of OAuth provider and start new session of the device
+func (u *UserService) Login(user models.User, params LoginParams) (*models.AuthSession, error) {
 	log.Info().Msgf("Login user: %v", user)
 	createdUser, err := u.userRepository.CreateOrGet(user)
 	if err != nil {
 		return nil, fmt.Errorf("user service: login: %v", err)
 	}
-	return createdUser, nil
+	if createdUser.Disabled {
+		return nil, ErrUserDisabled
+	}
+	// Access token of OAuth provider is not used, so the session doesn't depend on its format and lifetime
+	session, err := startSession(u.userRepository, u.config, createdUser, params)
+	if err != nil {
+		return nil, fmt.Errorf("user service: login: %v", err)
+	}
+	return session, nil
 }
 
 func (u *UserService) GetAPITokens(userID string) ([]models.APIToken, error) {
@@ -36,6 +63,9 @@ func (u *UserService) GetAPITokens(userID string) ([]models.APIToken, error) {
 	if err != nil {
 		return nil, fmt.Errorf("user service: get: %v", err)
 	}
+	for i := range tokens {
+		tokens[i].Scopes = tokens[i].GetScopes()
+	}
 	return tokens, nil
 }
 
@@ -47,14 +77,64 @@ func (u *UserService) FindUser(token string) (*models.UserPersonalInfo, error) {
 	return mapToUserPersonalInfo(user), nil
 }
 
-func (u *UserService) CreateToken(user *models.User) (*models.APIToken, error) {
-	token, err := u.userRepository.CreateAPIToken(user)
+type CreateAPITokenParams struct {
+	Name      string
+	Scopes    []string // Default scopes are used when it's empty
+	ExpiresAt *time.Time
+}
+
+// Validate requested scopes and remove duplicates
+func normalizeTokenScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return append([]string{}, models.DefaultAPITokenScopes...), nil
+	}
+
+	normalizedScopes := []string{}
+	for _, scope := range scopes {
+		if !funk.ContainsString(models.APITokenScopes, scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTokenScope, scope)
+		}
+		if !funk.ContainsString(normalizedScopes, scope) {
+			normalizedScopes = append(normalizedScopes, scope)
+		}
+	}
+	return normalizedScopes, nil
+}
+
+func (u *UserService) CreateToken(user *models.User, params CreateAPITokenParams) (*models.APIToken, error) {
+	scopes, err := normalizeTokenScopes(params.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if params.ExpiresAt != nil && !params.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidTokenExpiration
+	}
+
+	token, err := u.userRepository.CreateAPIToken(user, params.Name, scopes, params.ExpiresAt)
 	if err != nil {
 		return nil, fmt.Errorf("user service: create token: %v", err)
 	}
 	return token, nil
 }
 
+// Usage is saved not more often than once per interval when ip is not changed
+const apiTokenUsageInterval = time.Minute
+
+func shouldTouchAPIToken(token *models.APIToken, ip string, now time.Time) bool {
+	return token.LastUsedAt == nil || token.LastUsedIP != ip || now.Sub(*token.LastUsedAt) >= apiTokenUsageInterval
+}
+
+func (u *UserService) TouchAPIToken(user *models.User, token *models.APIToken, ip string) {
+	now := time.Now()
+	if !shouldTouchAPIToken(token, ip, now) {
+		return
+	}
+	err := u.userRepository.TouchAPIToken(user.ID, token.ID, ip, now)
+	if err != nil {
+		log.Error().Err(err).Msg("user service: touch api token")
+	}
+}
+
 func (u *UserService) DeleteToken(user *models.User, tokenID string) error {
 	err := u.userRepository.DeleteAPIToken(user, tokenID)
 	if err != nil {
@@ -63,6 +143,84 @@ func (u *UserService) DeleteToken(user *models.User, tokenID string) error {
 	return nil
 }
 
+// Create one time token which is passed through OAuth state to the callback of linked provider
+func (u *UserService) CreateLinkIdentityToken(user *models.User) (string, error) {
+	token, err := u.userRepository.CreateActionToken(user.ID, models.UserActionLinkIdentity, time.Now().Add(linkIdentityTokenLifetime))
+	if err != nil {
+		return "", fmt.Errorf("user service: create link identity token: %v", err)
+	}
+	return token, nil
+}
+
+// Attach identity of provider user to the user who created the link token
+func (u *UserService) LinkIdentity(linkToken string, providerUser models.User) (*models.Identity, error) {
+	actionToken, err := u.userRepository.ConsumeActionToken(models.UserActionLinkIdentity, linkToken)
+	if err != nil {
+		return nil, fmt.Errorf("user service: link identity: consume token: %v", err)
+	}
+	if actionToken == nil {
+		return nil, ErrInvalidActionToken
+	}
+
+	owner, err := u.userRepository.GetUser(&models.User{Provider: providerUser.Provider, ExternalID: providerUser.ExternalID})
+	if err != nil {
+		return nil, fmt.Errorf("user service: link identity: get identity owner: %v", err)
+	}
+	identity, err := findLinkedIdentity(owner, actionToken.UserID, providerUser)
+	if err != nil || identity != nil {
+		return identity, err
+	}
+
+	// Identity could be linked by concurrent request after the owner check
+	identity, err = u.userRepository.AddIdentity(actionToken.UserID, providerUser)
+	if errors.Is(err, repositories.ErrIdentityLinked) {
+		return nil, ErrIdentityLinked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user service: link identity: %v", err)
+	}
+	// User was removed after the link token was created
+	if identity == nil {
+		return nil, ErrInvalidActionToken
+	}
+	return identity, nil
+}
+
+// Return identity when it's already attached to the linking user,
+// the identity attached to another user could not be linked
+func findLinkedIdentity(owner *models.User, userID primitive.ObjectID, providerUser models.User) (*models.Identity, error) {
+	if owner == nil {
+		return nil, nil
+	}
+	if owner.ID != userID {
+		return nil, ErrIdentityLinked
+	}
+	return owner.FindIdentity(providerUser.Provider, providerUser.ExternalID), nil
+}
+
+func (u *UserService) UnlinkIdentity(user *models.User, identityID string) error {
+	id, err := primitive.ObjectIDFromHex(identityID)
+	if err != nil {
+		return ErrIdentityNotFound
+	}
+	found := false
+	for _, identity := range user.Identities {
+		found = found || identity.ID == id
+	}
+	if !found {
+		return ErrIdentityNotFound
+	}
+
+	removed, err := u.userRepository.RemoveIdentity(user.ID, id)
+	if err != nil {
+		return fmt.Errorf("user service: unlink identity: %v", err)
+	}
+	if !removed {
+		return ErrLastIdentity
+	}
+	return nil
+}
+
 func (u *UserService) DeleteUser(user *models.User) error {
 	err := u.userRepository.DeleteUser(user.ID.Hex())
 	if err != nil {
@@ -103,6 +261,10 @@ func (u *UserService) Subscribe(user *models.User, token string, emailAddress *s
 		return fmt.Errorf("user service: subscribe: set active status: %v", err)
 	}
 
+	// Limit set by admin has priority over subscription
+	if user.SpaceLimitOverride {
+		return nil
+	}
 	spaceLimit := int64(*data.SpaceLimit)
 
 	err = u.userRepository.UpdateSpaceLimitInfo(user.ID.Hex(), nil, &spaceLimit)
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeTokenScopes(t *testing.T) {
+	scopes, err := normalizeTokenScopes([]string{models.ScopeNotesRead, models.ScopePublish, models.ScopeNotesRead})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{models.ScopeNotesRead, models.ScopePublish}, scopes)
+}
+
+func TestNormalizeTokenScopesDefault(t *testing.T) {
+	scopes, err := normalizeTokenScopes(nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.DefaultAPITokenScopes, scopes)
+	assert.NotContains(t, scopes, models.ScopeAccount)
+}
+
+func TestNormalizeTokenScopesUnknown(t *testing.T) {
+	_, err := normalizeTokenScopes([]string{models.ScopeNotesRead, "admin"})
+
+	assert.ErrorIs(t, err, ErrInvalidTokenScope)
+}
+
+func TestAPITokenHasScope(t *testing.T) {
+	token := models.APIToken{Scopes: []string{models.ScopeNotesRead}}
+	legacyToken := models.APIToken{}
+
+	assert.True(t, token.HasScope(models.ScopeNotesRead))
+	assert.False(t, token.HasScope(models.ScopeNotesWrite))
+	assert.True(t, legacyToken.HasScope(models.ScopeNotesWrite))
+	assert.False(t, legacyToken.HasScope(models.ScopeAccount))
+}
+
+func TestShouldTouchAPIToken(t *testing.T) {
+	now := time.Now()
+	recently := now.Add(-10 * time.Second)
+	longAgo := now.Add(-2 * apiTokenUsageInterval)
+
+	assert.True(t, shouldTouchAPIToken(&models.APIToken{}, "10.0.0.1", now))
+	assert.False(t, shouldTouchAPIToken(&models.APIToken{LastUsedAt: &recently, LastUsedIP: "10.0.0.1"}, "10.0.0.1", now))
+	assert.True(t, shouldTouchAPIToken(&models.APIToken{LastUsedAt: &recently, LastUsedIP: "10.0.0.1"}, "10.0.0.2", now))
+	assert.True(t, shouldTouchAPIToken(&models.APIToken{LastUsedAt: &longAgo, LastUsedIP: "10.0.0.1"}, "10.0.0.1", now))
+}
+
+func TestUnlinkIdentityNotFound(t *testing.T) {
+	userService := &UserService{}
+	user := &models.User{Identities: []models.Identity{{ID: primitive.NewObjectID(), Provider: "github"}}}
+
+	assert.ErrorIs(t, userService.UnlinkIdentity(user, "invalid"), ErrIdentityNotFound)
+	assert.ErrorIs(t, userService.UnlinkIdentity(user, primitive.NewObjectID().Hex()), ErrIdentityNotFound)
+}
+
+func TestFindLinkedIdentity(t *testing.T) {
+	userID := primitive.NewObjectID()
+	identity := models.Identity{ID: primitive.NewObjectID(), Provider: "github", ExternalID: "42"}
+	owner := &models.User{ID: userID, Identities: []models.Identity{{ID: primitive.NewObjectID(), Provider: "local", ExternalID: "1"}, identity}}
+	providerUser := models.User{Provider: "github", ExternalID: "42"}
+
+	linked, err := findLinkedIdentity(nil, userID, providerUser)
+	assert.NoError(t, err)
+	assert.Nil(t, linked, "not linked identity should be added")
+
+	linked, err = findLinkedIdentity(owner, userID, providerUser)
+	assert.NoError(t, err)
+	assert.Equal(t, &identity, linked, "already linked identity should be returned")
+
+	linked, err = findLinkedIdentity(owner, primitive.NewObjectID(), providerUser)
+	assert.ErrorIs(t, err, ErrIdentityLinked)
+	assert.Nil(t, linked)
+}
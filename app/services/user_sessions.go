@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"orgnote/app/configs"
+	"orgnote/app/models"
+	"orgnote/app/repositories"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Device info saved with the session
+type LoginParams struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+// Long values are cut, they are provided by client
+const maxSessionFieldLength = 256
+
+func truncateSessionField(value string) string {
+	if len(value) <= maxSessionFieldLength {
+		return value
+	}
+	// Don't split multibyte character
+	end := maxSessionFieldLength
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[:end]
+}
+
+func newSessionParams(params LoginParams, expiresAt time.Time) repositories.SessionParams {
+	return repositories.SessionParams{
+		Name:      truncateSessionField(params.DeviceName),
+		UserAgent: truncateSessionField(params.UserAgent),
+		IP:        params.IP,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func createSession(userRepository *repositories.UserRepository, config configs.Config, user *models.User, params LoginParams) (*models.AuthSession, error) {
+	token, session, err := userRepository.CreateSession(user.ID, newSessionParams(params, time.Now().Add(config.SessionLifetime)))
+	if err != nil {
+		return nil, err
+	}
+	// Only hash of the token is stored, plain token is returned to the client once
+	return &models.AuthSession{
+		Token:     token,
+		ExpiresAt: &session.ExpiresAt,
+		User:      mapToUserPersonalInfo(user),
+	}, nil
+}
+
+// Create session of the device after the first login step,
+// two factor challenge is returned instead when the second factor is required
+func startSession(userRepository *repositories.UserRepository, config configs.Config, user *models.User, params LoginParams) (*models.AuthSession, error) {
+	twoFactorEnabled := user.TwoFactor.IsEnabled()
+	if !twoFactorEnabled && !config.TwoFactorRequired {
+		return createSession(userRepository, config, user, params)
+	}
+	expiresAt := time.Now().Add(twoFactorChallengeLifetime)
+	token, err := userRepository.CreateActionToken(user.ID, models.UserActionTwoFactor, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuthSession{
+		TwoFactorChallenge: &models.TwoFactorChallenge{
+			Token:         token,
+			ExpiresAt:     expiresAt,
+			SetupRequired: !twoFactorEnabled,
+		},
+	}, nil
+}
+
+// Return not expired sessions, the most recently used first
+func listSessions(sessions []models.Session, current *models.Session, now time.Time) []models.Session {
+	activeSessions := []models.Session{}
+	for _, session := range sessions {
+		if session.IsExpired(now) {
+			continue
+		}
+		session.Current = current != nil && session.ID == current.ID
+		activeSessions = append(activeSessions, session)
+	}
+	sort.SliceStable(activeSessions, func(i, j int) bool {
+		return activeSessions[i].LastSeenAt.After(activeSessions[j].LastSeenAt)
+	})
+	return activeSessions
+}
+
+func (u *UserService) GetSessions(user *models.User, current *models.Session) []models.Session {
+	return listSessions(user.Sessions, current, time.Now())
+}
+
+// Last seen time is saved not more often than once per interval when ip is not changed
+const sessionUsageInterval = time.Minute
+
+func shouldTouchSession(session *models.Session, ip string, now time.Time) bool {
+	return session.LastSeenIP != ip || now.Sub(session.LastSeenAt) >= sessionUsageInterval
+}
+
+func (u *UserService) TouchSession(user *models.User, session *models.Session, ip string) {
+	now := time.Now()
+	if !shouldTouchSession(session, ip, now) {
+		return
+	}
+	err := u.userRepository.TouchSession(user.ID, session.ID, ip, now)
+	if err != nil {
+		log.Error().Err(err).Msg("user service: touch session")
+	}
+}
+
+// Replace token of the session, previous token becomes invalid
+func (u *UserService) RotateSession(user *models.User, session *models.Session) (*models.SessionToken, error) {
+	expiresAt := time.Now().Add(u.config.SessionLifetime)
+	token, err := u.userRepository.RotateSession(user.ID, session.ID, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("user service: rotate session: %v", err)
+	}
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+	return &models.SessionToken{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+func (u *UserService) DeleteSession(user *models.User, sessionID string) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	deleted, err := u.userRepository.DeleteSession(user.ID, id)
+	if err != nil {
+		return fmt.Errorf("user service: delete session: %v", err)
+	}
+	if !deleted {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Sign out on all devices, API tokens are not changed
+func (u *UserService) DeleteSessions(user *models.User) error {
+	err := u.userRepository.DeleteSessions(user.ID)
+	if err != nil {
+		return fmt.Errorf("user service: delete sessions: %v", err)
+	}
+	return nil
+}
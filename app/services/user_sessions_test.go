@@ -0,0 +1,55 @@
+package services
+
+import (
+	"orgnote/app/models"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestListSessions(t *testing.T) {
+	now := time.Now()
+	expired := models.Session{ID: primitive.NewObjectID(), LastSeenAt: now, ExpiresAt: now.Add(-time.Minute)}
+	old := models.Session{ID: primitive.NewObjectID(), LastSeenAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	recent := models.Session{ID: primitive.NewObjectID(), LastSeenAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}
+
+	sessions := listSessions([]models.Session{expired, old, recent}, &old, now)
+
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, recent.ID, sessions[0].ID)
+	assert.False(t, sessions[0].Current)
+	assert.Equal(t, old.ID, sessions[1].ID)
+	assert.True(t, sessions[1].Current)
+}
+
+func TestListSessionsWithoutCurrent(t *testing.T) {
+	now := time.Now()
+	session := models.Session{ID: primitive.NewObjectID(), LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	sessions := listSessions([]models.Session{session}, nil, now)
+
+	assert.Len(t, sessions, 1)
+	assert.False(t, sessions[0].Current)
+}
+
+func TestShouldTouchSession(t *testing.T) {
+	now := time.Now()
+	session := &models.Session{LastSeenAt: now.Add(-10 * time.Second), LastSeenIP: "10.0.0.1"}
+
+	assert.False(t, shouldTouchSession(session, "10.0.0.1", now))
+	assert.True(t, shouldTouchSession(session, "10.0.0.2", now))
+	assert.True(t, shouldTouchSession(session, "10.0.0.1", now.Add(sessionUsageInterval)))
+}
+
+func TestTruncateSessionField(t *testing.T) {
+	assert.Equal(t, "Firefox", truncateSessionField("Firefox"))
+	assert.Len(t, truncateSessionField(strings.Repeat("a", 300)), maxSessionFieldLength)
+
+	truncated := truncateSessionField("a" + strings.Repeat("я", 200))
+	assert.True(t, utf8.ValidString(truncated))
+	assert.LessOrEqual(t, len(truncated), maxSessionFieldLength)
+}
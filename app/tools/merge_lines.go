@@ -0,0 +1,144 @@
+package tools
+
+import "strings"
+
+// Upper bound for the LCS table, bigger documents are reported as conflicting
+const maxMergeMatrixSize = 4_000_000
+
+// MergeLines performs line based three-way merge of ours and theirs changes made over base.
+// Return false when the changes overlap and could not be merged automatically
+func MergeLines(base string, ours string, theirs string) (merged string, ok bool) {
+	if ours == theirs || base == theirs {
+		return ours, true
+	}
+	if base == ours {
+		return theirs, true
+	}
+
+	baseLines := strings.Split(base, "\n")
+	ourLines := strings.Split(ours, "\n")
+	theirLines := strings.Split(theirs, "\n")
+
+	ourMatches, ok := matchLines(baseLines, ourLines)
+	if !ok {
+		return "", false
+	}
+	theirMatches, ok := matchLines(baseLines, theirLines)
+	if !ok {
+		return "", false
+	}
+
+	result := []string{}
+	baseIdx, ourIdx, theirIdx := 0, 0, 0
+
+	for {
+		stable := baseIdx
+		for stable < len(baseLines) && (ourMatches[stable] == -1 || theirMatches[stable] == -1) {
+			stable++
+		}
+
+		ourEnd, theirEnd := len(ourLines), len(theirLines)
+		if stable < len(baseLines) {
+			ourEnd, theirEnd = ourMatches[stable], theirMatches[stable]
+		}
+
+		if stable == baseIdx && ourEnd == ourIdx && theirEnd == theirIdx {
+			if stable == len(baseLines) {
+				break
+			}
+			result = append(result, baseLines[stable])
+			baseIdx, ourIdx, theirIdx = stable+1, ourIdx+1, theirIdx+1
+			continue
+		}
+
+		baseChunk := baseLines[baseIdx:stable]
+		ourChunk := ourLines[ourIdx:ourEnd]
+		theirChunk := theirLines[theirIdx:theirEnd]
+
+		switch {
+		case equalLines(ourChunk, theirChunk), equalLines(baseChunk, theirChunk):
+			result = append(result, ourChunk...)
+		case equalLines(baseChunk, ourChunk):
+			result = append(result, theirChunk...)
+		default:
+			return "", false
+		}
+
+		baseIdx, ourIdx, theirIdx = stable, ourEnd, theirEnd
+	}
+
+	return strings.Join(result, "\n"), true
+}
+
+// Return index of matched line from target for each line of source using longest common subsequence.
+// Unmatched lines are marked as -1
+func matchLines(source []string, target []string) ([]int, bool) {
+	matches := make([]int, len(source))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	prefix := 0
+	for prefix < len(source) && prefix < len(target) && source[prefix] == target[prefix] {
+		matches[prefix] = prefix
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(source)-prefix && suffix < len(target)-prefix &&
+		source[len(source)-1-suffix] == target[len(target)-1-suffix] {
+		matches[len(source)-1-suffix] = len(target) - 1 - suffix
+		suffix++
+	}
+
+	src := source[prefix : len(source)-suffix]
+	trg := target[prefix : len(target)-suffix]
+
+	if len(src) == 0 || len(trg) == 0 {
+		return matches, true
+	}
+
+	if len(src)*len(trg) > maxMergeMatrixSize {
+		return nil, false
+	}
+
+	width := len(trg) + 1
+	lcs := make([]int32, (len(src)+1)*width)
+	for i := len(src) - 1; i >= 0; i-- {
+		for j := len(trg) - 1; j >= 0; j-- {
+			if src[i] == trg[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+				continue
+			}
+			lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(src) && j < len(trg) {
+		switch {
+		case src[i] == trg[j]:
+			matches[prefix+i] = prefix + j
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	return matches, true
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
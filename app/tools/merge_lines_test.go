@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeLines_NonOverlappingChanges(t *testing.T) {
+	base := "* Header\nfirst line\nsecond line\nthird line"
+	ours := "* Header\nfirst line changed\nsecond line\nthird line"
+	theirs := "* Header\nfirst line\nsecond line\nthird line changed\nnew line"
+
+	merged, ok := MergeLines(base, ours, theirs)
+
+	assert.True(t, ok)
+	assert.Equal(t, "* Header\nfirst line changed\nsecond line\nthird line changed\nnew line", merged)
+}
+
+func TestMergeLines_SameChanges(t *testing.T) {
+	base := "first\nsecond"
+	changed := "first\nsecond\nthird"
+
+	merged, ok := MergeLines(base, changed, changed)
+
+	assert.True(t, ok)
+	assert.Equal(t, changed, merged)
+}
+
+func TestMergeLines_OneSideChanged(t *testing.T) {
+	base := "first\nsecond"
+	changed := "first\nupdated"
+
+	merged, ok := MergeLines(base, base, changed)
+
+	assert.True(t, ok)
+	assert.Equal(t, changed, merged)
+}
+
+func TestMergeLines_Conflict(t *testing.T) {
+	base := "first\nsecond\nthird"
+	ours := "first\nours\nthird"
+	theirs := "first\ntheirs\nthird"
+
+	_, ok := MergeLines(base, ours, theirs)
+
+	assert.False(t, ok)
+}
+
+func TestMergeLines_DeletedAndInsertedLines(t *testing.T) {
+	base := "a\nb\nc\nd\ne"
+	ours := "a\nc\nd\ne"
+	theirs := "a\nb\nc\nd\nx\ne"
+
+	merged, ok := MergeLines(base, ours, theirs)
+
+	assert.True(t, ok)
+	assert.Equal(t, "a\nc\nd\nx\ne", merged)
+}
@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// Argon2id parameters, they are stored inside the hash so they could be changed later
+const (
+	passwordHashMemory  = 64 * 1024
+	passwordHashTime    = 3
+	passwordHashThreads = 2
+	passwordHashLength  = 32
+	passwordSaltLength  = 16
+)
+
+// Every hash allocates passwordHashMemory KiB, number of concurrent hashes is limited
+// so unauthenticated login and registration requests can't exhaust memory
+const maxConcurrentPasswordHashes = 4
+
+var passwordHashSlots = make(chan struct{}, maxConcurrentPasswordHashes)
+
+func deriveKey(password string, salt []byte, iterations uint32, memory uint32, threads uint8, length uint32) []byte {
+	passwordHashSlots <- struct{}{}
+	defer func() { <-passwordHashSlots }()
+	return argon2.IDKey([]byte(password), salt, iterations, memory, threads, length)
+}
+
+// Hash password with Argon2id, result is encoded in PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := deriveKey(password, salt, passwordHashTime, passwordHashMemory, passwordHashThreads, passwordHashLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		passwordHashMemory,
+		passwordHashTime,
+		passwordHashThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+func VerifyPassword(password string, encodedHash string) (bool, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrInvalidPasswordHash
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrInvalidPasswordHash
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return false, ErrInvalidPasswordHash
+	}
+
+	expectedHash := deriveKey(password, salt, iterations, memory, threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(expectedHash, hash) == 1, nil
+}
@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$"))
+	assert.NotContains(t, hash, "correct horse")
+
+	ok, err := VerifyPassword("correct horse battery staple", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword("wrong password", hash)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestHashPasswordUsesRandomSalt(t *testing.T) {
+	firstHash, err := HashPassword("password")
+	require.NoError(t, err)
+	secondHash, err := HashPassword("password")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, firstHash, secondHash)
+}
+
+func TestVerifyPasswordInvalidHash(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plain",
+		"$argon2i$v=19$m=65536,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=18$m=65536,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=2$!!!$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$",
+	} {
+		_, err := VerifyPassword("password", hash)
+		assert.ErrorIs(t, err, ErrInvalidPasswordHash, hash)
+	}
+}
@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// Length of the token beginning which is stored in plain text for lookup
+const TokenPrefixLength = 8
+
+type HashedToken struct {
+	Prefix string
+	Hash   string
+	Salt   string
+}
+
+func TokenPrefix(token string) string {
+	if len(token) < TokenPrefixLength {
+		return token
+	}
+	return token[:TokenPrefixLength]
+}
+
+func hashTokenWithSalt(token string, salt string) string {
+	hash := sha256.Sum256([]byte(salt + token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Hash token with random salt, so plain token is not stored
+func HashToken(token string) (HashedToken, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return HashedToken{}, err
+	}
+	encodedSalt := hex.EncodeToString(salt)
+	return HashedToken{
+		Prefix: TokenPrefix(token),
+		Hash:   hashTokenWithSalt(token, encodedSalt),
+		Salt:   encodedSalt,
+	}, nil
+}
+
+func VerifyToken(token string, hash string, salt string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+	expectedHash := hashTokenWithSalt(token, salt)
+	return subtle.ConstantTimeCompare([]byte(expectedHash), []byte(hash)) == 1
+}
+
+// Generate random token, it's returned to the client only once
+func GenerateToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashToken(t *testing.T) {
+	hashedToken, err := HashToken("0123456789abcdef")
+	require.NoError(t, err)
+
+	assert.Equal(t, "01234567", hashedToken.Prefix)
+	assert.NotContains(t, hashedToken.Hash, "0123456789abcdef")
+	assert.True(t, VerifyToken("0123456789abcdef", hashedToken.Hash, hashedToken.Salt))
+	assert.False(t, VerifyToken("0123456789abcdee", hashedToken.Hash, hashedToken.Salt))
+	assert.False(t, VerifyToken("", hashedToken.Hash, hashedToken.Salt))
+}
+
+func TestHashTokenUsesRandomSalt(t *testing.T) {
+	first, err := HashToken("token")
+	require.NoError(t, err)
+	second, err := HashToken("token")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first.Salt, second.Salt)
+	assert.NotEqual(t, first.Hash, second.Hash)
+	assert.Equal(t, "token", first.Prefix)
+}
+
+func TestGenerateToken(t *testing.T) {
+	first, err := GenerateToken()
+	require.NoError(t, err)
+	second, err := GenerateToken()
+	require.NoError(t, err)
+
+	assert.Len(t, first, 64)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Secret from RFC 6238 test vectors: "12345678901234567890"
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(rfcTOTPSecret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := VerifyTOTP(rfcTOTPSecret, "081804", now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+
+	step, ok = VerifyTOTP(rfcTOTPSecret, "081 804", now.Add(TOTPPeriod))
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+
+	_, ok = VerifyTOTP(rfcTOTPSecret, "081804", now.Add(3*TOTPPeriod))
+	assert.False(t, ok)
+	_, ok = VerifyTOTP(rfcTOTPSecret, "000000", now)
+	assert.False(t, ok)
+	_, ok = VerifyTOTP(rfcTOTPSecret, "", now)
+	assert.False(t, ok)
+	_, ok = VerifyTOTP("invalid secret!", "081804", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	assert.Len(t, secret, 32)
+	code, err := TOTPCode(secret, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, code, TOTPDigits)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Org Note", "user@example.com", rfcTOTPSecret)
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Org%20Note:user@example.com?"))
+	assert.Contains(t, uri, "secret="+rfcTOTPSecret)
+	assert.Contains(t, uri, "issuer=Org%20Note")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}
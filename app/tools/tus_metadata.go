@@ -0,0 +1,26 @@
+package tools
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Parse tus Upload-Metadata header, comma separated pairs of key and base64 encoded value
+func ParseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, encodedValue, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedValue))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of metadata key %s: %v", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,31 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	metadata, err := ParseTusMetadata("filename aW1hZ2UucG5n,filetype aW1hZ2UvcG5n, is_confidential")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"filename":        "image.png",
+		"filetype":        "image/png",
+		"is_confidential": "",
+	}, metadata)
+}
+
+func TestParseTusMetadata_Empty(t *testing.T) {
+	metadata, err := ParseTusMetadata("")
+
+	assert.NoError(t, err)
+	assert.Empty(t, metadata)
+}
+
+func TestParseTusMetadata_InvalidValue(t *testing.T) {
+	_, err := ParseTusMetadata("filename not-base64!")
+
+	assert.Error(t, err)
+}